@@ -1,12 +1,16 @@
 package touchosc
 
-import "github.com/kward/venue/internal/router"
+import (
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/router"
+)
 
 const TouchOSC = "TouchOSC"
 
 type Packer interface {
-	// init prepares the packer to parse the request `req`.
-	init(req *request)
+	// init prepares the packer to parse the request `req`, for a console with
+	// bus configuration `c`.
+	init(req *request, c buses.Config)
 	// done returns true when packing is complete.
 	done() bool
 
@@ -29,10 +33,11 @@ type Packer interface {
 type packerFn func() packerFn
 
 type packerT struct {
-	err error          // An error message, if present.
-	fn  packerFn       // The next packer state to enter.
-	req *request       // The request to pack.
-	pkt *router.Packet // The packet to pack.
+	err   error          // An error message, if present.
+	fn    packerFn       // The next packer state to enter.
+	req   *request       // The request to pack.
+	pkt   *router.Packet // The packet to pack.
+	buses buses.Config   // The console bus configuration.
 }
 
 var (
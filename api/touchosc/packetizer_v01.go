@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/kward/venue/api/touchosc/multistates"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/router/actions"
 	"github.com/kward/venue/internal/router/controls"
@@ -18,11 +19,12 @@ type packerV01 packerT
 // Verify that the expected interface is implemented properly.
 var _ Packer = new(packerV01)
 
-func (p *packerV01) init(req *request) {
+func (p *packerV01) init(req *request, c buses.Config) {
 	p.err = nil
 	p.fn = p.packByControl
 	p.req = req
 	p.pkt = &router.Packet{}
+	p.buses = c
 }
 func (p *packerV01) done() bool { return p.fn == nil }
 
@@ -235,6 +237,8 @@ func (p *packerV01) inputSolo() packerFn {
 	})
 }
 
+// multiToggle packs the state of a Multi-Toggle UI control. The packet value
+// is true when the toggle is on.
 func (p *packerV01) multiToggle(pkt *router.Packet) packerFn {
 	args := p.req.msg.Arguments
 	if len(args) != 1 {
@@ -244,7 +248,7 @@ func (p *packerV01) multiToggle(pkt *router.Packet) packerFn {
 	if state == multistates.Unknown {
 		return p.errorf("received invalid argument %v", args[0])
 	}
-	pkt.Value = state
+	pkt.Value = state == multistates.Pressed
 	p.setPacket(pkt)
 	return nil
 }
@@ -263,6 +267,8 @@ func (p *packerV01) output() packerFn {
 	switch p.req.command {
 	case "level":
 		return p.outputLevel
+	case "mute":
+		return p.outputMute
 	case "pan":
 		return p.outputPan
 	case "select":
@@ -289,7 +295,10 @@ func (p *packerV01) outputLevel() packerFn {
 		return p.errorf("received invalid argument %v", args[0])
 	}
 
-	sig, sigNo := venueAuxGroup(p.req)
+	sig, sigNo, err := venueAuxGroup(p.req, p.buses)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
 	clicks := clicks(p.req.x)
 	if clicks == 0 {
 		return p.errorf("invalid level control x/y: %d/%d", p.req.x, p.req.y)
@@ -306,6 +315,23 @@ func (p *packerV01) outputLevel() packerFn {
 	return nil
 }
 
+func (p *packerV01) outputMute() packerFn {
+	if glog.V(3) {
+		glog.Info(venuelib.FnName())
+	}
+
+	sig, sigNo, err := venueAuxGroup(p.req, p.buses)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	return p.multiToggle(&router.Packet{
+		Action:   actions.OutputMute,
+		Control:  controls.Mute,
+		Signal:   sig,
+		SignalNo: sigNo,
+	})
+}
+
 func (p *packerV01) outputPan() packerFn {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
@@ -330,7 +356,10 @@ func (p *packerV01) outputSelect() packerFn {
 		return p.errorf("received invalid argument %v", args[0])
 	}
 
-	sig, sigNo := venueAuxGroup(p.req)
+	sig, sigNo, err := venueAuxGroup(p.req, p.buses)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
 	p.setPacket(&router.Packet{
 		Action:   actions.SelectOutput,
 		Signal:   sig,
@@ -373,16 +402,14 @@ func clicks(x int) int {
 	}
 }
 
-// venueAugGroup converts request into a Control and position.
-// Note: a Bus Configuration of "16 Auxes + 8 Variable Groups (24 bus)" is
-// assumed.
-func venueAuxGroup(req *request) (signals.Signal, signals.SignalNo) {
-	sig := signals.Aux
-	sigNo := req.y
-	if req.y > 8 {
-		sig = signals.Group
-		sigNo = req.y - 8
-	}
-	sigNo = sigNo*2 - 1 // Convert position into stereo channel number.
-	return sig, (signals.SignalNo)(sigNo)
+// venueAuxGroup converts the position of request `req` into the send of bus
+// configuration `c` at that position, i.e. the aux or group bus, or the first
+// bus of a stereo pair. The sends are positioned auxes first, then groups.
+func venueAuxGroup(req *request, c buses.Config) (signals.Signal, signals.SignalNo, error) {
+	ss := c.Sends()
+	if req.y < 1 || req.y > len(ss) {
+		return signals.Unknown, 0, fmt.Errorf("no bus at output position %d of the %s bus configuration", req.y, c)
+	}
+	s := ss[req.y-1]
+	return s.Signal, s.SignalNo, nil
 }
@@ -2,9 +2,11 @@ package touchosc
 
 import (
 	"flag"
+	"os"
 	"testing"
 
 	"github.com/kward/go-osc/osc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/router/actions"
 	"github.com/kward/venue/internal/router/controls"
@@ -18,6 +20,7 @@ func TestMain(m *testing.M) {
 		flag.Set("alsologtostderr", "true")
 		flag.Set("v", "5")
 	}
+	os.Exit(m.Run())
 }
 
 func TestV01Parse(t *testing.T) {
@@ -39,6 +42,26 @@ func TestV01Parse(t *testing.T) {
 				Action:     actions.Noop,
 			},
 			true},
+		{"thMute (on)",
+			osc.NewMessage("/venue/0.1/th/soundcheck/input/mute/1/1", 1),
+			&router.Packet{
+				SourceName: TouchOSC,
+				Action:     actions.InputMute,
+				Control:    controls.Mute,
+				Signal:     signals.Input,
+				Value:      true,
+			},
+			true},
+		{"thMute (off)",
+			osc.NewMessage("/venue/0.1/th/soundcheck/input/mute/1/1", 0),
+			&router.Packet{
+				SourceName: TouchOSC,
+				Action:     actions.InputMute,
+				Control:    controls.Mute,
+				Signal:     signals.Input,
+				Value:      false,
+			},
+			true},
 	} {
 		pkt, err := Parse(tt.msg)
 		if err != nil && tt.ok {
@@ -111,16 +134,30 @@ func TestV01PackGain(t *testing.T) {
 func TestVenueAuxGroup(t *testing.T) {
 	for _, tt := range []struct {
 		desc  string
+		c     buses.Config
 		y     int
 		sig   signals.Signal
 		sigNo signals.SignalNo
+		ok    bool
 	}{
-		{"aux 1", 1, signals.Aux, 1},
-		{"aux 9", 5, signals.Aux, 9},
-		{"group 1", 9, signals.Group, 1},
+		{"aux 1", buses.Default(), 1, signals.Aux, 1, true},
+		{"aux 9", buses.Default(), 5, signals.Aux, 9, true},
+		{"group 1", buses.Default(), 9, signals.Group, 1, true},
+		{"group 7", buses.Default(), 12, signals.Group, 7, true},
+		{"past groups", buses.Default(), 13, signals.Unknown, 0, false},
+		{"no position", buses.Default(), 0, signals.Unknown, 0, false},
+		{"8+16 group 1", buses.Config{Auxes: 8, Groups: 16, StereoAuxes: true, StereoGroups: true}, 5, signals.Group, 1, true},
+		{"8+16 group 15", buses.Config{Auxes: 8, Groups: 16, StereoAuxes: true, StereoGroups: true}, 12, signals.Group, 15, true},
+		{"mono aux 2", buses.Config{Auxes: 16, Groups: 8, StereoGroups: true}, 2, signals.Aux, 2, true},
+		{"mono group 3", buses.Config{Auxes: 16, Groups: 8, StereoGroups: true}, 18, signals.Group, 3, true},
+		{"mono past groups", buses.Config{Auxes: 16, Groups: 8, StereoGroups: true}, 21, signals.Unknown, 0, false},
 	} {
 		req := &request{y: tt.y}
-		sig, sigNo := venueAuxGroup(req)
+		sig, sigNo, err := venueAuxGroup(req, tt.c)
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("%s: ok: got %t, want %t; %v", tt.desc, got, want, err)
+			continue
+		}
 		if got, want := sig, tt.sig; got != want {
 			t.Errorf("%s: sig: got %d, want %d", tt.desc, got, want)
 		}
@@ -129,3 +166,64 @@ func TestVenueAuxGroup(t *testing.T) {
 		}
 	}
 }
+
+func TestParserBuses(t *testing.T) {
+	if _, err := NewParser(Buses(buses.Config{Auxes: 17})); err == nil {
+		t.Error("NewParser() expected an error for an invalid bus configuration")
+	}
+	p, err := NewParser(Buses(buses.Config{Auxes: 8, Groups: 16, StereoAuxes: true, StereoGroups: true}))
+	if err != nil {
+		t.Fatalf("NewParser() unexpected error: %s", err)
+	}
+	for _, tt := range []struct {
+		desc string
+		msg  *osc.Message
+		pkt  *router.Packet
+	}{
+		{"group 15",
+			osc.NewMessage("/venue/0.1/th/soundcheck/output/select/1/12", 1),
+			&router.Packet{
+				SourceName: TouchOSC,
+				Action:     actions.SelectOutput,
+				Signal:     signals.Group,
+				SignalNo:   15,
+			}},
+		{"no bus", osc.NewMessage("/venue/0.1/th/soundcheck/output/select/1/13", 1), nil},
+		{"group 15 mute",
+			osc.NewMessage("/venue/0.1/th/soundcheck/output/mute/1/12", 1),
+			&router.Packet{
+				SourceName: TouchOSC,
+				Action:     actions.OutputMute,
+				Control:    controls.Mute,
+				Signal:     signals.Group,
+				SignalNo:   15,
+				Value:      true,
+			}},
+		{"aux 1 unmute",
+			osc.NewMessage("/venue/0.1/th/soundcheck/output/mute/1/1", 0),
+			&router.Packet{
+				SourceName: TouchOSC,
+				Action:     actions.OutputMute,
+				Control:    controls.Mute,
+				Signal:     signals.Aux,
+				SignalNo:   1,
+				Value:      false,
+			}},
+		{"no mute bus", osc.NewMessage("/venue/0.1/th/soundcheck/output/mute/1/13", 1), nil},
+	} {
+		pkt, err := p.Parse(tt.msg)
+		if tt.pkt == nil {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tt.desc, pkt)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if !pkt.Equal(tt.pkt) {
+			t.Errorf("%s: packets not equal; got = %v, want = %v", tt.desc, pkt, tt.pkt)
+		}
+	}
+}
@@ -9,14 +9,42 @@ package touchosc
 import (
 	"github.com/golang/glog"
 	"github.com/kward/go-osc/osc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/codes"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/router/actions"
 	"github.com/kward/venue/internal/venuelib"
 )
 
-// Parse the OSC message `msg` and transform it into a Packet.
+// Parser transforms OSC messages into Packets.
+type Parser struct {
+	opts *options
+}
+
+// NewParser returns a Parser configured by options `opts`.
+func NewParser(opts ...func(*options) error) (*Parser, error) {
+	o := &options{}
+	o.setBuses(buses.Default())
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return &Parser{opts: o}, nil
+}
+
+// Parse the OSC message `msg` and transform it into a Packet. The default
+// bus configuration is assumed.
 func Parse(msg *osc.Message) (*router.Packet, error) {
+	p, err := NewParser()
+	if err != nil {
+		return nil, err
+	}
+	return p.Parse(msg)
+}
+
+// Parse the OSC message `msg` and transform it into a Packet.
+func (p *Parser) Parse(msg *osc.Message) (*router.Packet, error) {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
@@ -76,7 +104,7 @@ Processing:
 	if !ok {
 		return nil, venuelib.Errorf(codes.NotFound, "unable to pack version %s", req.version)
 	}
-	packer.init(req)
+	packer.init(req, p.opts.buses)
 	for packer.setPacker(packer.packer()); !packer.done(); {
 		packer.pack()
 	}
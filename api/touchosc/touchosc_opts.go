@@ -0,0 +1,23 @@
+package touchosc
+
+import "github.com/kward/venue/internal/buses"
+
+type options struct {
+	buses buses.Config // Console bus configuration.
+}
+
+// Buses is an option for NewParser() that sets the console bus configuration,
+// which positions the aux and group outputs. Packets for buses that do not
+// exist are rejected.
+func Buses(c buses.Config) func(*options) error {
+	return func(o *options) error { return o.setBuses(c) }
+}
+
+// setBuses sets the console bus configuration.
+func (o *options) setBuses(c buses.Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	o.buses = c
+	return nil
+}
@@ -0,0 +1,93 @@
+package vnc
+
+import (
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/venue/api/vnc/messages"
+	"github.com/kward/venue/internal/math"
+)
+
+// arrowRun describes a workflow that ends by adjusting a value with a run of
+// arrow key presses followed by Return, as encoder adjustments do.
+type arrowRun struct {
+	prefix []*Event // Events leading up to the arrow keys, e.g. page selection.
+	steps  int      // Net number of Up (positive) or Down (negative) presses.
+}
+
+// parseArrowRun returns the arrow run that workflow wf ends with, if any.
+func parseArrowRun(wf *Workflow) (arrowRun, bool) {
+	evs := wf.events
+	i := len(evs) - 2
+	if i < 0 || !isKeyPress(evs[i:], keys.Return) {
+		return arrowRun{}, false
+	}
+	steps := 0
+	for ; i >= 2; i -= 2 {
+		switch {
+		case isKeyPress(evs[i-2:], keys.Up):
+			steps++
+			continue
+		case isKeyPress(evs[i-2:], keys.Down):
+			steps--
+			continue
+		}
+		break
+	}
+	if i == len(evs)-2 {
+		return arrowRun{}, false // No arrow keys.
+	}
+	return arrowRun{evs[:i], steps}, true
+}
+
+// isKeyPress returns true if events evs start with a press and release of key.
+func isKeyPress(evs []*Event, key keys.Key) bool {
+	return len(evs) >= 2 &&
+		sameEvent(evs[0], &Event{msg: messages.KeyEvent, data: keyEvent{key, true}}) &&
+		sameEvent(evs[1], &Event{msg: messages.KeyEvent, data: keyEvent{key, false}})
+}
+
+// sameEvent returns true if events a and b have the same effect. Expand events
+// have the same effect when described alike.
+func sameEvent(a, b *Event) bool {
+	if a.msg == messages.Expand || b.msg == messages.Expand {
+		return a.msg == b.msg && a.desc == b.desc
+	}
+	return a.msg == b.msg && a.data == b.data
+}
+
+// coalesce merges workflow b into workflow a, which executes before it. Both
+// must adjust a value with arrow keys, and b must not lead up to its arrow keys
+// with anything that a does not end its lead up with. The page selection and
+// click that b repeats are dropped, and the arrow runs are summed, as long as
+// the sum does not exceed maxArrows presses. The workflows are not modified.
+func coalesce(a, b *Workflow, maxArrows int) (*Workflow, bool) {
+	ra, ok := parseArrowRun(a)
+	if !ok {
+		return nil, false
+	}
+	rb, ok := parseArrowRun(b)
+	if !ok || len(rb.prefix) == 0 || len(rb.prefix) > len(ra.prefix) {
+		return nil, false
+	}
+	tail := ra.prefix[len(ra.prefix)-len(rb.prefix):]
+	for i := range tail {
+		if !sameEvent(tail[i], rb.prefix[i]) {
+			return nil, false
+		}
+	}
+	steps := ra.steps + rb.steps
+	if math.Abs(steps) > maxArrows {
+		return nil, false
+	}
+
+	wf := &Workflow{conn: a.conn, fb: a.fb, sleeper: a.sleeper, recover: a.recover, tf: a.tf}
+	wf.events = append(wf.events, ra.prefix...)
+	key := keys.Up
+	if steps < 0 {
+		key = keys.Down
+	}
+	for i := 0; i < math.Abs(steps); i++ {
+		wf.KeyPress(key)
+	}
+	wf.KeyPress(keys.Return)
+	return wf, true
+}
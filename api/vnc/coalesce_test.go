@@ -0,0 +1,93 @@
+package vnc
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+)
+
+// adjust returns a workflow that optionally selects a page with key `page`,
+// clicks an encoder at p, and adjusts it by `steps` arrow key presses.
+func adjust(page keys.Key, p image.Point, steps int) *Workflow {
+	wf := NewWorkflow(NewMockConn())
+	if page != 0 {
+		wf.KeyPress(page)
+	}
+	wf.MouseClick(buttons.Left, p)
+	key := keys.Up
+	if steps < 0 {
+		key, steps = keys.Down, -steps
+	}
+	for i := 0; i < steps; i++ {
+		wf.KeyPress(key)
+	}
+	wf.KeyPress(keys.Return)
+	return wf
+}
+
+// expandAdjust returns an adjust workflow that selects a page with an Expand
+// event described as desc.
+func expandAdjust(desc string, p image.Point, steps int) *Workflow {
+	wf := NewWorkflow(NewMockConn())
+	wf.Expand(desc, func(x *Workflow) error {
+		x.KeyPress(keys.F1)
+		return nil
+	})
+	wf.events = append(wf.events, adjust(0, p, steps).events...)
+	return wf
+}
+
+func TestCoalesce(t *testing.T) {
+	gain, aux := image.Point{10, 20}, image.Point{30, 40}
+	typed := NewWorkflow(NewMockConn())
+	typed.MouseClick(buttons.Left, gain)
+	typed.KeyPress(keys.Digit1)
+	typed.KeyPress(keys.Return)
+
+	for _, tt := range []struct {
+		desc string
+		a, b *Workflow
+		ok   bool
+		want *Workflow
+	}{
+		{"same encoder", adjust(keys.F1, gain, 1), adjust(keys.F1, gain, 2), true, adjust(keys.F1, gain, 3)},
+		{"page already selected", adjust(keys.F1, gain, 2), adjust(0, gain, -1), true, adjust(keys.F1, gain, 1)},
+		{"opposite steps", adjust(0, gain, 2), adjust(0, gain, -2), true, adjust(0, gain, 0)},
+		{"page selected later", adjust(0, gain, 1), adjust(keys.F1, gain, 1), false, nil},
+		{"different encoders", adjust(keys.F1, gain, 1), adjust(keys.F1, aux, 1), false, nil},
+		{"too many steps", adjust(0, gain, 3), adjust(0, gain, 2), false, nil},
+		{"typed value", typed, adjust(0, gain, 1), false, nil},
+		{"same expansion", expandAdjust("inputs", gain, 1), expandAdjust("inputs", gain, 1), true, expandAdjust("inputs", gain, 2)},
+		{"different expansions", expandAdjust("inputs", gain, 1), expandAdjust("outputs", gain, 1), false, nil},
+		{"no click", adjust(keys.F1, gain, 1), func() *Workflow {
+			wf := NewWorkflow(NewMockConn())
+			wf.KeyPress(keys.Up)
+			wf.KeyPress(keys.Return)
+			return wf
+		}(), false, nil},
+	} {
+		got, ok := coalesce(tt.a, tt.b, 4)
+		if ok != tt.ok {
+			t.Errorf("%s: coalesce() ok = %t, want %t", tt.desc, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got, want := descs(got), descs(tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: coalesce() = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+// descs returns the descriptions of the workflow events.
+func descs(wf *Workflow) []string {
+	var ds []string
+	for _, e := range wf.events {
+		ds = append(ds, e.desc)
+	}
+	return ds
+}
@@ -0,0 +1,445 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	vnclib "github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// pixelFormat is the pixel format requested from the VNC server. Fixing the
+// format allows the encodings below to decode pixels without access to the
+// format negotiated by go-vnc.
+var pixelFormat = vnclib.PixelFormat{
+	BPP:        32,
+	Depth:      24,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     255,
+	GreenMax:   255,
+	BlueMax:    255,
+	RedShift:   16,
+	GreenShift: 8,
+	BlueShift:  0,
+}
+
+// The go-vnc library only decodes Raw rectangles, and the network connection
+// of a vnclib.ClientConn isn't accessible. The encodings below therefore hold
+// the connection they read from. As go-vnc reads unbuffered from the same
+// connection, the two readers stay in sync.
+
+// readColors reads n pixels of bpp bytes each from r.
+func readColors(r io.Reader, pf *vnclib.PixelFormat, n, bpp int) ([]vnclib.Color, error) {
+	buf := make([]byte, n*bpp)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	var cm vnclib.ColorMap
+	colors := make([]vnclib.Color, n)
+	for i := range colors {
+		c := vnclib.NewColor(pf, &cm)
+		px := buf[i*bpp : (i+1)*bpp]
+		if bpp < int(pf.BPP/8) {
+			// Compressed pixels omit the unused most significant byte.
+			px = append(px[:bpp:bpp], 0)
+		}
+		if err := c.Unmarshal(px); err != nil {
+			return nil, err
+		}
+		colors[i] = *c
+	}
+	return colors, nil
+}
+
+// fillColors paints rectangle x, y, w, h of the stride wide colors with c.
+func fillColors(colors []vnclib.Color, stride, x, y, w, h int, c vnclib.Color) {
+	for j := y; j < y+h; j++ {
+		for i := x; i < x+w; i++ {
+			colors[j*stride+i] = c
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// CopyRect Encoding
+//
+// See RFC 6143 §7.7.2.
+// https://tools.ietf.org/html/rfc6143#section-7.7.2
+
+// copyRectEncoding holds the source position of a CopyRect rectangle.
+type copyRectEncoding struct {
+	r          io.Reader
+	SrcX, SrcY uint16
+}
+
+// Verify that the expected interface is implemented properly.
+var _ vnclib.Encoding = (*copyRectEncoding)(nil)
+
+func newCopyRectEncoding(r io.Reader) *copyRectEncoding {
+	return &copyRectEncoding{r: r}
+}
+
+// Marshal implements the vnclib.Encoding interface.
+func (e *copyRectEncoding) Marshal() ([]byte, error) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint16(buf[0:], e.SrcX)
+	binary.BigEndian.PutUint16(buf[2:], e.SrcY)
+	return buf, nil
+}
+
+// Read implements the vnclib.Encoding interface.
+func (e *copyRectEncoding) Read(_ *vnclib.ClientConn, _ *vnclib.Rectangle) (vnclib.Encoding, error) {
+	var src [2]uint16
+	if err := binary.Read(e.r, binary.BigEndian, &src); err != nil {
+		return nil, fmt.Errorf("unable to read CopyRect source; %s", err)
+	}
+	return &copyRectEncoding{r: e.r, SrcX: src[0], SrcY: src[1]}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *copyRectEncoding) String() string { return "CopyRectEncoding" }
+
+// Type implements the vnclib.Encoding interface.
+func (e *copyRectEncoding) Type() encodings.Encoding { return encodings.CopyRect }
+
+//-----------------------------------------------------------------------------
+// Hextile Encoding
+//
+// See RFC 6143 §7.7.4.
+// https://tools.ietf.org/html/rfc6143#section-7.7.4
+
+const (
+	hextileRaw = 1 << iota
+	hextileBackgroundSpecified
+	hextileForegroundSpecified
+	hextileAnySubrects
+	hextileSubrectsColoured
+)
+
+const hextileSize = 16
+
+// hextileEncoding holds the decoded colors of a Hextile rectangle.
+type hextileEncoding struct {
+	r      io.Reader
+	pf     *vnclib.PixelFormat
+	Colors []vnclib.Color
+}
+
+// Verify that the expected interface is implemented properly.
+var _ vnclib.Encoding = (*hextileEncoding)(nil)
+
+func newHextileEncoding(r io.Reader, pf *vnclib.PixelFormat) *hextileEncoding {
+	return &hextileEncoding{r: r, pf: pf}
+}
+
+// Marshal implements the vnclib.Encoding interface.
+func (e *hextileEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the vnclib.Encoding interface.
+func (e *hextileEncoding) Read(_ *vnclib.ClientConn, rect *vnclib.Rectangle) (vnclib.Encoding, error) {
+	w, h := int(rect.Width), int(rect.Height)
+	bpp := int(e.pf.BPP / 8)
+	colors := make([]vnclib.Color, w*h)
+	var bg, fg vnclib.Color
+	for ty := 0; ty < h; ty += hextileSize {
+		th := min(hextileSize, h-ty)
+		for tx := 0; tx < w; tx += hextileSize {
+			tw := min(hextileSize, w-tx)
+			if err := e.readTile(colors, w, tx, ty, tw, th, bpp, &bg, &fg); err != nil {
+				return nil, fmt.Errorf("unable to read Hextile tile at %d,%d; %s", tx, ty, err)
+			}
+		}
+	}
+	return &hextileEncoding{r: e.r, pf: e.pf, Colors: colors}, nil
+}
+
+// readTile reads a single tile into colors. The background and foreground
+// colors carry over from the previous tile.
+func (e *hextileEncoding) readTile(colors []vnclib.Color, stride, tx, ty, tw, th, bpp int, bg, fg *vnclib.Color) error {
+	var mask [1]byte
+	if _, err := io.ReadFull(e.r, mask[:]); err != nil {
+		return err
+	}
+	if mask[0]&hextileRaw != 0 {
+		raw, err := readColors(e.r, e.pf, tw*th, bpp)
+		if err != nil {
+			return err
+		}
+		for y := 0; y < th; y++ {
+			copy(colors[(ty+y)*stride+tx:], raw[y*tw:(y+1)*tw])
+		}
+		return nil
+	}
+
+	if mask[0]&hextileBackgroundSpecified != 0 {
+		c, err := readColors(e.r, e.pf, 1, bpp)
+		if err != nil {
+			return err
+		}
+		*bg = c[0]
+	}
+	fillColors(colors, stride, tx, ty, tw, th, *bg)
+	if mask[0]&hextileForegroundSpecified != 0 {
+		c, err := readColors(e.r, e.pf, 1, bpp)
+		if err != nil {
+			return err
+		}
+		*fg = c[0]
+	}
+	if mask[0]&hextileAnySubrects == 0 {
+		return nil
+	}
+
+	var n [1]byte
+	if _, err := io.ReadFull(e.r, n[:]); err != nil {
+		return err
+	}
+	for i := 0; i < int(n[0]); i++ {
+		c := *fg
+		if mask[0]&hextileSubrectsColoured != 0 {
+			cs, err := readColors(e.r, e.pf, 1, bpp)
+			if err != nil {
+				return err
+			}
+			c = cs[0]
+		}
+		var xywh [2]byte
+		if _, err := io.ReadFull(e.r, xywh[:]); err != nil {
+			return err
+		}
+		x, y := int(xywh[0]>>4), int(xywh[0]&0x0f)
+		sw, sh := int(xywh[1]>>4)+1, int(xywh[1]&0x0f)+1
+		if x+sw > tw || y+sh > th {
+			return fmt.Errorf("subrect %d,%d %dx%d exceeds tile", x, y, sw, sh)
+		}
+		fillColors(colors, stride, tx+x, ty+y, sw, sh, c)
+	}
+	return nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *hextileEncoding) String() string { return "HextileEncoding" }
+
+// Type implements the vnclib.Encoding interface.
+func (e *hextileEncoding) Type() encodings.Encoding { return encodings.Hextile }
+
+//-----------------------------------------------------------------------------
+// ZRLE Encoding
+//
+// See RFC 6143 §7.7.6.
+// https://tools.ietf.org/html/rfc6143#section-7.7.6
+
+const (
+	zrleSize     = 64
+	zrleRaw      = 0
+	zrleSolid    = 1
+	zrlePlainRLE = 128
+)
+
+// zrleStream holds the zlib stream, which spans all ZRLE rectangles of a
+// connection.
+type zrleStream struct {
+	buf bytes.Buffer
+	zr  io.ReadCloser
+}
+
+// zrleEncoding holds the decoded colors of a ZRLE rectangle.
+type zrleEncoding struct {
+	r      io.Reader
+	pf     *vnclib.PixelFormat
+	z      *zrleStream
+	Colors []vnclib.Color
+}
+
+// Verify that the expected interface is implemented properly.
+var _ vnclib.Encoding = (*zrleEncoding)(nil)
+
+func newZRLEEncoding(r io.Reader, pf *vnclib.PixelFormat) *zrleEncoding {
+	return &zrleEncoding{r: r, pf: pf, z: &zrleStream{}}
+}
+
+// Marshal implements the vnclib.Encoding interface.
+func (e *zrleEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the vnclib.Encoding interface.
+func (e *zrleEncoding) Read(_ *vnclib.ClientConn, rect *vnclib.Rectangle) (vnclib.Encoding, error) {
+	var n uint32
+	if err := binary.Read(e.r, binary.BigEndian, &n); err != nil {
+		return nil, fmt.Errorf("unable to read ZRLE length; %s", err)
+	}
+	if _, err := io.CopyN(&e.z.buf, e.r, int64(n)); err != nil {
+		return nil, fmt.Errorf("unable to read ZRLE data; %s", err)
+	}
+	if e.z.zr == nil {
+		zr, err := zlib.NewReader(&e.z.buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open ZRLE stream; %s", err)
+		}
+		e.z.zr = zr
+	}
+
+	w, h := int(rect.Width), int(rect.Height)
+	colors := make([]vnclib.Color, w*h)
+	for ty := 0; ty < h; ty += zrleSize {
+		th := min(zrleSize, h-ty)
+		for tx := 0; tx < w; tx += zrleSize {
+			tw := min(zrleSize, w-tx)
+			if err := e.readTile(colors, w, tx, ty, tw, th); err != nil {
+				return nil, fmt.Errorf("unable to read ZRLE tile at %d,%d; %s", tx, ty, err)
+			}
+		}
+	}
+	return &zrleEncoding{r: e.r, pf: e.pf, z: e.z, Colors: colors}, nil
+}
+
+// cpixelSize returns the size in bytes of a compressed pixel.
+func (e *zrleEncoding) cpixelSize() int {
+	if e.pf.BPP == 32 && e.pf.Depth <= 24 && rfbflags.IsTrueColor(e.pf.TrueColor) {
+		return 3
+	}
+	return int(e.pf.BPP / 8)
+}
+
+// readTile reads a single tile into colors.
+func (e *zrleEncoding) readTile(colors []vnclib.Color, stride, tx, ty, tw, th int) error {
+	zr := e.z.zr
+	cpx := e.cpixelSize()
+	readByte := func() (int, error) {
+		var b [1]byte
+		_, err := io.ReadFull(zr, b[:])
+		return int(b[0]), err
+	}
+	readRun := func() (int, error) {
+		n := 1
+		for {
+			b, err := readByte()
+			if err != nil {
+				return 0, err
+			}
+			n += b
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	// set paints pixel i of the tile, returning false once the tile is full.
+	set := func(i int, c vnclib.Color) bool {
+		if i >= tw*th {
+			return false
+		}
+		colors[(ty+i/tw)*stride+tx+i%tw] = c
+		return true
+	}
+
+	sub, err := readByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case sub == zrleRaw:
+		raw, err := readColors(zr, e.pf, tw*th, cpx)
+		if err != nil {
+			return err
+		}
+		for i, c := range raw {
+			set(i, c)
+		}
+
+	case sub == zrleSolid:
+		c, err := readColors(zr, e.pf, 1, cpx)
+		if err != nil {
+			return err
+		}
+		fillColors(colors, stride, tx, ty, tw, th, c[0])
+
+	case sub >= 2 && sub <= 16: // Packed palette.
+		palette, err := readColors(zr, e.pf, sub, cpx)
+		if err != nil {
+			return err
+		}
+		bits := 4
+		switch {
+		case sub == 2:
+			bits = 1
+		case sub <= 4:
+			bits = 2
+		}
+		row := make([]byte, (tw*bits+7)/8)
+		for y := 0; y < th; y++ {
+			if _, err := io.ReadFull(zr, row); err != nil {
+				return err
+			}
+			for x := 0; x < tw; x++ {
+				shift := 8 - bits - (x*bits)%8
+				idx := int(row[x*bits/8]>>uint(shift)) & (1<<uint(bits) - 1)
+				if idx >= len(palette) {
+					return fmt.Errorf("palette index %d out of range", idx)
+				}
+				set(y*tw+x, palette[idx])
+			}
+		}
+
+	case sub == zrlePlainRLE:
+		for i := 0; i < tw*th; {
+			c, err := readColors(zr, e.pf, 1, cpx)
+			if err != nil {
+				return err
+			}
+			n, err := readRun()
+			if err != nil {
+				return err
+			}
+			for ; n > 0; n, i = n-1, i+1 {
+				if !set(i, c[0]) {
+					return fmt.Errorf("run exceeds tile")
+				}
+			}
+		}
+
+	case sub >= 130: // Palette RLE.
+		palette, err := readColors(zr, e.pf, sub-128, cpx)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < tw*th; {
+			idx, err := readByte()
+			if err != nil {
+				return err
+			}
+			n := 1
+			if idx&128 != 0 {
+				idx &= 127
+				if n, err = readRun(); err != nil {
+					return err
+				}
+			}
+			if idx >= len(palette) {
+				return fmt.Errorf("palette index %d out of range", idx)
+			}
+			for ; n > 0; n, i = n-1, i+1 {
+				if !set(i, palette[idx]) {
+					return fmt.Errorf("run exceeds tile")
+				}
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported subencoding %d", sub)
+	}
+	return nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *zrleEncoding) String() string { return "ZRLEEncoding" }
+
+// Type implements the vnclib.Encoding interface.
+func (e *zrleEncoding) Type() encodings.Encoding { return encodings.ZRLE }
@@ -0,0 +1,198 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	vnclib "github.com/kward/go-vnc"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+func TestCopyRectRead(t *testing.T) {
+	e := newCopyRectEncoding(bytes.NewReader([]byte{0, 5, 1, 2}))
+	enc, err := e.Read(nil, &vnclib.Rectangle{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	got := enc.(*copyRectEncoding)
+	if got.SrcX != 5 || got.SrcY != 258 {
+		t.Errorf("Read() = %d,%d, want 5,258", got.SrcX, got.SrcY)
+	}
+}
+
+func TestFramebufferCopy(t *testing.T) {
+	fb := NewFramebuffer(4, 1)
+	fb.Paint(vnclib.Rectangle{Width: 4, Height: 1}, toColors(red, green, blue, black))
+	// Overlapping copy of the first three pixels one to the right.
+	fb.Copy(vnclib.Rectangle{X: 1, Width: 3, Height: 1}, image.Point{0, 0})
+	fb.Commit()
+	if got, want := pixels(fb), []color.RGBA{red, red, green, blue}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Copy() = %v, want %v", got, want)
+	}
+}
+
+func TestHextileRead(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		w, h uint16
+		data []byte
+		want []color.RGBA
+	}{
+		{"raw", 2, 1,
+			cat([]byte{hextileRaw}, px(red), px(green)),
+			[]color.RGBA{red, green}},
+		{"background", 2, 1,
+			cat([]byte{hextileBackgroundSpecified}, px(blue)),
+			[]color.RGBA{blue, blue}},
+		{"subrect", 3, 2,
+			cat([]byte{hextileBackgroundSpecified | hextileForegroundSpecified | hextileAnySubrects},
+				px(black), px(red), []byte{1, 0x10, 0x10}),
+			[]color.RGBA{black, red, red, black, black, black}},
+		{"coloured subrects", 2, 2,
+			cat([]byte{hextileBackgroundSpecified | hextileAnySubrects | hextileSubrectsColoured},
+				px(black), []byte{2}, px(red), []byte{0x00, 0x00}, px(green), []byte{0x11, 0x00}),
+			[]color.RGBA{red, black, black, green}},
+		// The background of the first tile carries over to the second.
+		{"two tiles", 17, 1,
+			cat([]byte{hextileBackgroundSpecified}, px(blue), []byte{0}),
+			repeat(blue, 17)},
+	} {
+		e := newHextileEncoding(bytes.NewReader(tt.data), &pixelFormat)
+		enc, err := e.Read(nil, &vnclib.Rectangle{Width: tt.w, Height: tt.h})
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := toRGBA(enc.(*hextileEncoding).Colors), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Read() = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestZRLERead(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		w, h  uint16
+		tiles [][]byte // Uncompressed data of each rectangle.
+		want  []color.RGBA
+	}{
+		{"raw", 2, 1,
+			[][]byte{cat([]byte{zrleRaw}, cpx(red), cpx(green))},
+			[]color.RGBA{red, green}},
+		{"solid", 2, 2,
+			[][]byte{cat([]byte{zrleSolid}, cpx(blue))},
+			repeat(blue, 4)},
+		{"packed palette", 3, 2,
+			[][]byte{cat([]byte{2}, cpx(black), cpx(red), []byte{0x40, 0xa0})},
+			[]color.RGBA{black, red, black, red, black, red}},
+		{"plain rle", 3, 2,
+			[][]byte{cat([]byte{zrlePlainRLE}, cpx(red), []byte{1}, cpx(green), []byte{3})},
+			[]color.RGBA{red, red, green, green, green, green}},
+		{"palette rle", 3, 2,
+			[][]byte{cat([]byte{130}, cpx(black), cpx(blue), []byte{0x81, 4, 0x00})},
+			[]color.RGBA{blue, blue, blue, blue, blue, black}},
+		{"long run", 64, 5,
+			[][]byte{cat([]byte{zrlePlainRLE}, cpx(green), []byte{255, 64})},
+			repeat(green, 320)},
+		// Rectangles share a single zlib stream.
+		{"stream", 2, 1,
+			[][]byte{
+				cat([]byte{zrleSolid}, cpx(red)),
+				cat([]byte{zrleSolid}, cpx(green)),
+			},
+			[]color.RGBA{green, green}},
+	} {
+		var wire, zbuf bytes.Buffer
+		zw := zlib.NewWriter(&zbuf)
+		for _, data := range tt.tiles {
+			zw.Write(data)
+			zw.Flush()
+			binary.Write(&wire, binary.BigEndian, uint32(zbuf.Len()))
+			zbuf.WriteTo(&wire)
+		}
+
+		e := newZRLEEncoding(&wire, &pixelFormat)
+		var enc vnclib.Encoding
+		var err error
+		for range tt.tiles {
+			if enc, err = e.Read(nil, &vnclib.Rectangle{Width: tt.w, Height: tt.h}); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := toRGBA(enc.(*zrleEncoding).Colors), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Read() = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestZRLEReadInvalid(t *testing.T) {
+	var wire, zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte{17}) // Unused subencoding.
+	zw.Flush()
+	binary.Write(&wire, binary.BigEndian, uint32(zbuf.Len()))
+	zbuf.WriteTo(&wire)
+
+	e := newZRLEEncoding(&wire, &pixelFormat)
+	if _, err := e.Read(nil, &vnclib.Rectangle{Width: 1, Height: 1}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// px returns the wire format of a pixel in pixelFormat.
+func px(c color.RGBA) []byte { return []byte{c.B, c.G, c.R, 0} }
+
+// cpx returns the wire format of a ZRLE compressed pixel in pixelFormat.
+func cpx(c color.RGBA) []byte { return px(c)[:3] }
+
+func cat(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+func repeat(c color.RGBA, n int) []color.RGBA {
+	cs := make([]color.RGBA, n)
+	for i := range cs {
+		cs[i] = c
+	}
+	return cs
+}
+
+func toColors(cs ...color.RGBA) []vnclib.Color {
+	colors := make([]vnclib.Color, len(cs))
+	for i, c := range cs {
+		colors[i] = vnclib.Color{R: uint16(c.R), G: uint16(c.G), B: uint16(c.B)}
+	}
+	return colors
+}
+
+func toRGBA(colors []vnclib.Color) []color.RGBA {
+	cs := make([]color.RGBA, len(colors))
+	for i, c := range colors {
+		cs[i] = color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}
+	}
+	return cs
+}
+
+// pixels returns the pixels of the framebuffer, in row-major order.
+func pixels(fb *Framebuffer) []color.RGBA {
+	var cs []color.RGBA
+	for y := 0; y < fb.Height(); y++ {
+		for x := 0; x < fb.Width(); x++ {
+			cs = append(cs, fb.RGBAAt(x, y))
+		}
+	}
+	return cs
+}
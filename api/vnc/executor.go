@@ -0,0 +1,241 @@
+package vnc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kward/venue/api/vnc/messages"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+// Priority orders the workflows queued on an Executor.
+type Priority int
+
+const (
+	// Normal priority workflows execute in submission order.
+	Normal Priority = iota
+	// High priority workflows execute before any queued Normal ones. Those
+	// without key events may also execute while another workflow sleeps or
+	// waits.
+	High
+)
+
+// Result is the outcome of an executed workflow.
+type Result struct {
+	Sent int   // Number of key and pointer events sent.
+	Err  error // Error stopping the workflow, if any.
+}
+
+// job is a workflow queued for execution.
+type job struct {
+	ctx    context.Context
+	wf     *Workflow
+	prio   Priority
+	done   chan Result
+	merged []*job // Jobs coalesced into this one.
+}
+
+// finish delivers result r to the job, and those coalesced into it.
+func (j *job) finish(r Result) {
+	j.done <- r
+	for _, m := range j.merged {
+		m.done <- r
+	}
+}
+
+// Executor executes workflows one at a time from a single goroutine, so that
+// the events of concurrently submitted workflows are never interleaved.
+type Executor struct {
+	opts   *executorOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex // Protects queue and closed.
+	queue  []*job     // Ordered by priority, then submission.
+	closed bool
+	wake   chan struct{}
+}
+
+// NewExecutor returns a running executor. Call Close to stop it.
+func NewExecutor(opts ...func(*executorOptions) error) (*Executor, error) {
+	o := &executorOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Executor{
+		opts:   o,
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// Close stops the executor, interrupting the executing workflow. Queued and
+// later submitted workflows fail with codes.Canceled.
+func (e *Executor) Close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	e.cancel()
+	e.wg.Wait()
+}
+
+// Submit queues workflow wf for execution, returning a channel that receives
+// its result. The workflow is abandoned should ctx be done before it
+// completes.
+func (e *Executor) Submit(ctx context.Context, wf *Workflow, prio Priority) <-chan Result {
+	j := &job{ctx: ctx, wf: wf, prio: prio, done: make(chan Result, 1)}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		j.done <- Result{Err: venuelib.Errorf(codes.Canceled, "executor closed")}
+		return j.done
+	}
+	i := len(e.queue)
+	for i > 0 && e.queue[i-1].prio < prio {
+		i--
+	}
+	e.queue = append(e.queue, nil)
+	copy(e.queue[i+1:], e.queue[i:])
+	e.queue[i] = j
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return j.done
+}
+
+// Execute submits workflow wf, and waits for its result.
+func (e *Executor) Execute(ctx context.Context, wf *Workflow, prio Priority) error {
+	return (<-e.Submit(ctx, wf, prio)).Err
+}
+
+// run executes queued workflows until the executor is closed.
+func (e *Executor) run() {
+	defer e.wg.Done()
+	for {
+		for e.ctx.Err() == nil {
+			j := e.next(nil)
+			if j == nil {
+				break
+			}
+			e.execute(j, true)
+		}
+		select {
+		case <-e.ctx.Done():
+			for j := e.next(nil); j != nil; j = e.next(nil) {
+				j.finish(Result{Err: venuelib.Errorf(codes.Canceled, "executor closed")})
+			}
+			return
+		case <-e.wake:
+		}
+	}
+}
+
+// next dequeues the first workflow accepted by filter f, or the first workflow
+// if f is nil. It returns nil if there is none.
+func (e *Executor) next(f func(*job) bool) *job {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, j := range e.queue {
+		if f == nil || f(j) {
+			e.queue = append(e.queue[:i], e.queue[i+1:]...)
+			e.coalesce(j, i)
+			return j
+		}
+	}
+	return nil
+}
+
+// coalesce merges the workflows queued at index i onwards into job j, for as
+// long as they can be. Abandoned jobs are not merged, as they would cancel the
+// merged job. The executor lock must be held.
+func (e *Executor) coalesce(j *job, i int) {
+	if e.opts.maxArrows == 0 || j.ctx.Err() != nil {
+		return
+	}
+	for i < len(e.queue) && e.queue[i].prio == j.prio && e.queue[i].ctx.Err() == nil {
+		wf, ok := coalesce(j.wf, e.queue[i].wf, e.opts.maxArrows)
+		if !ok {
+			return
+		}
+		if glog.V(2) {
+			glog.Info("Coalescing queued workflows.")
+		}
+		j.wf = wf
+		j.merged = append(j.merged, e.queue[i])
+		j.merged = append(j.merged, e.queue[i].merged...)
+		e.queue = append(e.queue[:i], e.queue[i+1:]...)
+	}
+}
+
+// execute executes job j. Preemptible jobs let High priority workflows without
+// key events execute while they sleep or wait.
+func (e *Executor) execute(j *job, preemptible bool) {
+	ctx, cancel := mergeDone(j.ctx, e.ctx)
+	defer cancel()
+	// The merged workflow is abandoned along with any of the coalesced ones.
+	for _, m := range j.merged {
+		stop := context.AfterFunc(m.ctx, cancel)
+		defer stop()
+	}
+	if preemptible {
+		j.wf.preempt = &preemption{wake: e.wake, run: e.preempt}
+		defer func() { j.wf.preempt = nil }()
+	}
+	sent, err := j.wf.ExecuteCtx(ctx)
+	j.finish(Result{Sent: sent, Err: err})
+}
+
+// preempt executes the queued workflows able to preempt the executing one. Key
+// events are withheld, as they might land in a field that the preempted
+// workflow is typing into.
+func (e *Executor) preempt() {
+	for j := e.next(canPreempt); j != nil; j = e.next(canPreempt) {
+		if glog.V(2) {
+			glog.Info("Preempting the executing workflow.")
+		}
+		e.execute(j, false)
+	}
+}
+
+// canPreempt returns true if job j may execute while another workflow sleeps
+// or waits. The events of Expand events are unknown, so they might be key
+// events, unless the Expand event is known to be key free at the time.
+func canPreempt(j *job) bool {
+	if j.prio < High {
+		return false
+	}
+	for _, event := range j.wf.events {
+		switch event.msg {
+		case messages.KeyEvent:
+			return false
+		case messages.Expand:
+			if kf := event.data.(expandEvent).keyFree; kf == nil || !kf() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mergeDone returns a context derived from a, that is also done when b is.
+func mergeDone(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	stop := context.AfterFunc(b, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
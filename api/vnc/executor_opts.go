@@ -0,0 +1,26 @@
+package vnc
+
+import (
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+type executorOptions struct {
+	maxArrows int // Maximum arrow key run of coalesced workflows. 0 disables.
+}
+
+// Coalesce is an option for NewExecutor() that merges consecutive queued
+// workflows adjusting the same value with arrow keys, as long as the merged
+// run has at most maxArrows arrow key presses. Should any of the merged
+// workflows be abandoned, so is the merged one.
+func Coalesce(maxArrows int) func(*executorOptions) error {
+	return func(o *executorOptions) error { return o.setCoalesce(maxArrows) }
+}
+
+func (o *executorOptions) setCoalesce(v int) error {
+	if v < 0 {
+		return venuelib.Errorf(codes.InvalidArgument, "invalid maximum arrow keys %d", v)
+	}
+	o.maxArrows = v
+	return nil
+}
@@ -0,0 +1,367 @@
+package vnc
+
+import (
+	"context"
+	"image"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+func TestExecutorSerializes(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer e.Close()
+
+	ks := []keys.Key{keys.Digit1, keys.Digit2, keys.Digit3, keys.Digit4}
+	var wg sync.WaitGroup
+	for _, k := range ks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wf := NewWorkflow(conn)
+			wf.KeyPress(k)
+			wf.KeyPress(k)
+			if err := e.Execute(context.Background(), wf, Normal); err != nil {
+				t.Errorf("unexpected error; %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	events := conn.Events()
+	if got, want := len(events), 4*len(ks); got != want {
+		t.Fatalf("got %d events, want %d", got, want)
+	}
+	for i := 0; i < len(events); i += 4 {
+		k := events[i].(keyEvent).key
+		want := Events{keyEvent{k, true}, keyEvent{k, false}, keyEvent{k, true}, keyEvent{k, false}}
+		if got := events[i : i+4]; !reflect.DeepEqual(got, want) {
+			t.Errorf("interleaved events; < %v > != < %v >", got, want)
+		}
+	}
+}
+
+func TestExecutorPriority(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer e.Close()
+
+	a := NewWorkflow(conn)
+	a.KeyPress(keys.F1)
+	a.Sleep(100 * time.Millisecond)
+	aCh := e.Submit(context.Background(), a, Normal)
+	conn.WaitFor(t, 2)
+
+	// Neither workflow can preempt the sleep, as both hold key events.
+	b := NewWorkflow(conn)
+	b.KeyPress(keys.Digit1)
+	bCh := e.Submit(context.Background(), b, Normal)
+	c := NewWorkflow(conn)
+	c.KeyPress(keys.Digit2)
+	cCh := e.Submit(context.Background(), c, High)
+	for _, ch := range []<-chan Result{aCh, bCh, cCh} {
+		if r := <-ch; r.Err != nil {
+			t.Fatalf("unexpected error; %s", r.Err)
+		}
+	}
+
+	want := Events{
+		keyEvent{keys.F1, true}, keyEvent{keys.F1, false},
+		keyEvent{keys.Digit2, true}, keyEvent{keys.Digit2, false},
+		keyEvent{keys.Digit1, true}, keyEvent{keys.Digit1, false},
+	}
+	if got := conn.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events; < %v > != < %v >", got, want)
+	}
+}
+
+func TestExecutorPreempt(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer e.Close()
+
+	a := NewWorkflow(conn)
+	a.KeyPress(keys.Digit1)
+	a.Sleep(200 * time.Millisecond)
+	a.KeyPress(keys.Digit2)
+	aCh := e.Submit(context.Background(), a, Normal)
+	conn.WaitFor(t, 2)
+
+	b := NewWorkflow(conn)
+	b.MouseClick(buttons.Left, image.Point{10, 20})
+	select {
+	case r := <-e.Submit(context.Background(), b, High):
+		if got, want := r.Sent, 3; got != want {
+			t.Errorf("preempting workflow sent %d events, want %d", got, want)
+		}
+	case r := <-aCh:
+		t.Fatalf("sleeping workflow completed first; %v", r)
+	}
+	if r := <-aCh; r.Err != nil {
+		t.Fatalf("unexpected error; %s", r.Err)
+	}
+
+	want := Events{
+		keyEvent{keys.Digit1, true}, keyEvent{keys.Digit1, false},
+		pointerEvent{buttons.None, 10, 20},
+		pointerEvent{buttons.Left, 10, 20},
+		pointerEvent{buttons.None, 10, 20},
+		keyEvent{keys.Digit2, true}, keyEvent{keys.Digit2, false},
+	}
+	if got := conn.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events; < %v > != < %v >", got, want)
+	}
+}
+
+func TestExecutorPreemptWait(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer e.Close()
+
+	// The region never changes, so the wait times out.
+	a := NewWorkflow(conn)
+	a.SetFramebuffer(NewFramebuffer(4, 4))
+	a.KeyPress(keys.Digit1)
+	a.WaitForRegionChange(image.Rect(0, 0, 4, 4), 200*time.Millisecond)
+	aCh := e.Submit(context.Background(), a, Normal)
+	conn.WaitFor(t, 2)
+
+	b := NewWorkflow(conn)
+	b.ExpandKeyFree("click", func() bool { return true }, func(x *Workflow) error {
+		x.MouseClick(buttons.Left, image.Point{10, 20})
+		return nil
+	})
+	select {
+	case r := <-e.Submit(context.Background(), b, High):
+		if r.Err != nil {
+			t.Fatalf("unexpected error; %s", r.Err)
+		}
+	case r := <-aCh:
+		t.Fatalf("waiting workflow completed first; %v", r)
+	}
+	if got, want := venuelib.Code((<-aCh).Err), codes.DeadlineExceeded; got != want {
+		t.Errorf("waiting workflow error code = %s, want %s", got, want)
+	}
+}
+
+func TestCanPreempt(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		prio  Priority
+		build func(wf *Workflow)
+		want  bool
+	}{
+		{"click", High, func(wf *Workflow) { wf.MouseClick(buttons.Left, image.Point{10, 20}) }, true},
+		{"normal priority", Normal, func(wf *Workflow) { wf.MouseClick(buttons.Left, image.Point{10, 20}) }, false},
+		{"key", High, func(wf *Workflow) { wf.KeyPress(keys.Digit1) }, false},
+		{"expand", High, func(wf *Workflow) { wf.Expand("expand", func(*Workflow) error { return nil }) }, false},
+		{"key-free expand", High, func(wf *Workflow) {
+			wf.ExpandKeyFree("expand", func() bool { return true }, func(*Workflow) error { return nil })
+		}, true},
+		{"expand with keys", High, func(wf *Workflow) {
+			wf.ExpandKeyFree("expand", func() bool { return false }, func(*Workflow) error { return nil })
+		}, false},
+	} {
+		wf := NewWorkflow(NewMockConn())
+		tt.build(wf)
+		if got, want := canPreempt(&job{wf: wf, prio: tt.prio}), tt.want; got != want {
+			t.Errorf("%s: canPreempt() = %t, want %t", tt.desc, got, want)
+		}
+	}
+}
+
+func TestExecutorCoalesce(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor(Coalesce(4))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer e.Close()
+
+	a := NewWorkflow(conn)
+	a.KeyPress(keys.F1)
+	a.Sleep(100 * time.Millisecond)
+	aCh := e.Submit(context.Background(), a, Normal)
+	conn.WaitFor(t, 2)
+
+	// Queued while the first workflow executes, so they are merged.
+	var chs []<-chan Result
+	for _, steps := range []int{1, 2, -1} {
+		wf := adjust(keys.F1, image.Point{10, 20}, steps)
+		wf.conn = conn
+		chs = append(chs, e.Submit(context.Background(), wf, Normal))
+	}
+	if r := <-aCh; r.Err != nil {
+		t.Fatalf("unexpected error; %s", r.Err)
+	}
+	for _, ch := range chs {
+		r := <-ch
+		if r.Err != nil {
+			t.Fatalf("unexpected error; %s", r.Err)
+		}
+		if got, want := r.Sent, 11; got != want {
+			t.Errorf("sent = %d, want %d", got, want)
+		}
+	}
+
+	want := Events{
+		keyEvent{keys.F1, true}, keyEvent{keys.F1, false},
+		keyEvent{keys.F1, true}, keyEvent{keys.F1, false},
+		pointerEvent{buttons.None, 10, 20},
+		pointerEvent{buttons.Left, 10, 20},
+		pointerEvent{buttons.None, 10, 20},
+		keyEvent{keys.Up, true}, keyEvent{keys.Up, false},
+		keyEvent{keys.Up, true}, keyEvent{keys.Up, false},
+		keyEvent{keys.Return, true}, keyEvent{keys.Return, false},
+	}
+	if got := conn.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events; < %v > != < %v >", got, want)
+	}
+}
+
+func TestExecutorCoalesceCancel(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		before bool // Cancel before the queued workflows are merged.
+		codes  []codes.Code
+	}{
+		{"abandoned while queued", true, []codes.Code{codes.OK, codes.Canceled}},
+		{"abandoned while merged", false, []codes.Code{codes.Canceled, codes.Canceled}},
+	} {
+		conn := newSyncConn()
+		e, err := NewExecutor(Coalesce(4))
+		if err != nil {
+			t.Fatalf("unexpected error; %s", err)
+		}
+
+		a := NewWorkflow(conn)
+		a.KeyPress(keys.F1)
+		a.Sleep(100 * time.Millisecond)
+		aCh := e.Submit(context.Background(), a, Normal)
+		conn.WaitFor(t, 2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var chs []<-chan Result
+		for _, c := range []context.Context{context.Background(), ctx} {
+			wf := adjust(0, image.Point{10, 20}, 1)
+			wf.conn = conn
+			chs = append(chs, e.Submit(c, wf, Normal))
+		}
+		if tt.before {
+			cancel()
+		}
+		if r := <-aCh; r.Err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, r.Err)
+		}
+		if !tt.before {
+			conn.WaitFor(t, 3) // The click of the merged workflow.
+			cancel()
+		}
+		for i, ch := range chs {
+			if got, want := venuelib.Code((<-ch).Err), tt.codes[i]; got != want {
+				t.Errorf("%s: workflow %d error code = %s, want %s", tt.desc, i, got, want)
+			}
+		}
+		e.Close()
+	}
+}
+
+func TestExecutorClose(t *testing.T) {
+	conn := newSyncConn()
+	e, err := NewExecutor()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+
+	a := NewWorkflow(conn)
+	a.KeyPress(keys.Digit1)
+	a.Sleep(time.Hour)
+	aCh := e.Submit(context.Background(), a, Normal)
+	conn.WaitFor(t, 2)
+	b := NewWorkflow(conn)
+	b.KeyPress(keys.Digit2)
+	bCh := e.Submit(context.Background(), b, Normal)
+	e.Close()
+
+	for _, tt := range []struct {
+		desc string
+		ch   <-chan Result
+		sent int
+	}{
+		{"executing", aCh, 2},
+		{"queued", bCh, 0},
+		{"submitted after close", e.Submit(context.Background(), b, Normal), 0},
+	} {
+		r := <-tt.ch
+		if got, want := venuelib.Code(r.Err), codes.Canceled; got != want {
+			t.Errorf("%s: error code = %s, want %s", tt.desc, got, want)
+		}
+		if got, want := r.Sent, tt.sent; got != want {
+			t.Errorf("%s: sent = %d, want %d", tt.desc, got, want)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// syncConn is a mockConn that is safe for concurrent use.
+type syncConn struct {
+	mockConn
+	mu   sync.Mutex
+	sent chan struct{}
+}
+
+func newSyncConn() *syncConn { return &syncConn{sent: make(chan struct{}, 100)} }
+
+func (c *syncConn) KeyEvent(key keys.Key, down bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent <- struct{}{}
+	return c.mockConn.KeyEvent(key, down)
+}
+
+func (c *syncConn) PointerEvent(button buttons.Button, x, y uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent <- struct{}{}
+	return c.mockConn.PointerEvent(button, x, y)
+}
+
+// Events returns a copy of the events received.
+func (c *syncConn) Events() Events {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(Events{}, c.events...)
+}
+
+// WaitFor waits for n events to be received.
+func (c *syncConn) WaitFor(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.sent:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i+1)
+		}
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"encoding/base64"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/golang/glog"
 	vnclib "github.com/kward/go-vnc"
@@ -13,47 +17,304 @@ import (
 )
 
 // Framebuffer maintains a local copy of the remote VNC image.
+//
+// Updates are painted into a back buffer, and published as an immutable
+// Snapshot by Commit. Readers therefore always see a consistent frame, no
+// matter how long they hold on to it.
+//
+// Commit swaps the buffers: the back buffer becomes the image of the new
+// snapshot, and the buffer of an earlier snapshot that is no longer referenced
+// becomes the back buffer. Only the rectangles that changed since that earlier
+// snapshot are copied into it. A new buffer is copied in full only while every
+// earlier snapshot is still referenced.
 type Framebuffer struct {
-	fb *image.RGBA
+	mu      sync.Mutex // Protects back, dirty, history and free.
+	back    *image.RGBA
+	dirty   []image.Rectangle
+	history []commitDirty // Dirty rectangles of the latest commits, oldest first.
+	free    []buffer      // Buffers of the snapshots no longer referenced.
+
+	snap atomic.Pointer[Snapshot]
+
+	subMu sync.Mutex // Protects subs.
+	subs  []*Subscription
+}
+
+// maxHistory is the number of commits whose dirty rectangles are kept, to bring
+// free buffers up to date.
+const maxHistory = 8
+
+// commitDirty holds the rectangles changed by the commit of a version.
+type commitDirty struct {
+	version uint64
+	dirty   []image.Rectangle
+}
+
+// buffer is the image of a snapshot version.
+type buffer struct {
+	img     *image.RGBA
+	version uint64
 }
 
 // NewFramebuffer returns a new Framebuffer object.
 func NewFramebuffer(w, h int) *Framebuffer {
-	return &Framebuffer{
-		fb: image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{w, h}}),
-	}
+	r := image.Rectangle{image.Point{0, 0}, image.Point{w, h}}
+	f := &Framebuffer{back: image.NewRGBA(r)}
+	f.snap.Store(&Snapshot{img: image.NewRGBA(r)})
+	return f
 }
 
-// Paint accepts a Rectangle and Color data, and paints the framebuffer with it.
+// Paint accepts a Rectangle and Color data, and paints the back buffer with it.
 func (f *Framebuffer) Paint(r vnclib.Rectangle, colors []vnclib.Color) {
 	if glog.V(4) {
 		glog.Info(venuelib.FnNameWithArgs(r.String(), "colors"))
 	}
-	// TODO(kward): Implement double or triple buffering to reduce paint
-	// interference.
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	for x := 0; x < int(r.Width); x++ {
 		for y := 0; y < int(r.Height); y++ {
 			c := colors[x+y*int(r.Width)]
-			f.fb.SetRGBA(x+int(r.X), y+int(r.Y), color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255})
+			f.back.SetRGBA(x+int(r.X), y+int(r.Y), color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255})
+		}
+	}
+	f.markDirty(r)
+}
+
+// Copy copies the w x h pixels at `src` to the position of rectangle r, as
+// described by a CopyRect encoded rectangle. The regions may overlap.
+func (f *Framebuffer) Copy(r vnclib.Rectangle, src image.Point) {
+	if glog.V(4) {
+		glog.Info(venuelib.FnNameWithArgs(r.String(), src.String()))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dst := toRect(r)
+	tmp := image.NewRGBA(image.Rectangle{image.Point{}, dst.Size()})
+	draw.Draw(tmp, tmp.Bounds(), f.back, src, draw.Src)
+	draw.Draw(f.back, dst, tmp, image.Point{}, draw.Src)
+	f.markDirty(r)
+}
+
+// markDirty records that rectangle r of the back buffer has changed. The
+// caller must hold f.mu.
+func (f *Framebuffer) markDirty(r vnclib.Rectangle) {
+	if d := toRect(r).Intersect(f.back.Bounds()); !d.Empty() {
+		f.dirty = append(f.dirty, d)
+	}
+}
+
+// Commit publishes the back buffer as a new snapshot, and returns it. If
+// nothing was painted since the last commit, the current snapshot is returned.
+func (f *Framebuffer) Commit() *Snapshot {
+	f.mu.Lock()
+	prev := f.snap.Load()
+	if len(f.dirty) == 0 {
+		f.mu.Unlock()
+		return prev
+	}
+	s := &Snapshot{Version: prev.Version + 1, Dirty: f.dirty, img: f.back}
+	f.history = append(f.history, commitDirty{s.Version, f.dirty})
+	if len(f.history) > maxHistory {
+		f.history = f.history[len(f.history)-maxHistory:]
+	}
+	f.back = f.nextBack(s)
+	f.dirty = nil
+	f.snap.Store(s)
+	f.mu.Unlock()
+	// The image is recycled once the snapshot is no longer referenced.
+	runtime.AddCleanup(s, f.recycle, buffer{s.img, s.Version})
+	if glog.V(5) {
+		glog.Infof("Framebuffer.Commit() version:%d dirty:%v", s.Version, s.Dirty)
+	}
+
+	f.subMu.Lock()
+	subs := append([]*Subscription(nil), f.subs...)
+	f.subMu.Unlock()
+	for _, sub := range subs {
+		if sub.touched(s.Dirty) {
+			sub.notify(s)
+		}
+	}
+	return s
+}
+
+// nextBack returns the back buffer following the commit of snapshot s, which
+// is a free buffer brought up to date with s, else a copy of s. The caller must
+// hold f.mu.
+func (f *Framebuffer) nextBack(s *Snapshot) *image.RGBA {
+	for len(f.free) > 0 {
+		b := f.free[len(f.free)-1]
+		f.free = f.free[:len(f.free)-1]
+		if len(f.history) == 0 || b.version+1 < f.history[0].version {
+			continue // Too old to bring up to date.
 		}
+		for _, h := range f.history {
+			if h.version <= b.version {
+				continue
+			}
+			for _, r := range h.dirty {
+				draw.Draw(b.img, r, s.img, r.Min, draw.Src)
+			}
+		}
+		return b.img
 	}
+	img := image.NewRGBA(s.img.Bounds())
+	copy(img.Pix, s.img.Pix)
+	return img
 }
 
-// PNG converts the framebuffer into a base64 encoded PNG string.
-func (f *Framebuffer) PNG() (string, error) {
+// recycle frees buffer b, once its snapshot is no longer referenced.
+func (f *Framebuffer) recycle(b buffer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// A single free buffer is enough for the next commit; the newest is kept,
+	// as it has the least to copy.
+	if len(f.free) > 0 && f.free[0].version > b.version {
+		return
+	}
+	f.free = append(f.free[:0], b)
+}
+
+// Snapshot returns the most recently committed snapshot.
+func (f *Framebuffer) Snapshot() *Snapshot { return f.snap.Load() }
+
+// PNG converts the current snapshot into a base64 encoded PNG string.
+func (f *Framebuffer) PNG() (string, error) { return f.Snapshot().PNG() }
+
+// RGBAAt returns the color of the pixel at x, y of the current snapshot.
+func (f *Framebuffer) RGBAAt(x, y int) color.RGBA { return f.Snapshot().RGBAAt(x, y) }
+
+// Bounds returns the domain of the framebuffer.
+func (f *Framebuffer) Bounds() image.Rectangle { return f.Snapshot().Bounds() }
+
+// Width returns the width of the framebuffer.
+func (f *Framebuffer) Width() int { return f.Bounds().Max.X }
+
+// Height returns the height of the framebuffer.
+func (f *Framebuffer) Height() int { return f.Bounds().Max.Y }
+
+// Subscribe returns a subscription that receives a snapshot whenever a commit
+// changes rectangle r. Undelivered snapshots are replaced by newer ones, so a
+// slow receiver only ever sees the latest change.
+func (f *Framebuffer) Subscribe(r image.Rectangle) *Subscription {
+	sub := &Subscription{f: f, rect: r, ch: make(chan *Snapshot, 1)}
+	f.addSub(sub)
+	return sub
+}
+
+// SubscribeFunc returns a subscription that calls fn whenever a commit changes
+// rectangle r. The function is called from the goroutine calling Commit, and
+// must not block or modify the framebuffer.
+func (f *Framebuffer) SubscribeFunc(r image.Rectangle, fn func(*Snapshot)) *Subscription {
+	sub := &Subscription{f: f, rect: r, fn: fn}
+	f.addSub(sub)
+	return sub
+}
+
+func (f *Framebuffer) addSub(sub *Subscription) {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+	f.subs = append(f.subs, sub)
+}
+
+func (f *Framebuffer) removeSub(sub *Subscription) {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+	for i, s := range f.subs {
+		if s == sub {
+			f.subs = append(f.subs[:i], f.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Subscription delivers the snapshots that change a region of the framebuffer.
+type Subscription struct {
+	f    *Framebuffer
+	rect image.Rectangle
+	ch   chan *Snapshot
+	fn   func(*Snapshot)
+}
+
+// C returns the channel on which snapshots are delivered. It is nil for
+// subscriptions created with SubscribeFunc.
+func (s *Subscription) C() <-chan *Snapshot { return s.ch }
+
+// Rect returns the subscribed region.
+func (s *Subscription) Rect() image.Rectangle { return s.rect }
+
+// Close stops delivery to the subscription. The channel is not closed, as a
+// commit may be in flight.
+func (s *Subscription) Close() { s.f.removeSub(s) }
+
+// touched returns true if any of the dirty rectangles overlap the region.
+func (s *Subscription) touched(dirty []image.Rectangle) bool {
+	for _, d := range dirty {
+		if d.Overlaps(s.rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify delivers snapshot snap, replacing an undelivered one.
+func (s *Subscription) notify(snap *Snapshot) {
+	if s.fn != nil {
+		s.fn(snap)
+		return
+	}
+	for {
+		select {
+		case s.ch <- snap:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Snapshot is an immutable, consistent frame of the framebuffer.
+type Snapshot struct {
+	Version uint64            // Increases by one with every commit.
+	Dirty   []image.Rectangle // Rectangles changed since the previous version.
+
+	img *image.RGBA
+}
+
+// PNG converts the snapshot into a base64 encoded PNG string.
+func (s *Snapshot) PNG() (string, error) {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
 	var buf bytes.Buffer
-	err := png.Encode(&buf, f.fb)
+	err := png.Encode(&buf, s.img)
 	if err != nil {
 		return "", err
 	}
 	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
 
-// Width returns the width of the framebuffer.
-func (f *Framebuffer) Width() int { return f.fb.Bounds().Max.X }
+// Image returns a copy of the snapshot pixels, e.g. to draw on.
+func (s *Snapshot) Image() *image.RGBA {
+	img := image.NewRGBA(s.img.Bounds())
+	copy(img.Pix, s.img.Pix)
+	return img
+}
 
-// Height returns the height of the framebuffer.
-func (f *Framebuffer) Height() int { return f.fb.Bounds().Max.Y }
+// RGBAAt returns the color of the pixel at x, y.
+func (s *Snapshot) RGBAAt(x, y int) color.RGBA { return s.img.RGBAAt(x, y) }
+
+// Bounds returns the domain of the snapshot.
+func (s *Snapshot) Bounds() image.Rectangle { return s.img.Bounds() }
+
+// toRect converts a VNC rectangle into an image rectangle.
+func toRect(r vnclib.Rectangle) image.Rectangle {
+	return image.Rect(int(r.X), int(r.Y), int(r.X)+int(r.Width), int(r.Y)+int(r.Height))
+}
@@ -0,0 +1,206 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	vnclib "github.com/kward/go-vnc"
+)
+
+func TestFramebufferCommit(t *testing.T) {
+	fb := NewFramebuffer(4, 2)
+	s0 := fb.Snapshot()
+	if got, want := s0.Version, uint64(0); got != want {
+		t.Errorf("initial Version = %d, want %d", got, want)
+	}
+
+	fb.Paint(vnclib.Rectangle{X: 1, Width: 2, Height: 1}, toColors(red, red))
+	fb.Copy(vnclib.Rectangle{Y: 1, Width: 2, Height: 1}, image.Point{1, 0})
+	if got, want := fb.RGBAAt(1, 0), (color.RGBA{}); got != want {
+		t.Errorf("uncommitted RGBAAt() = %v, want %v", got, want)
+	}
+
+	s1 := fb.Commit()
+	if got, want := s1.Version, uint64(1); got != want {
+		t.Errorf("Version = %d, want %d", got, want)
+	}
+	if got, want := s1.Dirty, []image.Rectangle{image.Rect(1, 0, 3, 1), image.Rect(0, 1, 2, 2)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Dirty = %v, want %v", got, want)
+	}
+	if got, want := fb.Snapshot(), s1; got != want {
+		t.Errorf("Snapshot() = %p, want %p", got, want)
+	}
+	if got, want := s1.RGBAAt(0, 1), red; got != want {
+		t.Errorf("RGBAAt(0, 1) = %v, want %v", got, want)
+	}
+	if got, want := s0.RGBAAt(1, 0), (color.RGBA{}); got != want {
+		t.Errorf("previous snapshot RGBAAt() = %v, want %v", got, want)
+	}
+
+	// A commit without changes keeps the current snapshot.
+	if got, want := fb.Commit(), s1; got != want {
+		t.Errorf("empty Commit() = %p, want %p", got, want)
+	}
+
+	// Painting after a commit leaves the published snapshot untouched.
+	fb.Paint(vnclib.Rectangle{X: 1, Width: 1, Height: 1}, toColors(blue))
+	if got, want := s1.RGBAAt(1, 0), red; got != want {
+		t.Errorf("published RGBAAt() = %v, want %v", got, want)
+	}
+	s2 := fb.Commit()
+	if got, want := s2.Version, uint64(2); got != want {
+		t.Errorf("Version = %d, want %d", got, want)
+	}
+	if got, want := s2.Dirty, []image.Rectangle{image.Rect(1, 0, 2, 1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Dirty = %v, want %v", got, want)
+	}
+}
+
+func TestFramebufferCommitSwap(t *testing.T) {
+	fb := NewFramebuffer(4, 2)
+	fb.Paint(vnclib.Rectangle{Width: 1, Height: 1}, toColors(red))
+	img1 := fb.Commit().img
+	fb.Paint(vnclib.Rectangle{X: 1, Width: 1, Height: 1}, toColors(blue))
+	s2 := fb.Commit()
+	if s2.img == img1 {
+		t.Fatal("the back buffer is the image of a referenced snapshot")
+	}
+
+	// Once unreferenced, the first snapshot frees its buffer.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		fb.mu.Lock()
+		free := len(fb.free) > 0 && fb.free[0].img == img1
+		fb.mu.Unlock()
+		if free {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first snapshot buffer was not freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The freed buffer becomes the back buffer, brought up to date.
+	fb.Paint(vnclib.Rectangle{X: 2, Y: 1, Width: 1, Height: 1}, toColors(red))
+	s3 := fb.Commit()
+	fb.mu.Lock()
+	back := fb.back
+	fb.mu.Unlock()
+	if back != img1 {
+		t.Errorf("back buffer = %p, want the freed buffer %p", back, img1)
+	}
+	if !reflect.DeepEqual(back.Pix, s3.img.Pix) {
+		t.Errorf("back buffer = %v, want %v", back.Pix, s3.img.Pix)
+	}
+	if got, want := s2.RGBAAt(2, 1), (color.RGBA{}); got != want {
+		t.Errorf("previous snapshot RGBAAt() = %v, want %v", got, want)
+	}
+}
+
+func TestFramebufferConcurrentReads(t *testing.T) {
+	fb := NewFramebuffer(16, 16)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			c := red
+			if i%2 == 1 {
+				c = blue
+			}
+			fb.Paint(vnclib.Rectangle{Width: 16, Height: 16}, toColors(repeat(c, 256)...))
+			fb.Commit()
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		s := fb.Snapshot()
+		// Every pixel of a snapshot comes from the same frame.
+		want := s.RGBAAt(0, 0)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				if got := s.RGBAAt(x, y); got != want {
+					t.Fatalf("version %d: RGBAAt(%d, %d) = %v, want %v", s.Version, x, y, got, want)
+				}
+			}
+		}
+		if _, err := s.PNG(); err != nil {
+			t.Fatalf("unexpected error; %s", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestFramebufferSubscribe(t *testing.T) {
+	fb := NewFramebuffer(16, 16)
+	meter := fb.Subscribe(image.Rect(0, 0, 4, 16))
+	window := fb.Subscribe(image.Rect(8, 8, 16, 12))
+	defer meter.Close()
+	defer window.Close()
+
+	// Only the meter is touched.
+	fb.Paint(vnclib.Rectangle{X: 2, Y: 2, Width: 1, Height: 1}, toColors(red))
+	s1 := fb.Commit()
+	select {
+	case got := <-meter.C():
+		if got != s1 {
+			t.Errorf("meter received version %d, want %d", got.Version, s1.Version)
+		}
+	default:
+		t.Error("meter received no snapshot")
+	}
+	select {
+	case got := <-window.C():
+		t.Errorf("window received unexpected version %d", got.Version)
+	default:
+	}
+
+	// Undelivered snapshots are coalesced into the latest one.
+	fb.Paint(vnclib.Rectangle{X: 8, Y: 8, Width: 1, Height: 1}, toColors(red))
+	fb.Commit()
+	fb.Paint(vnclib.Rectangle{X: 9, Y: 9, Width: 1, Height: 1}, toColors(red))
+	s3 := fb.Commit()
+	if got := <-window.C(); got != s3 {
+		t.Errorf("window received version %d, want %d", got.Version, s3.Version)
+	}
+	select {
+	case got := <-window.C():
+		t.Errorf("window received unexpected version %d", got.Version)
+	default:
+	}
+
+	// Closed subscriptions receive nothing.
+	meter.Close()
+	fb.Paint(vnclib.Rectangle{Width: 16, Height: 16}, toColors(repeat(blue, 256)...))
+	fb.Commit()
+	select {
+	case got := <-meter.C():
+		t.Errorf("closed meter received version %d", got.Version)
+	default:
+	}
+}
+
+func TestFramebufferSubscribeFunc(t *testing.T) {
+	fb := NewFramebuffer(16, 16)
+	var got []uint64
+	sub := fb.SubscribeFunc(image.Rect(4, 4, 8, 8), func(s *Snapshot) { got = append(got, s.Version) })
+	defer sub.Close()
+
+	for _, r := range []vnclib.Rectangle{
+		{X: 4, Y: 4, Width: 1, Height: 1},   // Inside.
+		{X: 0, Y: 0, Width: 4, Height: 4},   // Adjacent.
+		{X: 0, Y: 0, Width: 16, Height: 16}, // Covering.
+	} {
+		fb.Paint(r, toColors(repeat(red, int(r.Width)*int(r.Height))...))
+		fb.Commit()
+	}
+	if want := []uint64{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("versions = %v, want %v", got, want)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/kward/go-vnc/buttons"
 	"github.com/kward/go-vnc/keys"
 	"github.com/kward/go-vnc/rfbflags"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
 )
 
 type mockConnListen struct {
@@ -93,3 +95,24 @@ func TestListenAndHandleCtx_ChannelClosedReturns(t *testing.T) {
 		t.Fatal("ListenAndHandleCtx did not return after channel close")
 	}
 }
+
+func TestListenAndHandleCtx_ConnectionLostReturns(t *testing.T) {
+	mc := newMockConnListen()
+	v := &VNC{conn: mc, cfg: &vnclib.ClientConfig{ServerMessageCh: make(chan vnclib.ServerMessage)}}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- v.ListenAndHandleCtx(context.Background()) }()
+	<-mc.startedCh
+
+	// Stop the listener to simulate a dropped connection.
+	mc.Close()
+
+	select {
+	case err := <-errCh:
+		if got, want := venuelib.Code(err), codes.Unavailable; got != want {
+			t.Errorf("ListenAndHandleCtx() error code = %s, want %s", got, want)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("ListenAndHandleCtx did not return after connection loss")
+	}
+}
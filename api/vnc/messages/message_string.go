@@ -4,9 +4,9 @@ package messages
 
 import "fmt"
 
-const _Message_name = "UnknownSetPixelFormatSetEncodingsFramebufferUpdateRequestKeyEventPointerEventClientCutTextFramebufferUpdateSetColorMapEntriesBellServerCutTextSleep"
+const _Message_name = "UnknownSetPixelFormatSetEncodingsFramebufferUpdateRequestKeyEventPointerEventClientCutTextFramebufferUpdateSetColorMapEntriesBellServerCutTextSleepWaitForRegionChangeWaitForPixelExpand"
 
-var _Message_index = [...]uint8{0, 7, 21, 33, 57, 65, 77, 90, 107, 125, 129, 142, 147}
+var _Message_index = [...]uint8{0, 7, 21, 33, 57, 65, 77, 90, 107, 125, 129, 142, 147, 166, 178, 184}
 
 func (i Message) String() string {
 	if i < 0 || i >= Message(len(_Message_index)-1) {
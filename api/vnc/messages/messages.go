@@ -26,4 +26,10 @@ const (
 
 	// Sleep enables a workflow to pause for a given duration.
 	Sleep
+	// WaitForRegionChange pauses a workflow until a framebuffer region changes.
+	WaitForRegionChange
+	// WaitForPixel pauses a workflow until a framebuffer pixel has a color.
+	WaitForPixel
+	// Expand adds workflow events once the workflow executes.
+	Expand
 )
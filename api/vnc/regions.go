@@ -0,0 +1,155 @@
+package vnc
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/rfbflags"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+// Region is a rectangle of interest of the framebuffer, which is refreshed
+// every Period.
+type Region struct {
+	Rect   image.Rectangle
+	Period time.Duration
+}
+
+// regionState tracks the refresh schedule of a region.
+type regionState struct {
+	Region
+	next time.Time // When the region is next due.
+	sent bool      // True once the initial, non-incremental, request was sent.
+}
+
+// regions holds the regions of interest of a VNC connection.
+type regions struct {
+	mu     sync.Mutex
+	states []*regionState
+	full   regionState   // Full-screen refresh, used when no regions are set.
+	wake   chan struct{} // Signals the refresh loop that the regions changed.
+}
+
+// SetRegions replaces the regions of interest of the framebuffer refresh. Only
+// these rectangles are requested from the server, each at its own period. With
+// no regions, the full screen is refreshed.
+func (v *VNC) SetRegions(rs ...Region) error {
+	if glog.V(3) {
+		glog.Info(venuelib.FnName())
+	}
+	states := make([]*regionState, 0, len(rs))
+	for _, r := range rs {
+		if r.Period <= 0 {
+			return venuelib.Errorf(codes.InvalidArgument, "invalid period %s for region %v", r.Period, r.Rect)
+		}
+		if v.fb != nil {
+			r.Rect = r.Rect.Intersect(v.fb.Bounds())
+		}
+		if r.Rect.Empty() {
+			return venuelib.Errorf(codes.InvalidArgument, "empty region %v", r.Rect)
+		}
+		states = append(states, &regionState{Region: r})
+	}
+
+	v.rois.mu.Lock()
+	// Regions already received in full only need incremental updates.
+	for _, s := range states {
+		s.sent = v.rois.received(s.Rect)
+	}
+	v.rois.states = states
+	wake := v.wakeCh()
+	v.rois.mu.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// received returns true if the full contents of rectangle r were requested from
+// the server. The caller must hold rois.mu.
+func (rois *regions) received(r image.Rectangle) bool {
+	if rois.full.sent && r.In(rois.full.Rect) {
+		return true
+	}
+	for _, s := range rois.states {
+		if s.sent && r.In(s.Rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// Regions returns the regions of interest of the framebuffer refresh.
+func (v *VNC) Regions() []Region {
+	v.rois.mu.Lock()
+	defer v.rois.mu.Unlock()
+	rs := make([]Region, len(v.rois.states))
+	for i, s := range v.rois.states {
+		rs[i] = s.Region
+	}
+	return rs
+}
+
+// wakeCh returns the channel that signals region changes. The caller must hold
+// v.rois.mu.
+func (v *VNC) wakeCh() chan struct{} {
+	if v.rois.wake == nil {
+		v.rois.wake = make(chan struct{}, 1)
+	}
+	return v.rois.wake
+}
+
+// refreshDue requests updates for the regions that are due at time `now`, and
+// returns when the next region is due. Without regions, the full screen is
+// refreshed every period `p`. The requests are sent once the regions are
+// unlocked, so that a slow connection does not block SetRegions.
+func (v *VNC) refreshDue(now time.Time, p time.Duration) time.Time {
+	type due struct {
+		rect        image.Rectangle
+		incremental bool
+	}
+	var (
+		next time.Time
+		reqs []due
+	)
+	v.rois.mu.Lock()
+	states := v.rois.states
+	if len(states) == 0 {
+		v.rois.full.Region = Region{v.fb.Bounds(), p}
+		states = []*regionState{&v.rois.full}
+	}
+	for _, s := range states {
+		if !now.Before(s.next) {
+			// The first request for a region is non-incremental, so that the
+			// server sends its full contents.
+			reqs = append(reqs, due{s.Rect, s.sent})
+			s.sent = true
+			s.next = now.Add(s.Period)
+		}
+		if next.IsZero() || s.next.Before(next) {
+			next = s.next
+		}
+	}
+	v.rois.mu.Unlock()
+
+	for _, r := range reqs {
+		if err := v.request(r.rect, r.incremental); err != nil {
+			glog.Errorf("framebuffer refresh error: %s", err)
+		}
+	}
+	return next
+}
+
+// request sends a FramebufferUpdateRequest for rectangle r.
+func (v *VNC) request(r image.Rectangle, incremental bool) error {
+	if glog.V(5) {
+		glog.Infof("request(%v, %t)", r, incremental)
+	}
+	return v.conn.FramebufferUpdateRequest(rfbflags.BoolToRFBFlag(incremental),
+		uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()))
+}
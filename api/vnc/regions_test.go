@@ -0,0 +1,178 @@
+package vnc
+
+import (
+	"context"
+	"image"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestRefreshDue(t *testing.T) {
+	meter := image.Rect(10, 10, 20, 60)
+	window := image.Rect(100, 10, 150, 20)
+	start := time.Unix(0, 0)
+
+	for _, tt := range []struct {
+		desc    string
+		regions []Region
+		times   []time.Duration // Offsets from start to call refreshDue at.
+		reqs    []request
+		next    time.Duration // Offset of the last returned due time.
+	}{
+		{"full screen", nil,
+			[]time.Duration{0, 500 * time.Millisecond, time.Second},
+			[]request{{false, image.Rect(0, 0, 200, 100)}, {true, image.Rect(0, 0, 200, 100)}},
+			2 * time.Second},
+		{"regions", []Region{{meter, 100 * time.Millisecond}, {window, time.Second}},
+			[]time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond},
+			[]request{{false, meter}, {false, window}, {true, meter}, {true, meter}},
+			300 * time.Millisecond},
+		{"clipped", []Region{{image.Rect(190, 90, 300, 300), time.Second}},
+			[]time.Duration{0},
+			[]request{{false, image.Rect(190, 90, 200, 100)}},
+			time.Second},
+	} {
+		conn := &mockConnRefresh{}
+		v := &VNC{conn: conn, fb: NewFramebuffer(200, 100)}
+		if err := v.SetRegions(tt.regions...); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		var next time.Time
+		for _, d := range tt.times {
+			next = v.refreshDue(start.Add(d), time.Second)
+		}
+		if got, want := conn.reqs, tt.reqs; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: requests = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := next.Sub(start), tt.next; got != want {
+			t.Errorf("%s: next = %s, want %s", tt.desc, got, want)
+		}
+	}
+}
+
+func TestSetRegionsReceived(t *testing.T) {
+	conn := &mockConnRefresh{}
+	v := &VNC{conn: conn, fb: NewFramebuffer(200, 100)}
+	start := time.Unix(0, 0)
+	v.refreshDue(start, time.Second)
+
+	// The full screen was requested, so the regions are only updated.
+	meter := image.Rect(10, 10, 20, 60)
+	if err := v.SetRegions(Region{meter, 100 * time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	v.refreshDue(start, time.Second)
+	want := []request{{false, image.Rect(0, 0, 200, 100)}, {true, meter}}
+	if got := conn.reqs; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestSetRegionsInvalid(t *testing.T) {
+	v := &VNC{fb: NewFramebuffer(200, 100)}
+	for _, tt := range []struct {
+		desc   string
+		region Region
+	}{
+		{"no period", Region{image.Rect(0, 0, 10, 10), 0}},
+		{"empty", Region{image.Rectangle{}, time.Second}},
+		{"off screen", Region{image.Rect(300, 300, 310, 310), time.Second}},
+	} {
+		if err := v.SetRegions(tt.region); err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+	}
+}
+
+func TestFramebufferRefreshCtxSetRegions(t *testing.T) {
+	conn := &mockConnRefresh{}
+	v := &VNC{conn: conn, fb: NewFramebuffer(200, 100)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { v.FramebufferRefreshCtx(ctx, time.Hour); close(done) }()
+
+	// Setting regions wakes the loop, rather than waiting for the hour.
+	meter := image.Rect(10, 10, 20, 60)
+	if err := v.SetRegions(Region{meter, time.Hour}); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !conn.requested(request{false, meter}) {
+		if time.Now().After(deadline) {
+			t.Fatal("region was not requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestRefreshDueUnlocked(t *testing.T) {
+	conn := &mockConnRefresh{}
+	v := &VNC{conn: conn, fb: NewFramebuffer(200, 100)}
+	// The regions must not be locked while requests are sent.
+	conn.onRequest = func() { v.Regions() }
+	if err := v.SetRegions(Region{image.Rect(10, 10, 20, 60), time.Second}); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	done := make(chan struct{})
+	go func() { v.refreshDue(time.Now(), time.Second); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refreshDue() deadlocked sending a request")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type request struct {
+	inc  bool
+	rect image.Rectangle
+}
+
+// mockConnRefresh records framebuffer update requests.
+type mockConnRefresh struct {
+	mu        sync.Mutex
+	reqs      []request
+	onRequest func() // Called on each request, when not nil.
+}
+
+func (m *mockConnRefresh) FramebufferHeight() uint16                          { return 0 }
+func (m *mockConnRefresh) FramebufferWidth() uint16                           { return 0 }
+func (m *mockConnRefresh) KeyEvent(_ keys.Key, _ bool) error                  { return nil }
+func (m *mockConnRefresh) PointerEvent(_ buttons.Button, _x, _y uint16) error { return nil }
+func (m *mockConnRefresh) Close() error                                       { return nil }
+func (m *mockConnRefresh) DebugMetrics()                                      {}
+func (m *mockConnRefresh) ListenAndHandle() error                             { return nil }
+
+func (m *mockConnRefresh) FramebufferUpdateRequest(inc rfbflags.RFBFlag, x, y, w, h uint16) error {
+	if m.onRequest != nil {
+		m.onRequest()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reqs = append(m.reqs, request{
+		inc:  rfbflags.ToBool(inc),
+		rect: image.Rect(int(x), int(y), int(x)+int(w), int(y)+int(h)),
+	})
+	return nil
+}
+
+func (m *mockConnRefresh) requested(r request) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, req := range m.reqs {
+		if req == r {
+			return true
+		}
+	}
+	return false
+}
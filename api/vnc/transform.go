@@ -0,0 +1,69 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Transform maps points on a reference screen onto the framebuffer. Points are
+// scaled about the origin, and then offset.
+type Transform struct {
+	Scale  float64     // Scale factor. Zero leaves points unscaled.
+	Offset image.Point // Framebuffer position of the reference screen origin.
+}
+
+// Identity is the transform that leaves points unchanged.
+var Identity = Transform{Scale: 1}
+
+// FitTransform returns the transform that fits a reference screen of size ref
+// onto a framebuffer of size fb. The screen is scaled uniformly to fill as much
+// of the framebuffer as possible, and centered along the other axis.
+func FitTransform(ref, fb image.Point) Transform {
+	scale := math.Min(float64(fb.X)/float64(ref.X), float64(fb.Y)/float64(ref.Y))
+	return Transform{
+		Scale: scale,
+		Offset: image.Point{
+			(fb.X - int(math.Round(float64(ref.X)*scale))) / 2,
+			(fb.Y - int(math.Round(float64(ref.Y)*scale))) / 2,
+		},
+	}
+}
+
+func (t Transform) String() string {
+	return fmt.Sprintf("scale %g, offset %s", t.scale(), t.Offset)
+}
+
+// IsIdentity returns true if the transform leaves points unchanged.
+func (t Transform) IsIdentity() bool { return t.scale() == 1 && t.Offset == image.Point{} }
+
+// Point maps reference point p onto the framebuffer.
+func (t Transform) Point(p image.Point) image.Point {
+	s := t.scale()
+	return image.Point{
+		int(math.Round(float64(p.X)*s)) + t.Offset.X,
+		int(math.Round(float64(p.Y)*s)) + t.Offset.Y,
+	}
+}
+
+// Rect maps reference rectangle r onto the framebuffer.
+func (t Transform) Rect(r image.Rectangle) image.Rectangle {
+	return image.Rectangle{t.Point(r.Min), t.Point(r.Max)}
+}
+
+// Pixel returns the framebuffer pixel sampled for reference pixel (x, y), i.e.
+// the pixel beneath the center of the scaled reference pixel.
+func (t Transform) Pixel(x, y int) image.Point {
+	s := t.scale()
+	return image.Point{
+		int(math.Floor((float64(x)+0.5)*s)) + t.Offset.X,
+		int(math.Floor((float64(y)+0.5)*s)) + t.Offset.Y,
+	}
+}
+
+func (t Transform) scale() float64 {
+	if t.Scale == 0 {
+		return 1
+	}
+	return t.Scale
+}
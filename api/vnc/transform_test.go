@@ -0,0 +1,56 @@
+package vnc
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFitTransform(t *testing.T) {
+	ref := image.Point{1024, 768}
+	for _, tt := range []struct {
+		desc string
+		fb   image.Point
+		t    Transform
+	}{
+		{"same size", image.Point{1024, 768}, Transform{Scale: 1}},
+		{"double size", image.Point{2048, 1536}, Transform{Scale: 2}},
+		{"wider", image.Point{1366, 768}, Transform{Scale: 1, Offset: image.Point{171, 0}}},
+		{"taller", image.Point{1280, 1024}, Transform{Scale: 1.25, Offset: image.Point{0, 32}}},
+	} {
+		if got, want := FitTransform(ref, tt.fb), tt.t; got != want {
+			t.Errorf("%s: FitTransform() = %s, want %s", tt.desc, got, want)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		t     Transform
+		p     image.Point
+		point image.Point
+		pixel image.Point
+	}{
+		{"zero", Transform{}, image.Point{932, 524}, image.Point{932, 524}, image.Point{932, 524}},
+		{"identity", Identity, image.Point{932, 524}, image.Point{932, 524}, image.Point{932, 524}},
+		{"offset", Transform{Scale: 1, Offset: image.Point{171, 0}}, image.Point{932, 524}, image.Point{1103, 524}, image.Point{1103, 524}},
+		{"scaled", Transform{Scale: 2}, image.Point{932, 524}, image.Point{1864, 1048}, image.Point{1865, 1049}},
+		{"fractional", Transform{Scale: 1.25, Offset: image.Point{0, 32}}, image.Point{1, 3}, image.Point{1, 36}, image.Point{1, 36}},
+	} {
+		if got, want := tt.t.Point(tt.p), tt.point; got != want {
+			t.Errorf("%s: Point(%s) = %s, want %s", tt.desc, tt.p, got, want)
+		}
+		if got, want := tt.t.Pixel(tt.p.X, tt.p.Y), tt.pixel; got != want {
+			t.Errorf("%s: Pixel(%d, %d) = %s, want %s", tt.desc, tt.p.X, tt.p.Y, got, want)
+		}
+		if got, want := tt.t.Rect(image.Rectangle{tt.p, tt.p}), (image.Rectangle{tt.point, tt.point}); got != want {
+			t.Errorf("%s: Rect() = %s, want %s", tt.desc, got, want)
+		}
+	}
+	if !Identity.IsIdentity() || !(Transform{}).IsIdentity() {
+		t.Errorf("IsIdentity() = false for an identity transform")
+	}
+	if (Transform{Scale: 2}).IsIdentity() {
+		t.Errorf("IsIdentity() = true for a scaling transform")
+	}
+}
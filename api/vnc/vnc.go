@@ -5,12 +5,12 @@ import (
 	"image"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	vnclib "github.com/kward/go-vnc"
 	"github.com/kward/go-vnc/buttons"
-	"github.com/kward/go-vnc/encodings"
 	"github.com/kward/go-vnc/keys"
 	"github.com/kward/go-vnc/messages"
 	"github.com/kward/go-vnc/rfbflags"
@@ -37,12 +37,39 @@ type ClientConn interface {
 	ListenAndHandle() error
 }
 
+// lockedConn serializes the client-to-server messages of a connection, as the
+// workflow executor and the framebuffer refresh send them concurrently, and the
+// go-vnc ClientConn is not safe for concurrent use.
+type lockedConn struct {
+	ClientConn
+	mu sync.Mutex // Serializes sends.
+}
+
+func (c *lockedConn) KeyEvent(key keys.Key, down bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ClientConn.KeyEvent(key, down)
+}
+
+func (c *lockedConn) PointerEvent(button buttons.Button, x, y uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ClientConn.PointerEvent(button, x, y)
+}
+
+func (c *lockedConn) FramebufferUpdateRequest(inc rfbflags.RFBFlag, x, y, w, h uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ClientConn.FramebufferUpdateRequest(inc, x, y, w, h)
+}
+
 // The VNC type contains various handles relating to a VNC connection.
 type VNC struct {
 	opts *options
 	cfg  *vnclib.ClientConfig
 	conn ClientConn
 	fb   *Framebuffer
+	rois regions
 }
 
 // New returns a populated VNC structure.
@@ -87,7 +114,23 @@ func (v *VNC) Connect(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	v.conn = conn
+	v.conn = &lockedConn{ClientConn: conn}
+
+	// Request a known pixel format, and advertise the encodings able to decode
+	// it. Raw is retained as the fallback.
+	if err := conn.SetPixelFormat(pixelFormat); err != nil {
+		conn.Close()
+		return venuelib.Errorf(codes.Internal, "failure calling SetPixelFormat; %s", err)
+	}
+	if err := conn.SetEncodings(vnclib.Encodings{
+		newCopyRectEncoding(nc),
+		newZRLEEncoding(nc, &pixelFormat),
+		newHextileEncoding(nc, &pixelFormat),
+		&vnclib.RawEncoding{},
+	}); err != nil {
+		conn.Close()
+		return venuelib.Errorf(codes.Internal, "failure calling SetEncodings; %s", err)
+	}
 
 	// Initialize a framebuffer for updates.
 	v.fb = NewFramebuffer(int(v.conn.FramebufferWidth()), int(v.conn.FramebufferHeight()))
@@ -97,18 +140,44 @@ func (v *VNC) Connect(ctx context.Context) error {
 	return nil
 }
 
+// ClientConn returns the VNC client connection. Its messages are serialized
+// with those of the framebuffer refresh.
 func (v *VNC) ClientConn() ClientConn {
 	return v.conn
 }
 
+// Framebuffer returns the local copy of the VNC framebuffer.
+func (v *VNC) Framebuffer() *Framebuffer {
+	return v.fb
+}
+
+// Subscribe returns a subscription to changes of rectangle r of the
+// framebuffer. See Framebuffer.Subscribe.
+func (v *VNC) Subscribe(r image.Rectangle) (*Subscription, error) {
+	if v.fb == nil {
+		return nil, venuelib.Errorf(codes.FailedPrecondition, "not connected")
+	}
+	return v.fb.Subscribe(r), nil
+}
+
+// SubscribeFunc returns a subscription that calls fn on changes of rectangle r
+// of the framebuffer. See Framebuffer.SubscribeFunc.
+func (v *VNC) SubscribeFunc(r image.Rectangle, fn func(*Snapshot)) (*Subscription, error) {
+	if v.fb == nil {
+		return nil, venuelib.Errorf(codes.FailedPrecondition, "not connected")
+	}
+	return v.fb.SubscribeFunc(r, fn), nil
+}
+
 // ListenAndHandle VNC server messages.
 // ListenAndHandle maintains backward compatibility by using a background context.
 // Deprecated: prefer ListenAndHandleCtx.
 func (v *VNC) ListenAndHandle() { v.ListenAndHandleCtx(context.Background()) }
 
-// ListenAndHandleCtx listens for server messages until the context is cancelled
-// or the ServerMessage channel is closed.
-func (v *VNC) ListenAndHandleCtx(ctx context.Context) {
+// ListenAndHandleCtx listens for server messages until the context is
+// cancelled, the ServerMessage channel is closed, or the connection is lost.
+// An Unavailable error is returned if the connection was lost.
+func (v *VNC) ListenAndHandleCtx(ctx context.Context) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
@@ -130,29 +199,23 @@ func (v *VNC) ListenAndHandleCtx(ctx context.Context) {
 			case <-done:
 			case <-time.After(2 * time.Second):
 			}
-			return
+			return nil
+		case <-done:
+			return venuelib.Errorf(codes.Unavailable, "VNC connection lost")
 		case msg, ok := <-v.cfg.ServerMessageCh:
 			if !ok {
 				if glog.V(2) {
 					glog.Info("ListenAndHandleCtx channel closed")
 				}
-				return
+				return nil
 			}
 			switch msg.Type() {
 			case messages.FramebufferUpdate:
 				if glog.V(5) {
 					glog.Info("ListenAndHandleCtx FramebufferUpdateMessage")
 				}
-				fu := msg.(*vnclib.FramebufferUpdate)
-				for i := uint16(0); i < fu.NumRect; i++ {
-					var colors []vnclib.Color
-					rect := fu.Rects[i]
-					switch rect.Enc.Type() {
-					case encodings.Raw:
-						colors = rect.Enc.(*vnclib.RawEncoding).Colors
-					}
-					v.fb.Paint(rect, colors)
-				}
+				v.paint(msg.(*vnclib.FramebufferUpdate))
+				v.fb.Commit()
 			default:
 				glog.Errorf("ListenAndHandleCtx unknown message type:%d msg:%s", msg.Type(), msg)
 			}
@@ -160,6 +223,25 @@ func (v *VNC) ListenAndHandleCtx(ctx context.Context) {
 	}
 }
 
+// paint applies the rectangles of a framebuffer update to the framebuffer.
+func (v *VNC) paint(fu *vnclib.FramebufferUpdate) {
+	for i := uint16(0); i < fu.NumRect; i++ {
+		rect := fu.Rects[i]
+		switch enc := rect.Enc.(type) {
+		case *vnclib.RawEncoding:
+			v.fb.Paint(rect, enc.Colors)
+		case *copyRectEncoding:
+			v.fb.Copy(rect, image.Point{int(enc.SrcX), int(enc.SrcY)})
+		case *hextileEncoding:
+			v.fb.Paint(rect, enc.Colors)
+		case *zrleEncoding:
+			v.fb.Paint(rect, enc.Colors)
+		default:
+			glog.Errorf("paint unsupported encoding:%s rect:%s", rect.Enc, &rect)
+		}
+	}
+}
+
 // FramebufferRefresh refreshes the local framebuffer image of the VNC server
 // every period `p`.
 // FramebufferRefresh maintains backward compatibility by using a background context.
@@ -167,27 +249,30 @@ func (v *VNC) ListenAndHandleCtx(ctx context.Context) {
 func (v *VNC) FramebufferRefresh(p time.Duration) { v.FramebufferRefreshCtx(context.Background(), p) }
 
 // FramebufferRefreshCtx periodically requests framebuffer updates until the
-// context is cancelled. If p == 0 a single refresh is performed.
+// context is cancelled. The regions of interest set with SetRegions are each
+// refreshed at their own period, and without any, the full screen is refreshed
+// every period `p`. If p == 0 a single refresh is performed.
 func (v *VNC) FramebufferRefreshCtx(ctx context.Context, p time.Duration) {
-	r := image.Rectangle{image.Point{0, 0}, image.Point{v.fb.Width(), v.fb.Height()}}
 	if p == 0 {
-		_ = v.Snapshot(r)
+		_ = v.Snapshot(v.fb.Bounds())
 		return
 	}
-	ticker := time.NewTicker(p)
-	defer ticker.Stop()
+	v.rois.mu.Lock()
+	wake := v.wakeCh()
+	v.rois.mu.Unlock()
 	for {
-		if err := v.Snapshot(r); err != nil {
-			glog.Errorf("framebuffer refresh error: %s", err)
-		}
+		next := v.refreshDue(time.Now(), p)
+		timer := time.NewTimer(time.Until(next))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			if glog.V(2) {
 				glog.Infof("FramebufferRefreshCtx stopping: %v", ctx.Err())
 			}
 			return
-		case <-ticker.C:
-			continue
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
 		}
 	}
 }
@@ -197,9 +282,7 @@ func (v *VNC) Snapshot(r image.Rectangle) error {
 	if glog.V(5) {
 		glog.Infof("Snapshot(%v)\n", r)
 	}
-	w, h := uint16(r.Max.X-r.Min.X), uint16(r.Max.Y-r.Min.Y)
-	if err := v.conn.FramebufferUpdateRequest(
-		rfbflags.RFBTrue, uint16(r.Min.X), uint16(r.Min.Y), w, h); err != nil {
+	if err := v.request(r, true); err != nil {
 		glog.Errorf("Snapshot() error: %s", err)
 		return err
 	}
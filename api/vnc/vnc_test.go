@@ -1,5 +1,16 @@
 package vnc
 
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
 // func TestOverlay(t *testing.T) {
 // 	for _, tt := range []struct {
 // 		a, b, c *image.RGBA
@@ -33,3 +44,60 @@ package vnc
 // 		}
 // 	}
 // }
+
+func TestLockedConn(t *testing.T) {
+	mc := &mockConnSend{}
+	c := &lockedConn{ClientConn: mc}
+	var wg sync.WaitGroup
+	for _, send := range []func(){
+		func() { c.KeyEvent(keys.Up, true) },
+		func() { c.PointerEvent(buttons.Left, 1, 2) },
+		func() { c.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 10, 10) },
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				send()
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := mc.sends.Load(), int32(60); got != want {
+		t.Errorf("got %d sends, want %d", got, want)
+	}
+	if mc.overlapped.Load() {
+		t.Error("sends overlapped")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// mockConnSend records whether sends overlap.
+type mockConnSend struct {
+	inFlight   atomic.Int32
+	sends      atomic.Int32
+	overlapped atomic.Bool
+}
+
+func (m *mockConnSend) send() error {
+	if m.inFlight.Add(1) > 1 {
+		m.overlapped.Store(true)
+	}
+	time.Sleep(100 * time.Microsecond)
+	m.inFlight.Add(-1)
+	m.sends.Add(1)
+	return nil
+}
+
+func (m *mockConnSend) FramebufferHeight() uint16                        { return 0 }
+func (m *mockConnSend) FramebufferWidth() uint16                         { return 0 }
+func (m *mockConnSend) KeyEvent(_ keys.Key, _ bool) error                { return m.send() }
+func (m *mockConnSend) PointerEvent(_ buttons.Button, _, _ uint16) error { return m.send() }
+func (m *mockConnSend) Close() error                                     { return nil }
+func (m *mockConnSend) DebugMetrics()                                    {}
+func (m *mockConnSend) ListenAndHandle() error                           { return nil }
+
+func (m *mockConnSend) FramebufferUpdateRequest(_ rfbflags.RFBFlag, _, _, _, _ uint16) error {
+	return m.send()
+}
@@ -0,0 +1,698 @@
+/*
+Package vnctest provides an in-process RFB server for testing VNC clients.
+
+The server speaks the RFB 3.8 handshake, optionally with VNC authentication,
+and serves a synthetic framebuffer made up of widgets. Key and pointer events
+received from the client are recorded, and passed on to the widgets so that
+their pixels change as they are clicked or typed into.
+
+	s, err := vnctest.NewServer(vnctest.Password("secret"))
+	...
+	defer s.Close()
+	mute := &vnctest.Toggle{Rect: image.Rect(10, 10, 36, 26), On: yellow}
+	s.Add(mute)
+	// Connect a client to s.Host() and s.Port().
+*/
+package vnctest
+
+import (
+	"bytes"
+	"crypto/des"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	vnclib "github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+const (
+	protocolVersion = "RFB 003.008\n"
+	secTypeNone     = 1
+	secTypeVNCAuth  = 2
+)
+
+// defaultPixelFormat is used until the client requests another one.
+var defaultPixelFormat = vnclib.PixelFormat{
+	BPP:        32,
+	Depth:      24,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     255,
+	GreenMax:   255,
+	BlueMax:    255,
+	RedShift:   16,
+	GreenShift: 8,
+	BlueShift:  0,
+}
+
+// Event is a key or pointer event received from a client.
+type Event struct {
+	Type   messages.ClientMessage // messages.KeyEvent or messages.PointerEvent.
+	Key    keys.Key               // Key events only.
+	Down   bool                   // Key events only.
+	Button buttons.Button         // Pointer events only.
+	Point  image.Point            // Pointer events only.
+}
+
+// String implements the fmt.Stringer interface.
+func (e Event) String() string {
+	if e.Type == messages.KeyEvent {
+		return fmt.Sprintf("key %s down:%t", e.Key, e.Down)
+	}
+	return fmt.Sprintf("pointer %s %v", e.Button, e.Point)
+}
+
+// Server is an in-process RFB server.
+type Server struct {
+	opts *options
+	ln   net.Listener
+
+	mu      sync.Mutex // Protects the fields below.
+	img     *image.RGBA
+	widgets []Widget
+	events  []Event
+	eventCh chan struct{} // Closed, and replaced, when an event is recorded.
+	conns   map[*conn]bool
+}
+
+// NewServer returns a started server, listening on a local port.
+func NewServer(opts ...func(*options) error) (*Server, error) {
+	o := &options{name: "VENUE"}
+	o.setSize(1024, 768)
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		opts:    o,
+		ln:      ln,
+		img:     image.NewRGBA(image.Rect(0, 0, o.width, o.height)),
+		eventCh: make(chan struct{}),
+		conns:   map[*conn]bool{},
+	}
+	fill(s.img, s.img.Bounds(), o.background)
+	go s.serve()
+	return s, nil
+}
+
+// Host returns the host the server listens on.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.ln.Addr().String())
+	return host
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() uint {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return uint(p)
+}
+
+// Close stops the server, and drops all client connections.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.Disconnect()
+	return err
+}
+
+// Disconnect drops all client connections, while continuing to accept new
+// ones. It simulates a network failure.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+}
+
+// Add adds widgets to the framebuffer, and draws them.
+func (s *Server) Add(ws ...Widget) {
+	s.update(func() bool {
+		for _, w := range ws {
+			s.widgets = append(s.widgets, w)
+			w.Draw(s.img)
+		}
+		return true
+	})
+}
+
+// Fill paints rectangle r of the framebuffer with color c.
+func (s *Server) Fill(r image.Rectangle, c color.RGBA) {
+	s.update(func() bool {
+		fill(s.img, r, c)
+		return true
+	})
+}
+
+// Do calls fn with the server lock held, e.g. to inspect or change a widget,
+// and redraws the widgets afterwards.
+func (s *Server) Do(fn func()) {
+	s.update(func() bool {
+		fn()
+		return true
+	})
+}
+
+// RGBAAt returns the color of the server pixel at x, y.
+func (s *Server) RGBAAt(x, y int) color.RGBA {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.img.RGBAAt(x, y)
+}
+
+// Events returns the events received so far.
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// ClearEvents forgets the events received so far.
+func (s *Server) ClearEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = nil
+}
+
+// WaitForEvents waits until at least n events were received, and returns them.
+func (s *Server) WaitForEvents(n int, timeout time.Duration) ([]Event, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		events, ch := append([]Event(nil), s.events...), s.eventCh
+		s.mu.Unlock()
+		if len(events) >= n {
+			return events, nil
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return events, venuelib.Errorf(codes.DeadlineExceeded, "received %d of %d events", len(events), n)
+		}
+	}
+}
+
+// update calls fn with the server lock held. If fn reports a change, the
+// widgets are redrawn and pending client requests are answered.
+func (s *Server) update(fn func() bool) {
+	s.mu.Lock()
+	changed := fn()
+	if changed {
+		for _, w := range s.widgets {
+			w.Draw(s.img)
+		}
+	}
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	if changed {
+		for _, c := range conns {
+			c.flush()
+		}
+	}
+}
+
+// record records event e, and passes it on to the widgets.
+func (s *Server) record(e Event, pressed bool) {
+	s.update(func() bool {
+		s.events = append(s.events, e)
+		close(s.eventCh)
+		s.eventCh = make(chan struct{})
+
+		changed := false
+		for _, w := range s.widgets {
+			switch {
+			case e.Type == messages.KeyEvent && e.Down:
+				changed = w.Key(e.Key) || changed
+			case e.Type == messages.PointerEvent && pressed:
+				changed = w.Click(e.Point) || changed
+			}
+		}
+		return changed
+	})
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return // Listener closed.
+		}
+		go s.handle(nc)
+	}
+}
+
+// handle serves a single client connection.
+func (s *Server) handle(nc net.Conn) {
+	defer nc.Close()
+	c := &conn{s: s, nc: nc, pf: defaultPixelFormat}
+	if err := c.handshake(); err != nil {
+		if glog.V(2) {
+			glog.Infof("vnctest handshake failed; %s", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	if err := c.serve(); err != nil && err != io.EOF {
+		if glog.V(2) {
+			glog.Infof("vnctest connection closed; %s", err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// conn is a client connection.
+type conn struct {
+	s  *Server
+	nc net.Conn
+
+	mu      sync.Mutex // Protects the fields below, and serializes writes.
+	pf      vnclib.PixelFormat
+	full    image.Rectangle   // Requested area to send whole.
+	inc     []image.Rectangle // Requested areas to send once changed.
+	hextile bool              // True if the client accepts Hextile rectangles.
+	shadow  *image.RGBA       // Framebuffer as last sent to the client.
+	buttons buttons.Button
+}
+
+type updateRequest struct {
+	Inc           uint8
+	X, Y          uint16
+	Width, Height uint16
+}
+
+func (c *conn) read(data interface{}) error {
+	return binary.Read(c.nc, binary.BigEndian, data)
+}
+
+func (c *conn) write(data interface{}) error {
+	return binary.Write(c.nc, binary.BigEndian, data)
+}
+
+// handshake implements the server side of the RFB 3.8 handshake.
+func (c *conn) handshake() error {
+	if _, err := io.WriteString(c.nc, protocolVersion); err != nil {
+		return err
+	}
+	var pv [12]byte
+	if err := c.read(&pv); err != nil {
+		return err
+	}
+	if string(pv[:]) != protocolVersion {
+		return fmt.Errorf("unsupported protocol version %q", pv)
+	}
+
+	secType := uint8(secTypeNone)
+	if c.s.opts.passwd != "" {
+		secType = secTypeVNCAuth
+	}
+	if err := c.write([]uint8{1, secType}); err != nil {
+		return err
+	}
+	var chosen uint8
+	if err := c.read(&chosen); err != nil {
+		return err
+	}
+	if chosen != secType {
+		return fmt.Errorf("client chose security type %d, want %d", chosen, secType)
+	}
+	// The go-vnc client does not expect a SecurityResult for the None security
+	// type, as was the case with RFB 3.3.
+	if secType == secTypeVNCAuth {
+		if err := c.authenticate(); err != nil {
+			return err
+		}
+	}
+
+	var shared uint8
+	if err := c.read(&shared); err != nil {
+		return err
+	}
+	name := c.s.opts.name
+	if err := c.write(struct {
+		Width, Height uint16
+		PF            vnclib.PixelFormat
+		NameLength    uint32
+	}{uint16(c.s.opts.width), uint16(c.s.opts.height), c.pf, uint32(len(name))}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.nc, name)
+	return err
+}
+
+// authenticate implements VNC authentication, sending the SecurityResult.
+func (c *conn) authenticate() error {
+	var challenge [16]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return err
+	}
+	if err := c.write(challenge); err != nil {
+		return err
+	}
+	var response [16]byte
+	if err := c.read(&response); err != nil {
+		return err
+	}
+	want, err := vncAuthResponse(c.s.opts.passwd, challenge)
+	if err != nil {
+		return err
+	}
+	if response == want {
+		return c.write(uint32(0))
+	}
+	reason := "authentication failed"
+	if err := c.write([]uint32{1, uint32(len(reason))}); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(c.nc, reason); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s", reason)
+}
+
+// vncAuthResponse returns the expected response to a VNC authentication
+// challenge. The challenge is DES encrypted with the password as key, with the
+// bits of each key byte reversed.
+func vncAuthResponse(passwd string, challenge [16]byte) ([16]byte, error) {
+	key := make([]byte, 8)
+	copy(key, passwd)
+	for i, b := range key {
+		var r byte
+		for j := 0; j < 8; j++ {
+			r = r<<1 | (b>>uint(j))&1
+		}
+		key[i] = r
+	}
+	cipher, err := des.NewCipher(key)
+	if err != nil {
+		return challenge, err
+	}
+	for i := 0; i < len(challenge); i += cipher.BlockSize() {
+		cipher.Encrypt(challenge[i:i+cipher.BlockSize()], challenge[i:i+cipher.BlockSize()])
+	}
+	return challenge, nil
+}
+
+// serve handles client messages until the connection is closed.
+func (c *conn) serve() error {
+	for {
+		var t messages.ClientMessage
+		if err := c.read(&t); err != nil {
+			return err
+		}
+		switch t {
+		case messages.SetPixelFormat:
+			var msg struct {
+				_  [3]byte
+				PF vnclib.PixelFormat
+			}
+			if err := c.read(&msg); err != nil {
+				return err
+			}
+			if !rfbflags.IsTrueColor(msg.PF.TrueColor) {
+				return fmt.Errorf("color map pixel formats are unsupported")
+			}
+			c.mu.Lock()
+			c.pf = msg.PF
+			c.mu.Unlock()
+
+		case messages.SetEncodings:
+			var msg struct {
+				_ [1]byte
+				N uint16
+			}
+			if err := c.read(&msg); err != nil {
+				return err
+			}
+			// Hextile rectangles are sent to clients accepting them, being far
+			// smaller for the mostly solid screen, and Raw ones to the others.
+			encs := make([]encodings.Encoding, msg.N)
+			if err := c.read(&encs); err != nil {
+				return err
+			}
+			hextile := false
+			for _, e := range encs {
+				hextile = hextile || e == encodings.Hextile
+			}
+			c.mu.Lock()
+			c.hextile = hextile
+			c.mu.Unlock()
+
+		case messages.FramebufferUpdateRequest:
+			var req updateRequest
+			if err := c.read(&req); err != nil {
+				return err
+			}
+			// Requests accumulate until answered, as with real servers. Areas
+			// already pending are compared again as the widgets change.
+			r := image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+			c.mu.Lock()
+			pending := false
+			if req.Inc != 0 {
+				pending = !c.request(r)
+			} else {
+				c.full = c.full.Union(r)
+			}
+			c.mu.Unlock()
+			if !pending {
+				c.flush()
+			}
+
+		case messages.KeyEvent:
+			var msg struct {
+				Down uint8
+				_    [2]byte
+				Key  keys.Key
+			}
+			if err := c.read(&msg); err != nil {
+				return err
+			}
+			c.s.record(Event{Type: messages.KeyEvent, Key: msg.Key, Down: msg.Down != 0}, false)
+
+		case messages.PointerEvent:
+			var msg struct {
+				Mask buttons.Button
+				X, Y uint16
+			}
+			if err := c.read(&msg); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			pressed := msg.Mask&buttons.Left != 0 && c.buttons&buttons.Left == 0
+			c.buttons = msg.Mask
+			c.mu.Unlock()
+			c.s.record(Event{
+				Type:   messages.PointerEvent,
+				Button: msg.Mask,
+				Point:  image.Point{int(msg.X), int(msg.Y)},
+			}, pressed)
+
+		case messages.ClientCutText:
+			var msg struct {
+				_ [3]byte
+				N uint32
+			}
+			if err := c.read(&msg); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, c.nc, int64(msg.N)); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported client message type %d", t)
+		}
+	}
+}
+
+// request adds rectangle r to the incrementally requested areas, and returns
+// false if it was requested already. The areas are kept apart rather than
+// merged, so that requests for a few small regions far apart are not compared
+// across the whole screen in between.
+func (c *conn) request(r image.Rectangle) bool {
+	for i, ir := range c.inc {
+		switch {
+		case r.In(ir):
+			return false
+		case ir.In(r):
+			c.inc[i] = r
+			return true
+		}
+	}
+	c.inc = append(c.inc, r)
+	return true
+}
+
+// flush answers the pending update requests. Incrementally requested areas are
+// only answered once pixels within them differ from those last sent, and then
+// only the changed area is sent.
+func (c *conn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.full.Empty() && len(c.inc) == 0 {
+		return
+	}
+
+	c.s.mu.Lock()
+	b := c.s.img.Bounds()
+	if c.shadow == nil {
+		c.shadow = image.NewRGBA(b)
+	}
+	r := c.full.Intersect(b)
+	for _, ir := range c.inc {
+		r = r.Union(changed(c.shadow, c.s.img, ir.Intersect(b)))
+	}
+	if r.Empty() {
+		c.s.mu.Unlock()
+		return
+	}
+	draw.Draw(c.shadow, r, c.s.img, r.Min, draw.Src)
+	enc, pixels := encodings.Raw, []byte(nil)
+	if c.hextile {
+		enc, pixels = encodings.Hextile, encodeHextile(&c.pf, c.s.img, r)
+	} else {
+		pixels = encodeRaw(&c.pf, c.s.img, r)
+	}
+	c.s.mu.Unlock()
+
+	c.full, c.inc = image.Rectangle{}, nil
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, struct {
+		Type    messages.ServerMessage
+		_       [1]byte
+		NumRect uint16
+		X, Y    uint16
+		W, H    uint16
+		Enc     encodings.Encoding
+	}{messages.FramebufferUpdate, [1]byte{}, 1,
+		uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()), enc})
+	buf.Write(pixels)
+	if _, err := c.nc.Write(buf.Bytes()); err != nil && glog.V(2) {
+		glog.Infof("vnctest update failed; %s", err)
+	}
+}
+
+// changed returns the bounding box of the pixels within rectangle r that
+// differ between images a and b. Rows are compared whole first, as clients
+// request updates far more often than the pixels change.
+func changed(a, b *image.RGBA, r image.Rectangle) image.Rectangle {
+	var bb image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i, j := a.PixOffset(r.Min.X, y), b.PixOffset(r.Min.X, y)
+		if bytes.Equal(a.Pix[i:i+4*r.Dx()], b.Pix[j:j+4*r.Dx()]) {
+			continue
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				bb = bb.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return bb
+}
+
+// Hextile tile subencodings, see RFC 6143 §7.7.4.
+const (
+	hextileRaw                 = 1
+	hextileBackgroundSpecified = 2
+	hextileSize                = 16
+)
+
+// encodeHextile returns the Hextile tiles of rectangle r of img in pixel format
+// pf. Solid tiles are sent as their background color, and the others raw.
+func encodeHextile(pf *vnclib.PixelFormat, img *image.RGBA, r image.Rectangle) []byte {
+	var buf bytes.Buffer
+	for ty := r.Min.Y; ty < r.Max.Y; ty += hextileSize {
+		for tx := r.Min.X; tx < r.Max.X; tx += hextileSize {
+			t := image.Rect(tx, ty, tx+hextileSize, ty+hextileSize).Intersect(r)
+			if solid(img, t) {
+				buf.WriteByte(hextileBackgroundSpecified)
+				buf.Write(encodeRaw(pf, img, image.Rectangle{t.Min, t.Min.Add(image.Point{1, 1})}))
+				continue
+			}
+			buf.WriteByte(hextileRaw)
+			buf.Write(encodeRaw(pf, img, t))
+		}
+	}
+	return buf.Bytes()
+}
+
+// solid returns true if all pixels of rectangle r of img are the same color.
+func solid(img *image.RGBA, r image.Rectangle) bool {
+	c := img.RGBAAt(r.Min.X, r.Min.Y)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.RGBAAt(x, y) != c {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// encodeRaw returns the pixels of rectangle r of img in pixel format pf.
+func encodeRaw(pf *vnclib.PixelFormat, img *image.RGBA, r image.Rectangle) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if rfbflags.IsBigEndian(pf.BigEndian) {
+		order = binary.BigEndian
+	}
+	scale := func(v uint8, max uint16, shift uint8) uint32 {
+		return uint32(v) * uint32(max) / 255 << shift
+	}
+	bpp := int(pf.BPP / 8)
+	buf := make([]byte, r.Dx()*r.Dy()*bpp)
+	i := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			px := scale(c.R, pf.RedMax, pf.RedShift) |
+				scale(c.G, pf.GreenMax, pf.GreenShift) |
+				scale(c.B, pf.BlueMax, pf.BlueShift)
+			switch bpp {
+			case 1:
+				buf[i] = byte(px)
+			case 2:
+				order.PutUint16(buf[i:], uint16(px))
+			case 4:
+				order.PutUint32(buf[i:], px)
+			}
+			i += bpp
+		}
+	}
+	return buf
+}
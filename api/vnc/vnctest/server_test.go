@@ -0,0 +1,217 @@
+package vnctest
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/venue/api/vnc"
+)
+
+var (
+	background = color.RGBA{40, 40, 40, 255}
+	ledOn      = color.RGBA{250, 210, 30, 255}
+	ledOff     = color.RGBA{70, 70, 70, 255}
+)
+
+func TestServer(t *testing.T) {
+	s, err := NewServer(Password("secret"), Size(320, 240), Background(background))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer s.Close()
+	mute := &Toggle{Rect: image.Rect(10, 10, 36, 26), Off: ledOff, On: ledOn}
+	s.Add(mute)
+
+	v := connect(t, s, "secret")
+	defer v.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := v.Subscribe(mute.Rect)
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer sub.Close()
+	go v.ListenAndHandleCtx(ctx)
+
+	// Requests are sent from this goroutine only, as the go-vnc client does not
+	// support concurrent senders.
+	if err := v.Snapshot(image.Rect(0, 0, 320, 240)); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := waitForColor(t, sub, 20, 15, ledOff), ledOff; got != want {
+		t.Fatalf("initial mute color = %v, want %v", got, want)
+	}
+	if got, want := v.Framebuffer().RGBAAt(100, 100), background; got != want {
+		t.Errorf("background color = %v, want %v", got, want)
+	}
+
+	// Clicking the toggle changes its pixels on the client.
+	wf := vnc.NewWorkflow(v.ClientConn())
+	wf.MouseClick(buttons.Left, image.Point{20, 15})
+	wf.KeyPress(keys.Digit1)
+	if err := wf.Execute(); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if err := v.Snapshot(mute.Rect); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := waitForColor(t, sub, 20, 15, ledOn), ledOn; got != want {
+		t.Errorf("clicked mute color = %v, want %v", got, want)
+	}
+	s.Do(func() {
+		if !mute.State {
+			t.Error("mute was not toggled")
+		}
+	})
+
+	events, err := s.WaitForEvents(4, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	want := []Event{
+		{Type: messages.PointerEvent, Button: buttons.Left, Point: image.Point{20, 15}},
+		{Type: messages.PointerEvent, Button: buttons.None, Point: image.Point{20, 15}},
+		{Type: messages.KeyEvent, Key: keys.Digit1, Down: true},
+		{Type: messages.KeyEvent, Key: keys.Digit1, Down: false},
+	}
+	if got := events[len(events)-4:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	s, err := NewServer(Password("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer s.Close()
+
+	v, err := vnc.New(vnc.Host(s.Host()), vnc.Port(s.Port()), vnc.Password("wrong"))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx); err == nil {
+		t.Error("expected an error connecting with the wrong password")
+	}
+}
+
+func TestServerDisconnect(t *testing.T) {
+	s, err := NewServer(Password("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer s.Close()
+
+	v := connect(t, s, "secret")
+	errCh := make(chan error, 1)
+	go func() { errCh <- v.ListenAndHandleCtx(context.Background()) }()
+	s.Disconnect()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected an error after the server disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndHandleCtx did not return after disconnect")
+	}
+
+	// The server continues to accept connections.
+	connect(t, s, "secret").Close()
+}
+
+func TestWidgets(t *testing.T) {
+	tabs := &Tabs{
+		Tabs: []Tab{
+			{Rect: image.Rect(0, 0, 10, 10), Key: keys.F1},
+			{Rect: image.Rect(10, 0, 20, 10), Key: keys.F2},
+		},
+		Off: ledOff, On: ledOn,
+	}
+	field := &Field{Rect: image.Rect(0, 20, 40, 30), Color: background}
+	button := &Button{Rect: image.Rect(0, 40, 10, 50), Color: ledOff}
+	channel := &Channel{Rect: image.Rect(0, 60, 40, 70), Off: ledOff, On: ledOn, Digits: 2}
+
+	for _, tt := range []struct {
+		desc     string
+		click    *image.Point
+		key      keys.Key
+		selected int
+		text     string
+		presses  int
+		channel  int
+	}{
+		{"select tab by key", nil, keys.F2, 1, "", 0, 0},
+		{"select tab by click", &image.Point{5, 5}, 0, 0, "", 0, 0},
+		{"unfocused typing", nil, keys.Digit1, 0, "", 0, 0},
+		{"focus field", &image.Point{5, 25}, 0, 0, "", 0, 0},
+		{"type", nil, keys.Digit4, 0, "4", 0, 14},
+		{"type more", nil, keys.Digit2, 0, "42", 0, 14},
+		{"backspace", nil, keys.BackSpace, 0, "4", 0, 14},
+		{"return", nil, keys.Return, 0, "4", 0, 14},
+		{"typing after return", nil, keys.Digit1, 0, "4", 0, 14},
+		{"press button", &image.Point{5, 45}, 0, 0, "4", 1, 14},
+		{"select channel", nil, keys.Digit2, 0, "4", 1, 12},
+	} {
+		for _, w := range []Widget{tabs, field, button, channel} {
+			if tt.click != nil {
+				w.Click(*tt.click)
+			} else {
+				w.Key(tt.key)
+			}
+		}
+		if got, want := tabs.Selected, tt.selected; got != want {
+			t.Errorf("%s: Selected = %d, want %d", tt.desc, got, want)
+		}
+		if got, want := field.Text, tt.text; got != want {
+			t.Errorf("%s: Text = %q, want %q", tt.desc, got, want)
+		}
+		if got, want := button.Presses, tt.presses; got != want {
+			t.Errorf("%s: Presses = %d, want %d", tt.desc, got, want)
+		}
+		if got, want := channel.Selected, tt.channel; got != want {
+			t.Errorf("%s: Selected channel = %d, want %d", tt.desc, got, want)
+		}
+	}
+}
+
+// connect returns a VNC client connected to server s.
+func connect(t *testing.T, s *Server, passwd string) *vnc.VNC {
+	t.Helper()
+	v, err := vnc.New(vnc.Host(s.Host()), vnc.Port(s.Port()), vnc.Password(passwd))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	return v
+}
+
+// waitForColor waits for the client pixel at x, y to change to color c, and
+// returns the last color seen.
+func waitForColor(t *testing.T, sub *vnc.Subscription, x, y int, c color.RGBA) color.RGBA {
+	t.Helper()
+	var got color.RGBA
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case snap := <-sub.C():
+			if got = snap.RGBAAt(x, y); got == c {
+				return got
+			}
+		case <-timeout:
+			return got
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package vnctest
+
+import (
+	"image/color"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+type options struct {
+	width, height int        // Framebuffer dimensions.
+	name          string     // Desktop name.
+	passwd        string     // VNC password. Empty disables authentication.
+	background    color.RGBA // Initial framebuffer color.
+}
+
+// Size is an option for NewServer() that sets the framebuffer dimensions.
+func Size(w, h int) func(*options) error {
+	return func(o *options) error { return o.setSize(w, h) }
+}
+
+func (o *options) setSize(w, h int) error {
+	if w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return venuelib.Errorf(codes.InvalidArgument, "invalid framebuffer size %dx%d", w, h)
+	}
+	o.width, o.height = w, h
+	return nil
+}
+
+// Name is an option for NewServer() that sets the desktop name.
+func Name(v string) func(*options) error {
+	return func(o *options) error { return o.setName(v) }
+}
+
+func (o *options) setName(v string) error {
+	o.name = v
+	return nil
+}
+
+// Password is an option for NewServer() that enables VNC authentication with
+// the given password.
+func Password(v string) func(*options) error {
+	return func(o *options) error { return o.setPassword(v) }
+}
+
+func (o *options) setPassword(v string) error {
+	if len(v) > 8 {
+		return venuelib.Errorf(codes.InvalidArgument, "VNC passwords are limited to 8 characters")
+	}
+	o.passwd = v
+	return nil
+}
+
+// Background is an option for NewServer() that sets the initial framebuffer
+// color.
+func Background(v color.RGBA) func(*options) error {
+	return func(o *options) error { return o.setBackground(v) }
+}
+
+func (o *options) setBackground(v color.RGBA) error {
+	o.background = v
+	return nil
+}
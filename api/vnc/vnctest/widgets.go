@@ -0,0 +1,246 @@
+package vnctest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/kward/go-vnc/keys"
+)
+
+// Widget is an element of the synthetic UI served by a Server. Widgets are
+// only ever called with the server lock held, so they need no locking of
+// their own, but must not call back into the server.
+type Widget interface {
+	// Draw renders the widget into img.
+	Draw(img draw.Image)
+	// Click handles a left button press at point p, returning true if the
+	// widget changed.
+	Click(p image.Point) bool
+	// Key handles a key press, returning true if the widget changed.
+	Key(k keys.Key) bool
+}
+
+// fill paints rectangle r of img with color c.
+func fill(img draw.Image, r image.Rectangle, c color.RGBA) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+//-----------------------------------------------------------------------------
+
+// Toggle is a switch that changes state when clicked.
+type Toggle struct {
+	Rect    image.Rectangle
+	Off, On color.RGBA
+	State   bool
+}
+
+// Verify that the expected interface is implemented properly.
+var _ Widget = new(Toggle)
+
+// Draw implements the Widget interface.
+func (w *Toggle) Draw(img draw.Image) {
+	c := w.Off
+	if w.State {
+		c = w.On
+	}
+	fill(img, w.Rect, c)
+}
+
+// Click implements the Widget interface.
+func (w *Toggle) Click(p image.Point) bool {
+	if !p.In(w.Rect) {
+		return false
+	}
+	w.State = !w.State
+	return true
+}
+
+// Key implements the Widget interface.
+func (w *Toggle) Key(_ keys.Key) bool { return false }
+
+//-----------------------------------------------------------------------------
+
+// Button is a push-button that counts its presses.
+type Button struct {
+	Rect    image.Rectangle
+	Color   color.RGBA
+	Presses int
+}
+
+// Verify that the expected interface is implemented properly.
+var _ Widget = new(Button)
+
+// Draw implements the Widget interface.
+func (w *Button) Draw(img draw.Image) { fill(img, w.Rect, w.Color) }
+
+// Click implements the Widget interface.
+func (w *Button) Click(p image.Point) bool {
+	if !p.In(w.Rect) {
+		return false
+	}
+	w.Presses++
+	return false // The button looks the same after a press.
+}
+
+// Key implements the Widget interface.
+func (w *Button) Key(_ keys.Key) bool { return false }
+
+//-----------------------------------------------------------------------------
+
+// Tab is a single tab of a Tabs widget, selected by clicking it or pressing
+// its key.
+type Tab struct {
+	Rect image.Rectangle
+	Key  keys.Key
+}
+
+// Tabs is a row of tabs of which one is highlighted, e.g. the page tabs of a
+// console. A negative Selected highlights no tab.
+type Tabs struct {
+	Tabs     []Tab
+	Off, On  color.RGBA
+	Selected int
+}
+
+// Verify that the expected interface is implemented properly.
+var _ Widget = new(Tabs)
+
+// Draw implements the Widget interface.
+func (w *Tabs) Draw(img draw.Image) {
+	for i, t := range w.Tabs {
+		c := w.Off
+		if i == w.Selected {
+			c = w.On
+		}
+		fill(img, t.Rect, c)
+	}
+}
+
+// Click implements the Widget interface.
+func (w *Tabs) Click(p image.Point) bool {
+	for i, t := range w.Tabs {
+		if p.In(t.Rect) {
+			return w.selectTab(i)
+		}
+	}
+	return false
+}
+
+// Key implements the Widget interface.
+func (w *Tabs) Key(k keys.Key) bool {
+	for i, t := range w.Tabs {
+		if t.Key == k {
+			return w.selectTab(i)
+		}
+	}
+	return false
+}
+
+func (w *Tabs) selectTab(i int) bool {
+	changed := w.Selected != i
+	w.Selected = i
+	return changed
+}
+
+//-----------------------------------------------------------------------------
+
+// Channel is a channel display, selecting the channel whose number is typed
+// with Digits digits. The selected channel is drawn as a bar as many pixels
+// wide as its number.
+type Channel struct {
+	Rect     image.Rectangle
+	Off, On  color.RGBA
+	Digits   int
+	Selected int
+	typed    []int
+}
+
+// Verify that the expected interface is implemented properly.
+var _ Widget = new(Channel)
+
+// Draw implements the Widget interface.
+func (w *Channel) Draw(img draw.Image) {
+	fill(img, w.Rect, w.Off)
+	bar := w.Rect
+	bar.Max.X = min(bar.Min.X+w.Selected, bar.Max.X)
+	fill(img, bar, w.On)
+}
+
+// Click implements the Widget interface.
+func (w *Channel) Click(_ image.Point) bool { return false }
+
+// Key implements the Widget interface. Keys other than digits abandon the
+// typed number.
+func (w *Channel) Key(k keys.Key) bool {
+	if k < keys.Digit0 || k > keys.Digit9 {
+		w.typed = nil
+		return false
+	}
+	if w.typed = append(w.typed, int(k-keys.Digit0)); len(w.typed) < w.Digits {
+		return false
+	}
+	n := 0
+	for _, d := range w.typed {
+		n = n*10 + d
+	}
+	w.typed = nil
+	changed := w.Selected != n
+	w.Selected = n
+	return changed
+}
+
+//-----------------------------------------------------------------------------
+
+// Field is a text entry that is focused by clicking it, and then collects the
+// typed characters. Return or Escape remove the focus.
+type Field struct {
+	Rect   image.Rectangle
+	Color  color.RGBA
+	Text   string
+	Focus  bool
+	Render func(img draw.Image, r image.Rectangle, text string) // Optional.
+}
+
+// Verify that the expected interface is implemented properly.
+var _ Widget = new(Field)
+
+// Draw implements the Widget interface.
+func (w *Field) Draw(img draw.Image) {
+	fill(img, w.Rect, w.Color)
+	if w.Render != nil {
+		w.Render(img, w.Rect, w.Text)
+	}
+}
+
+// Click implements the Widget interface.
+func (w *Field) Click(p image.Point) bool {
+	focus := p.In(w.Rect)
+	if focus && !w.Focus {
+		w.Text = ""
+	}
+	w.Focus = focus
+	return false
+}
+
+// Key implements the Widget interface.
+func (w *Field) Key(k keys.Key) bool {
+	if !w.Focus {
+		return false
+	}
+	switch {
+	case k == keys.Return || k == keys.Escape:
+		w.Focus = false
+		return false
+	case k == keys.BackSpace:
+		if len(w.Text) == 0 {
+			return false
+		}
+		w.Text = w.Text[:len(w.Text)-1]
+		return true
+	case k >= keys.Space && k <= keys.AsciiTilde:
+		w.Text += string(rune(k))
+		return true
+	}
+	return false
+}
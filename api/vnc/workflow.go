@@ -9,8 +9,10 @@ otherwise conflict with one another.
 package vnc
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"image/color"
 	"time"
 
 	"github.com/golang/glog"
@@ -22,6 +24,13 @@ import (
 	"github.com/kward/venue/internal/venuelib"
 )
 
+const (
+	// eventDelay is the pause between consecutive key and pointer events.
+	eventDelay = 10 * time.Millisecond
+	// recoveryTimeout bounds the execution of a recovery workflow.
+	recoveryTimeout = 5 * time.Second
+)
+
 // Event describes a single workflow event.
 type Event struct {
 	desc string           // Description of the event.
@@ -29,6 +38,9 @@ type Event struct {
 	data interface{}      // Event data.
 }
 
+// String returns the description of the event.
+func (e *Event) String() string { return e.desc }
+
 type keyEvent struct {
 	key  keys.Key
 	down bool
@@ -43,11 +55,51 @@ type sleepEvent struct {
 	d time.Duration
 }
 
+type waitEvent struct {
+	rect    image.Rectangle
+	color   *color.RGBA // Color of the pixel at rect.Min, or nil for any change.
+	cond    *waitCond   // Condition to wait for, or nil for any change.
+	timeout time.Duration
+}
+
+// waitCond is a condition on the framebuffer contents.
+type waitCond struct {
+	desc string
+	fn   func(s *Snapshot) bool
+}
+
+// expandEvent adds the events replacing it once executed. Expand events with
+// the same description are expected to expand alike.
+type expandEvent struct {
+	fn      func(x *Workflow) error
+	keyFree func() bool // True while fn adds no key events; nil if unknown.
+}
+
+// preemption lets other workflows execute while a workflow sleeps or waits.
+type preemption struct {
+	wake <-chan struct{} // Signals that workflows were queued.
+	run  func()          // Executes the queued workflows able to preempt.
+}
+
+func (e waitEvent) String() string {
+	if e.cond != nil {
+		return e.cond.desc
+	}
+	if e.color != nil {
+		return fmt.Sprintf("pixel %s to become %v", e.rect.Min, *e.color)
+	}
+	return fmt.Sprintf("region %s to change", e.rect)
+}
+
 // Workflow holds a client connection to the VNC server, and a list of events.
 type Workflow struct {
 	conn    ClientConn
+	fb      *Framebuffer
 	events  []*Event
 	sleeper Sleeper
+	preempt *preemption             // Optional; lets other workflows execute meanwhile.
+	recover func(r *Workflow) error // Optional; builds the recovery workflow.
+	tf      Transform               // Maps event coordinates onto the framebuffer.
 }
 
 // NewWorkflow returns a new workflow object.
@@ -58,22 +110,115 @@ func NewWorkflow(conn ClientConn) *Workflow {
 	}
 }
 
+// SetFramebuffer sets the framebuffer that widgets read their state from.
+func (wf *Workflow) SetFramebuffer(fb *Framebuffer) { wf.fb = fb }
+
+// Framebuffer returns the framebuffer associated with the workflow, or nil if
+// there is none.
+func (wf *Workflow) Framebuffer() *Framebuffer { return wf.fb }
+
+// SetTransform sets the transform mapping the coordinates of subsequently
+// added mouse and wait events onto the framebuffer.
+func (wf *Workflow) SetTransform(t Transform) { wf.tf = t }
+
+// Transform returns the transform mapping event coordinates onto the
+// framebuffer.
+func (wf *Workflow) Transform() Transform { return wf.tf }
+
+// SetSleeper sets the sleeper used to pause between events, e.g. to let tests
+// run without delay.
+func (wf *Workflow) SetSleeper(s Sleeper) { wf.sleeper = s }
+
+// SetRecovery sets the function building the workflow that is executed should
+// this one fail partway through, e.g. to escape from a focused field and return
+// VENUE to a known state. Should fn fail, the events it added are executed
+// nonetheless, and the recovery is reported as failed.
+func (wf *Workflow) SetRecovery(fn func(r *Workflow) error) { wf.recover = fn }
+
+// Len returns the number of queued workflow events.
+func (wf *Workflow) Len() int { return len(wf.events) }
+
+// Events returns the queued workflow events.
+func (wf *Workflow) Events() []*Event { return append([]*Event(nil), wf.events...) }
+
 func (wf *Workflow) enqueue(e *Event) {
 	wf.events = append(wf.events, e)
 }
 
 // Execute the workflow against the VNC server.
 func (wf *Workflow) Execute() error {
+	_, err := wf.ExecuteCtx(context.Background())
+	return err
+}
+
+// ExecuteCtx executes the workflow against the VNC server, stopping between
+// events should the context be cancelled or its deadline pass. It returns the
+// number of key and pointer events sent to the server. Should the workflow fail
+// after events were sent, its recovery workflow is executed.
+func (wf *Workflow) ExecuteCtx(ctx context.Context) (int, error) {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
 
 	if wf.conn == nil {
-		return venuelib.Errorf(codes.Internal, "invalid VNC connection")
+		return 0, venuelib.Errorf(codes.Internal, "invalid VNC connection")
 	}
 
-	for eventNo, event := range wf.events {
-		// TODO(kward:20170207) Send an 'ESC' key for certain workflow errors.
+	sent, err := wf.execute(ctx)
+	if err != nil && sent > 0 && wf.recover != nil {
+		wf.recoverFrom(ctx, err)
+	}
+	return sent, err
+}
+
+// recoverFrom executes the recovery workflow following error err. Recovery
+// proceeds even if the context was cancelled, as it has its own timeout.
+func (wf *Workflow) recoverFrom(ctx context.Context, err error) {
+	glog.Errorf("Workflow failed, recovering; %s", err)
+	r := &Workflow{conn: wf.conn, fb: wf.fb, sleeper: wf.sleeper, tf: wf.tf}
+	buildErr := wf.recover(r)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recoveryTimeout)
+	defer cancel()
+	if _, err := r.execute(ctx); err != nil {
+		glog.Errorf("Workflow recovery failed; %s", err)
+		return
+	}
+	if buildErr != nil {
+		glog.Errorf("Workflow recovery failed; %s", buildErr)
+	}
+}
+
+// execute sends the workflow events. Expand events are replaced by the events
+// they add as they are reached.
+func (wf *Workflow) execute(ctx context.Context) (int, error) {
+	// Waits observe the framebuffer changes caused by the events preceding them,
+	// so each is subscribed to before the first of those events is sent.
+	var sub *Subscription
+	var base *Snapshot
+	defer func() {
+		if sub != nil {
+			sub.Close()
+		}
+	}()
+
+	events := wf.events
+	sent := 0
+	for eventNo := 0; eventNo < len(events); eventNo++ {
+		event := events[eventNo]
+		if err := ctx.Err(); err != nil {
+			return sent, contextError(err, sent)
+		}
+		if sub == nil && wf.fb != nil {
+			if r, ok := wf.nextWait(events[eventNo:]); ok {
+				sub, base = wf.fb.Subscribe(r), wf.fb.Snapshot()
+			}
+		}
+		// Give the UI a moment between consecutive input events.
+		if eventNo > 0 && isInput(event) && isInput(events[eventNo-1]) {
+			if err := sleepCtx(ctx, wf.sleeper, eventDelay); err != nil {
+				return sent, contextError(err, sent)
+			}
+		}
 		if glog.V(4) {
 			glog.Infof("Handling event #%d: %s", eventNo+1, event.desc)
 		}
@@ -81,22 +226,152 @@ func (wf *Workflow) Execute() error {
 		case messages.KeyEvent:
 			e := event.data.(keyEvent)
 			if err := wf.conn.KeyEvent(e.key, e.down); err != nil {
-				return err
+				return sent, err
 			}
+			sent++
 		case messages.PointerEvent:
 			e := event.data.(pointerEvent)
 			if err := wf.conn.PointerEvent(e.button, e.x, e.y); err != nil {
-				return err
+				return sent, err
 			}
+			sent++
 		case messages.Sleep:
-			e := event.data.(sleepEvent)
-			wf.sleeper.Sleep(e.d)
-			time.Sleep(e.d)
+			if err := wf.sleep(ctx, event.data.(sleepEvent).d); err != nil {
+				return sent, contextError(err, sent)
+			}
+		case messages.WaitForRegionChange, messages.WaitForPixel:
+			if sub == nil {
+				return sent, venuelib.Errorf(codes.FailedPrecondition, "workflow has no framebuffer to %s", event.desc)
+			}
+			err := wf.wait(ctx, event.data.(waitEvent), sub, base)
+			sub.Close()
+			sub = nil
+			if err != nil {
+				if ctx.Err() != nil {
+					return sent, contextError(err, sent)
+				}
+				return sent, err
+			}
+		case messages.Expand:
+			x := &Workflow{conn: wf.conn, fb: wf.fb, sleeper: wf.sleeper, preempt: wf.preempt, tf: wf.tf}
+			if err := event.data.(expandEvent).fn(x); err != nil {
+				return sent, err
+			}
+			rest := events[eventNo+1:]
+			events = append(append(events[:eventNo+1:eventNo+1], x.events...), rest...)
+		}
+	}
+
+	return sent, nil
+}
+
+// sleep pauses the workflow for duration d. The workflows able to preempt this
+// one execute meanwhile.
+func (wf *Workflow) sleep(ctx context.Context, d time.Duration) error {
+	if wf.preempt == nil {
+		return sleepCtx(ctx, wf.sleeper, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		wf.preempt.run()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wf.preempt.wake:
+		case <-t.C:
+			return nil
+		}
+	}
+}
+
+// isInput returns true if the event sends input to the server.
+func isInput(e *Event) bool {
+	return e.msg == messages.KeyEvent || e.msg == messages.PointerEvent
+}
+
+// nextWait returns the region observed by the first wait of events. Should an
+// expand event come first, the waits it adds are unknown, so the whole
+// framebuffer is observed.
+func (wf *Workflow) nextWait(events []*Event) (image.Rectangle, bool) {
+	for _, e := range events {
+		switch d := e.data.(type) {
+		case waitEvent:
+			return d.rect, true
+		case expandEvent:
+			return wf.fb.Bounds(), true
+		}
+	}
+	return image.Rectangle{}, false
+}
+
+// wait blocks until the framebuffer satisfies wait event w. Changes are
+// relative to the base snapshot taken when the subscription was made. The
+// workflows able to preempt this one execute meanwhile, within the timeout.
+func (wf *Workflow) wait(ctx context.Context, w waitEvent, sub *Subscription, base *Snapshot) error {
+	done := func(s *Snapshot) bool {
+		if w.cond != nil {
+			return w.cond.fn(s)
+		}
+		if w.color != nil {
+			return s.RGBAAt(w.rect.Min.X, w.rect.Min.Y) == *w.color
+		}
+		return regionChanged(base, s, w.rect)
+	}
+	var wake <-chan struct{}
+	if wf.preempt != nil {
+		wf.preempt.run()
+		wake = wf.preempt.wake
+	}
+	if done(wf.fb.Snapshot()) {
+		return nil
+	}
+	t := time.NewTimer(w.timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+			// The preempting workflows may have taken a while.
+			wf.preempt.run()
+			if done(wf.fb.Snapshot()) {
+				return nil
+			}
+		case <-t.C:
+			if done(wf.fb.Snapshot()) {
+				return nil
+			}
+			return venuelib.Errorf(codes.DeadlineExceeded, "timed out after %s waiting for %s", w.timeout, w)
+		case s := <-sub.C():
+			if done(s) {
+				return nil
+			}
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
+}
 
-	return nil
+// regionChanged returns true if any pixel within rectangle r differs between
+// snapshots a and b.
+func regionChanged(a, b *Snapshot, r image.Rectangle) bool {
+	r = r.Intersect(a.Bounds()).Intersect(b.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contextError converts a context error into a VENUE error.
+func contextError(err error, sent int) error {
+	c := codes.Canceled
+	if err == context.DeadlineExceeded {
+		c = codes.DeadlineExceeded
+	}
+	return venuelib.Errorf(c, "workflow stopped after %d events; %s", sent, err)
 }
 
 // KeyPress presses a key on the VENUE console.
@@ -113,6 +388,11 @@ func (wf *Workflow) KeyPress(key keys.Key) {
 
 // MouseMove moves the mouse.
 func (wf *Workflow) MouseMove(p image.Point) {
+	wf.mouseMove(wf.tf.Point(p))
+}
+
+// mouseMove moves the mouse to framebuffer point p.
+func (wf *Workflow) mouseMove(p image.Point) {
 	wf.enqueue(&Event{
 		fmt.Sprintf("move mouse to %s", p),
 		messages.PointerEvent,
@@ -121,7 +401,8 @@ func (wf *Workflow) MouseMove(p image.Point) {
 
 // MouseClick moves the mouse to a position and left clicks.
 func (wf *Workflow) MouseClick(b buttons.Button, p image.Point) {
-	wf.MouseMove(p)
+	p = wf.tf.Point(p)
+	wf.mouseMove(p)
 	wf.enqueue(&Event{
 		fmt.Sprintf("%s button click at %s", b, p),
 		messages.PointerEvent,
@@ -134,20 +415,20 @@ func (wf *Workflow) MouseClick(b buttons.Button, p image.Point) {
 
 // MouseDrag moves the mouse, clicks, and drags to a new position.
 func (wf *Workflow) MouseDrag(p, d image.Point) {
-	wf.MouseMove(p)
+	p, q := wf.tf.Point(p), wf.tf.Point(p.Add(d))
+	wf.mouseMove(p)
 	wf.enqueue(&Event{
 		fmt.Sprintf("%s button click at %s", buttons.Left, p),
 		messages.PointerEvent,
 		pointerEvent{buttons.Left, uint16(p.X), uint16(p.Y)}})
-	p = p.Add(d) // Add delta.
 	wf.enqueue(&Event{
-		fmt.Sprintf("mouse drag to %s", p),
+		fmt.Sprintf("mouse drag to %s", q),
 		messages.PointerEvent,
-		pointerEvent{buttons.Left, uint16(p.X), uint16(p.Y)}})
+		pointerEvent{buttons.Left, uint16(q.X), uint16(q.Y)}})
 	wf.enqueue(&Event{
 		fmt.Sprintf("%s button release", buttons.Left),
 		messages.PointerEvent,
-		pointerEvent{buttons.None, uint16(p.X), uint16(p.Y)}})
+		pointerEvent{buttons.None, uint16(q.X), uint16(q.Y)}})
 }
 
 // Sleep the workflow for at least the duration d.
@@ -158,12 +439,99 @@ func (wf *Workflow) Sleep(d time.Duration) {
 		sleepEvent{d}})
 }
 
+// WaitForRegionChange waits up to timeout for the pixels within rectangle r to
+// change from what they were before the preceding workflow events were sent.
+// The workflow fails with codes.DeadlineExceeded should they not.
+func (wf *Workflow) WaitForRegionChange(r image.Rectangle, timeout time.Duration) {
+	e := waitEvent{rect: wf.tf.Rect(r), timeout: timeout}
+	wf.enqueue(&Event{
+		fmt.Sprintf("wait for %s", e),
+		messages.WaitForRegionChange,
+		e})
+}
+
+// WaitForPixel waits up to timeout for the pixel at p to become color c. The
+// workflow fails with codes.DeadlineExceeded should it not.
+func (wf *Workflow) WaitForPixel(p image.Point, c color.RGBA, timeout time.Duration) {
+	p = wf.tf.Pixel(p.X, p.Y)
+	e := waitEvent{rect: image.Rectangle{p, p.Add(image.Point{1, 1})}, color: &c, timeout: timeout}
+	wf.enqueue(&Event{
+		fmt.Sprintf("wait for %s", e),
+		messages.WaitForPixel,
+		e})
+}
+
+// WaitFor waits up to timeout for condition fn to hold on the framebuffer. It is
+// checked whenever pixels within rectangle r change. The workflow fails with
+// codes.DeadlineExceeded should it not hold in time.
+func (wf *Workflow) WaitFor(desc string, r image.Rectangle, fn func(s *Snapshot) bool, timeout time.Duration) {
+	e := waitEvent{rect: wf.tf.Rect(r), cond: &waitCond{desc, fn}, timeout: timeout}
+	wf.enqueue(&Event{
+		fmt.Sprintf("wait for %s", e),
+		messages.WaitForRegionChange,
+		e})
+}
+
+// Expand adds an event that calls fn when the workflow executes it. The events
+// fn adds to workflow x are then executed in place of it, so that they can
+// depend on the framebuffer as the preceding events left it. Expand events
+// with the same description must expand alike.
+func (wf *Workflow) Expand(desc string, fn func(x *Workflow) error) {
+	wf.enqueue(&Event{
+		desc,
+		messages.Expand,
+		expandEvent{fn: fn}})
+}
+
+// ExpandKeyFree adds an Expand event, as Expand does, whose events hold no key
+// events for as long as keyFree returns true, e.g. while the page they act on
+// is displayed. A High priority workflow may then preempt another one while
+// keyFree holds. keyFree must not block.
+func (wf *Workflow) ExpandKeyFree(desc string, keyFree func() bool, fn func(x *Workflow) error) {
+	wf.enqueue(&Event{
+		desc,
+		messages.Expand,
+		expandEvent{fn: fn, keyFree: keyFree}})
+}
+
+// Sleeper pauses workflow execution.
 type Sleeper interface {
 	Sleep(d time.Duration)
 }
 
+// ContextSleeper is a Sleeper that can be interrupted by a context.
+type ContextSleeper interface {
+	Sleeper
+	SleepCtx(ctx context.Context, d time.Duration) error
+}
+
+// sleepCtx sleeps for duration d using s, returning early with the context
+// error should the context be done. Sleepers unable to be interrupted sleep
+// for the full duration.
+func sleepCtx(ctx context.Context, s Sleeper, d time.Duration) error {
+	if cs, ok := s.(ContextSleeper); ok {
+		return cs.SleepCtx(ctx, d)
+	}
+	s.Sleep(d)
+	return ctx.Err()
+}
+
 type workflowSleeper struct{}
 
+// Verify that the expected interface is implemented properly.
+var _ ContextSleeper = new(workflowSleeper)
+
 func newWorkflowSleeper() Sleeper { return &workflowSleeper{} }
 
 func (s *workflowSleeper) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (s *workflowSleeper) SleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -1,14 +1,20 @@
 package vnc
 
 import (
+	"context"
+	"errors"
 	"image"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	vnclib "github.com/kward/go-vnc"
 	"github.com/kward/go-vnc/buttons"
 	"github.com/kward/go-vnc/keys"
 	"github.com/kward/go-vnc/rfbflags"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
 )
 
 type Events []interface{}
@@ -75,6 +81,24 @@ func TestMouseDrag(t *testing.T) {
 	}
 }
 
+func TestMouseTransform(t *testing.T) {
+	conn := NewMockConn()
+	wf := NewWorkflow(conn)
+	wf.SetTransform(Transform{Scale: 1.25, Offset: image.Point{0, 32}})
+	wf.MouseDrag(image.Point{100, 200}, image.Point{10, -20})
+	wf.Execute()
+
+	events := Events{
+		pointerEvent{buttons.None, 125, 282},
+		pointerEvent{buttons.Left, 125, 282},
+		pointerEvent{buttons.Left, 138, 257},
+		pointerEvent{buttons.None, 138, 257},
+	}
+	if got, want := conn.(*mockConn).events, events; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events; < %v > != < %v >", got, want)
+	}
+}
+
 func TestSleep(t *testing.T) {
 	conn := NewMockConn()
 	wf := NewWorkflow(conn)
@@ -90,6 +114,199 @@ func TestSleep(t *testing.T) {
 	}
 }
 
+func TestExecuteCtx(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		cancelAt time.Duration // Cancel the context when sleeping this long.
+		timeout  time.Duration
+		sent     int
+		sleeps   Events
+		code     codes.Code
+	}{
+		{"complete", 0, time.Hour, 4,
+			Events{sleepEvent{eventDelay}, sleepEvent{time.Second}, sleepEvent{eventDelay}}, codes.OK},
+		{"cancelled while sleeping", time.Second, time.Hour, 2,
+			Events{sleepEvent{eventDelay}, sleepEvent{time.Second}}, codes.Canceled},
+		{"deadline exceeded", 0, -time.Second, 0, nil, codes.DeadlineExceeded},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		defer cancel()
+		s := &mockCtxSleeper{cancel: cancel, cancelAt: tt.cancelAt}
+
+		wf := NewWorkflow(NewMockConn())
+		wf.SetSleeper(s)
+		wf.KeyPress(keys.F1)
+		wf.Sleep(time.Second)
+		wf.KeyPress(keys.F2)
+		sent, err := wf.ExecuteCtx(ctx)
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("%s: ExecuteCtx() error code = %s, want %s; %v", tt.desc, got, want, err)
+		}
+		if got, want := sent, tt.sent; got != want {
+			t.Errorf("%s: ExecuteCtx() sent = %d, want %d", tt.desc, got, want)
+		}
+		if got, want := s.events, tt.sleeps; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unexpected sleeps; < %v > != < %v >", tt.desc, got, want)
+		}
+	}
+}
+
+func TestExecuteCtxRecovery(t *testing.T) {
+	for _, tt := range []struct {
+		desc       string
+		keys       keys.Keys
+		recoverErr error
+		events     Events
+	}{
+		{"success", keys.Keys{keys.Digit1}, nil, Events{
+			keyEvent{keys.Digit1, true}, keyEvent{keys.Digit1, false},
+		}},
+		{"failure partway", keys.Keys{keys.Digit1, keys.Digit9}, nil, Events{
+			keyEvent{keys.Digit1, true}, keyEvent{keys.Digit1, false},
+			keyEvent{keys.Escape, true}, keyEvent{keys.Escape, false},
+		}},
+		{"failure before sending", keys.Keys{keys.Digit9}, nil, nil},
+		// The events added before the recovery failed are executed nonetheless.
+		{"recovery failure", keys.Keys{keys.Digit1, keys.Digit9}, venuelib.Errorf(codes.Unimplemented, "no page"), Events{
+			keyEvent{keys.Digit1, true}, keyEvent{keys.Digit1, false},
+			keyEvent{keys.Escape, true}, keyEvent{keys.Escape, false},
+		}},
+	} {
+		conn := &failConn{fail: keys.Digit9}
+		wf := NewWorkflow(conn)
+		wf.SetSleeper(newMockSleeper())
+		wf.SetRecovery(func(r *Workflow) error {
+			r.KeyPress(keys.Escape)
+			return tt.recoverErr
+		})
+		for _, k := range tt.keys {
+			wf.KeyPress(k)
+		}
+		wf.ExecuteCtx(context.Background())
+		if got, want := conn.events, tt.events; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unexpected events; < %v > != < %v >", tt.desc, got, want)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	r := image.Rect(4, 4, 8, 8)
+	for _, tt := range []struct {
+		desc  string
+		fb    bool
+		async bool // Paint the framebuffer after PointerEvent returns.
+		click bool
+		wait  func(wf *Workflow)
+		code  codes.Code
+	}{
+		{"region change", true, false, true,
+			func(wf *Workflow) { wf.WaitForRegionChange(r, time.Second) }, codes.OK},
+		{"later region change", true, true, true,
+			func(wf *Workflow) { wf.WaitForRegionChange(r, time.Second) }, codes.OK},
+		{"unchanged region", true, false, false,
+			func(wf *Workflow) { wf.WaitForRegionChange(r, 20*time.Millisecond) }, codes.DeadlineExceeded},
+		{"other region changed", true, false, true,
+			func(wf *Workflow) { wf.WaitForRegionChange(image.Rect(0, 0, 4, 4), 20*time.Millisecond) }, codes.DeadlineExceeded},
+		{"pixel", true, true, true,
+			func(wf *Workflow) { wf.WaitForPixel(image.Point{5, 5}, red, time.Second) }, codes.OK},
+		{"pixel already set", true, false, false,
+			func(wf *Workflow) { wf.WaitForPixel(image.Point{0, 0}, blue, time.Second) }, codes.OK},
+		{"wrong pixel color", true, false, true,
+			func(wf *Workflow) { wf.WaitForPixel(image.Point{5, 5}, green, 20*time.Millisecond) }, codes.DeadlineExceeded},
+		{"condition", true, true, true,
+			func(wf *Workflow) { wf.WaitFor("red", r, isRed, time.Second) }, codes.OK},
+		{"unmet condition", true, false, false,
+			func(wf *Workflow) { wf.WaitFor("red", r, isRed, 20*time.Millisecond) }, codes.DeadlineExceeded},
+		{"expanded wait", true, true, true,
+			func(wf *Workflow) {
+				wf.Expand("wait", func(x *Workflow) error {
+					x.WaitForPixel(image.Point{5, 5}, red, time.Second)
+					return nil
+				})
+			}, codes.OK},
+		{"no framebuffer", false, false, true,
+			func(wf *Workflow) { wf.WaitForRegionChange(r, time.Second) }, codes.FailedPrecondition},
+	} {
+		fb := NewFramebuffer(16, 16)
+		fb.Paint(vnclib.Rectangle{Width: 16, Height: 16}, toColors(repeat(blue, 256)...))
+		fb.Commit()
+		conn := &paintConn{fb: fb, rect: r, async: tt.async}
+
+		wf := NewWorkflow(conn)
+		wf.SetSleeper(newMockSleeper())
+		if tt.fb {
+			wf.SetFramebuffer(fb)
+		}
+		if tt.click {
+			wf.MouseClick(buttons.Left, image.Point{5, 5})
+		}
+		tt.wait(wf)
+		_, err := wf.ExecuteCtx(context.Background())
+		conn.wg.Wait()
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("%s: ExecuteCtx() error code = %s, want %s; %v", tt.desc, got, want, err)
+		}
+	}
+}
+
+// isRed returns true if the snapshot pixel at (5, 5) is red.
+func isRed(s *Snapshot) bool { return s.RGBAAt(5, 5) == red }
+
+func TestExpand(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		click  bool
+		events Events
+		err    bool
+	}{
+		{"red", true, Events{
+			keyEvent{keys.F1, true},
+			keyEvent{keys.F1, false},
+			keyEvent{keys.Escape, true},
+			keyEvent{keys.Escape, false},
+		}, false},
+		{"blue", false, Events{
+			keyEvent{keys.F2, true},
+			keyEvent{keys.F2, false},
+			keyEvent{keys.Escape, true},
+			keyEvent{keys.Escape, false},
+		}, false},
+		{"error", false, nil, true},
+	} {
+		fb := NewFramebuffer(16, 16)
+		fb.Paint(vnclib.Rectangle{Width: 16, Height: 16}, toColors(repeat(blue, 256)...))
+		fb.Commit()
+		conn := &paintConn{fb: fb, rect: image.Rect(4, 4, 8, 8)}
+
+		wf := NewWorkflow(conn)
+		wf.SetSleeper(newMockSleeper())
+		wf.SetFramebuffer(fb)
+		if tt.click {
+			wf.MouseClick(buttons.Left, image.Point{5, 5})
+		}
+		// The framebuffer is read once the click was sent.
+		wf.Expand("press by color", func(x *Workflow) error {
+			if tt.err {
+				return venuelib.Errorf(codes.Internal, "expansion failed")
+			}
+			key := keys.F2
+			if isRed(x.Framebuffer().Snapshot()) {
+				key = keys.F1
+			}
+			x.KeyPress(key)
+			return nil
+		})
+		wf.KeyPress(keys.Escape)
+		err := wf.Execute()
+		if got, want := err != nil, tt.err; got != want {
+			t.Errorf("%s: Execute() error = %v, want error %t", tt.desc, err, want)
+		}
+		if got, want := conn.events, tt.events; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: events = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
 //-----------------------------------------------------------------------------
 // mockConn implements the ClientConn interface.
 type mockConn struct {
@@ -125,3 +342,69 @@ type mockSleeper struct {
 func newMockSleeper() Sleeper { return &mockSleeper{} }
 
 func (s *mockSleeper) Sleep(d time.Duration) { s.events = append(s.events, sleepEvent{d}) }
+
+//-----------------------------------------------------------------------------
+// mockCtxSleeper implements the ContextSleeper interface. It returns
+// immediately, cancelling the context when asked to sleep for cancelAt.
+type mockCtxSleeper struct {
+	mockSleeper
+	cancel   context.CancelFunc
+	cancelAt time.Duration
+}
+
+func (s *mockCtxSleeper) SleepCtx(ctx context.Context, d time.Duration) error {
+	s.Sleep(d)
+	if d == s.cancelAt {
+		s.cancel()
+	}
+	return ctx.Err()
+}
+
+//-----------------------------------------------------------------------------
+// paintConn paints a rectangle of the framebuffer red when the left button is
+// pressed, as a server would update the client.
+type paintConn struct {
+	mockConn
+	fb    *Framebuffer
+	rect  image.Rectangle
+	async bool
+	wg    sync.WaitGroup
+}
+
+func (c *paintConn) PointerEvent(button buttons.Button, x, y uint16) error {
+	if button != buttons.Left {
+		return nil
+	}
+	paint := func() {
+		c.fb.Paint(vnclib.Rectangle{
+			X: uint16(c.rect.Min.X), Y: uint16(c.rect.Min.Y),
+			Width: uint16(c.rect.Dx()), Height: uint16(c.rect.Dy()),
+		}, toColors(repeat(red, c.rect.Dx()*c.rect.Dy())...))
+		c.fb.Commit()
+	}
+	if !c.async {
+		paint()
+		return nil
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		paint()
+	}()
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// failConn fails to send the `fail` key.
+type failConn struct {
+	mockConn
+	fail keys.Key
+}
+
+func (c *failConn) KeyEvent(key keys.Key, down bool) error {
+	if key == c.fail {
+		return errors.New("key event failed")
+	}
+	return c.mockConn.KeyEvent(key, down)
+}
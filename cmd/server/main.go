@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,6 +17,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/kward/go-osc/osc"
 	"github.com/kward/venue/api/touchosc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/ping"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/venuelib"
@@ -26,10 +28,16 @@ var (
 	oscServerHost = flag.String("osc_server_host", "0.0.0.0", "OSC server hostname/IP.")
 	oscServerPort = flag.Uint("osc_server_port", 8000, "OSC server port.")
 
-	venueHost    = flag.String("venue_host", "", "Venue VNC host/IP.")
-	venuePort    = flag.Uint("venue_port", 5900, "Venue VNC port.")
-	venuePasswd  string
-	venueTimeout = flag.Duration("venue_timeout", 15*time.Second, "Venue VNC timeout.")
+	venueHost      = flag.String("venue_host", "", "Venue VNC host/IP.")
+	venuePort      = flag.Uint("venue_port", 5900, "Venue VNC port.")
+	venuePasswd    string
+	venueTimeout   = flag.Duration("venue_timeout", 15*time.Second, "Venue VNC timeout.")
+	venueLayout    = flag.String("venue_layout", "", "Venue page layout file. Empty uses the default layout.")
+	venueTemplates = flag.String("venue_templates", "", "Directory of widget templates to locate widgets with. Empty trusts the layout positions.")
+	venueState     = flag.String("venue_state", "", "Console state file, loaded at startup and saved every checkpoint period. Empty keeps no state.")
+	stateAddr      = flag.String("state_addr", "", "HTTP address serving the unauthenticated console state to venue_cli, e.g. localhost:8001. Empty serves none.")
+	venueBuses     = flag.String("venue_buses", buses.Default().String(), "Venue bus configuration, as <auxes>+<groups>, with an \"m\" suffix for mono buses, e.g. 16m+8.")
+	venueGlyphs    = flag.String("venue_glyphs", "", "Directory of encoder value glyphs to confirm encoder values with. Empty confirms none.")
 
 	// Kept for future usage; referenced in init to satisfy linters.
 	venueFbRefresh   = flag.Bool("enable_venue_fb_refresh", false, "Enable Venue framebuffer refresh.")
@@ -50,15 +58,17 @@ type state struct {
 	output     int
 	outputBank int
 	router     *router.Router
+	parser     *touchosc.Parser
 }
 
-func NewState(router *router.Router) *state {
+func NewState(router *router.Router, parser *touchosc.Parser) *state {
 	return &state{
 		input:      1,
 		inputBank:  1,
 		output:     1,
 		outputBank: 1,
 		router:     router,
+		parser:     parser,
 	}
 }
 
@@ -85,7 +95,7 @@ func (s *state) handleMessage(v *venue.Venue, msg *osc.Message) {
 		glog.Infof("Received OSC message from %s: %q", msg.Addr(), msg)
 	}
 
-	pkt, err := touchosc.Parse(msg)
+	pkt, err := s.parser.Parse(msg)
 	if err != nil {
 		glog.Errorf("Failed to parse OSC message %s; %s", msg, err)
 		return
@@ -112,8 +122,17 @@ func main() {
 		passwd = pw
 	}
 
+	bc, err := buses.Parse(*venueBuses)
+	if err != nil {
+		glog.Exitf("Invalid --venue_buses flag; %s\n", err)
+	}
+	parser, err := touchosc.NewParser(touchosc.Buses(bc))
+	if err != nil {
+		glog.Exitf("Failure instantiating TouchOSC parser; %s\n", err)
+	}
+
 	// Instantiate Venue client.
-	v, err := venue.New()
+	v, err := venue.New(venue.LayoutFile(*venueLayout), venue.TemplateDir(*venueTemplates), venue.Buses(bc), venue.GlyphDir(*venueGlyphs))
 	if err != nil {
 		glog.Exitf("Failure instantiating Venue client; %s\n", err)
 	}
@@ -130,16 +149,39 @@ func main() {
 	}
 	defer v.Close()
 	glog.Info("Venue connection established.")
+
+	// The model starts from the saved state, else from the defaults. It is
+	// initialized against VENUE afterwards, and kept as the connection is
+	// reestablished.
+	if *venueState != "" {
+		if err := loadConsoleState(v, *venueState); err != nil {
+			glog.Exitf("Unable to load the console state; %s\n", err)
+		}
+		defer saveConsoleState(v, *venueState)
+	}
+	// Listen before initializing, so that the framebuffer reflects the console.
+	// The listener also reconnects and reinitializes should the connection drop.
+	go v.ListenAndHandleCtx(ctxApp)
 	if err := v.Initialize(); err != nil {
 		glog.Exitf("Unable to initialize Venue properly; %s\n", err)
 	}
+	if *stateAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/state", v.StateHandler())
+		srv := &http.Server{Addr: *stateAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				glog.Exitf("Error serving the console state; %s\n", err)
+			}
+		}()
+		defer srv.Close()
+		glog.Infof("Serving the console state on http://%s/state.", *stateAddr)
+	}
 
 	router := &router.Router{}
 	router.RegisterEndpoint(v)
 	router.RegisterEndpoint(&ping.Ping{})
 
-	go v.ListenAndHandleCtx(ctxApp)
-
 	o := &osc.Server{}
 	conn, err := net.ListenPacket("udp", fmt.Sprintf("%v:%v", *oscServerHost, *oscServerPort))
 	if err != nil {
@@ -149,7 +191,7 @@ func main() {
 	glog.Info("OSC server started.")
 
 	go func() {
-		s := NewState(router)
+		s := NewState(router, parser)
 
 		for {
 			p, err := o.ReceivePacket(ctxApp, conn)
@@ -187,6 +229,55 @@ func main() {
 			if glog.V(5) {
 				glog.Infof("--- checkpoint ---")
 			}
+			if *venueState != "" {
+				saveConsoleState(v, *venueState)
+			}
+		}
+	}
+}
+
+// loadConsoleState loads the console state file at path. A missing file is
+// not an error, as there is no state to load on first use.
+func loadConsoleState(v *venue.Venue, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		glog.Infof("No console state to load from %s.", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := v.LoadState(f); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	glog.Infof("Loaded the console state from %s.", path)
+	return nil
+}
+
+// saveConsoleState saves the console state to the file at path. The state is
+// written to a temporary file first, so that a failed save leaves the previous
+// state intact.
+func saveConsoleState(v *venue.Venue, path string) {
+	if err := func() error {
+		tmp := path + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		if err := v.SaveState(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
 		}
+		return os.Rename(tmp, path)
+	}(); err != nil {
+		glog.Errorf("Unable to save the console state; %s", err)
+		return
+	}
+	if glog.V(2) {
+		glog.Infof("Saved the console state to %s.", path)
 	}
 }
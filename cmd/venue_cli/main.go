@@ -1,28 +1,58 @@
 // Package main implements a command-line tool to test VENUE connectivity
-// by randomly selecting inputs.
+// by randomly selecting inputs, to run workflow scripts, to calibrate the
+// widget layout, to capture encoder value glyphs, and to save, compare and
+// recall console states.
+//
+// Usage:
+//
+//	venue_cli [flags]                          # Randomly select inputs.
+//	venue_cli [flags] run [--dry_run] script.vwf  # Run a workflow script.
+//	venue_cli [flags] calibrate [--click] [--out prefix]  # Check widget positions.
+//	venue_cli [flags] glyphs [--widget name] [--dir dir] text  # Capture the glyphs of an encoder showing text.
+//	venue_cli [flags] state save state.json    # Save the console state of the server.
+//	venue_cli [flags] state load state.json    # Load a console state into the server.
+//	venue_cli [flags] state recall state.json  # Drive the console to a state.
+//	venue_cli state check state.json           # Check a state, and list its changes from the defaults.
+//	venue_cli state diff from.json to.json     # List the changes between states.
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/router/actions"
 	"github.com/kward/venue/internal/router/signals"
 	"github.com/kward/venue/internal/venuelib"
 	"github.com/kward/venue/venue"
+	"github.com/kward/venue/venue/pages"
 )
 
 var (
 	venueHost   = flag.String("venue_host", "localhost", "Venue host.")
 	venuePort   = flag.Uint("venue_port", 5900, "Venue port.")
 	venuePasswd string
+	venueLayout = flag.String("venue_layout", "", "Venue page layout file. Empty uses the default layout.")
+	venueBuses  = flag.String("venue_buses", buses.Default().String(), "Venue bus configuration, as <auxes>+<groups>, with an \"m\" suffix for mono buses, e.g. 16m+8.")
+
+	busConfig buses.Config // Parsed --venue_buses flag.
+
+	stateAddr = flag.String("state_addr", "localhost:8001", "HTTP address of the server console state.")
 
 	numInputs = flag.Uint("num_inputs", 48, "number of inputs")
 	period    = flag.Duration("period", 100*time.Millisecond, "period for random adjustment")
@@ -31,11 +61,91 @@ var (
 func flagInit() {
 	flag.StringVar(&venuePasswd, "venue_passwd", "", "Venue password.")
 	flag.Parse()
+	c, err := buses.Parse(*venueBuses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	busConfig = c
 }
 
 func main() {
 	flagInit()
 
+	var (
+		script      string
+		calibrate   bool
+		click       bool
+		out         string
+		glyphs      string // Text shown by the encoder to capture glyphs from.
+		widget      string
+		glyphDir    string
+		recallState string
+	)
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "calibrate":
+		fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+		fs.BoolVar(&click, "click", false, "Click every widget, and report the clicks without a visible effect. This changes the console state!")
+		fs.StringVar(&out, "out", "calibrate", "Prefix of the PNG file written for each page.")
+		fs.Parse(flag.Args()[1:])
+		if fs.NArg() != 0 {
+			log.Fatal("usage: venue_cli calibrate [--click] [--out prefix]")
+		}
+		calibrate = true
+	case "glyphs":
+		fs := flag.NewFlagSet("glyphs", flag.ExitOnError)
+		fs.StringVar(&widget, "widget", "Gain", "INPUTS page encoder showing the text.")
+		fs.StringVar(&glyphDir, "dir", "glyphs", "Directory the glyph PNG files are written to.")
+		fs.Parse(flag.Args()[1:])
+		if fs.NArg() != 1 {
+			log.Fatal("usage: venue_cli glyphs [--widget name] [--dir dir] text")
+		}
+		glyphs = fs.Arg(0)
+	case "run":
+		fs := flag.NewFlagSet("run", flag.ExitOnError)
+		dryRun := fs.Bool("dry_run", false, "Print the workflow events instead of sending them.")
+		fs.Parse(flag.Args()[1:])
+		if fs.NArg() != 1 {
+			log.Fatal("usage: venue_cli run [--dry_run] script.vwf")
+		}
+		script = fs.Arg(0)
+		if *dryRun {
+			if err := printScript(script); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	case "state":
+		switch sub := flag.Arg(1); {
+		case sub == "save" && flag.NArg() == 3:
+			if err := saveServerState(flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case sub == "load" && flag.NArg() == 3:
+			if err := loadServerState(flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case sub == "recall" && flag.NArg() == 3:
+			recallState = flag.Arg(2)
+		case sub == "check" && flag.NArg() == 3:
+			if err := diffStates("", flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case sub == "diff" && flag.NArg() == 4:
+			if err := diffStates(flag.Arg(2), flag.Arg(3)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		default:
+			log.Fatal("usage: venue_cli state save|load|recall|check state.json, or venue_cli state diff from.json to.json")
+		}
+	default:
+		log.Fatalf("unknown command %q", cmd)
+	}
+
 	if venuePasswd == "" {
 		var err error
 		venuePasswd, err = venuelib.GetPasswd()
@@ -44,7 +154,7 @@ func main() {
 		}
 	}
 
-	v, err := venue.New()
+	v, err := venue.New(venue.LayoutFile(*venueLayout), venue.Buses(busConfig))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -59,10 +169,34 @@ func main() {
 	defer v.Close()
 	log.Println("Venue connection established.")
 
+	go v.ListenAndHandleCtx(ctxApp)
+	if calibrate {
+		if err := calibrateLayout(ctxApp, v, click, out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if glyphs != "" {
+		if err := captureGlyphs(ctxApp, v, widget, glyphs, glyphDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if script != "" {
+		if err := runScript(ctxApp, v, script); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := v.Initialize(); err != nil {
 		log.Fatal(err)
 	}
-	go v.ListenAndHandleCtx(ctxApp)
+	if recallState != "" {
+		if err := recall(ctxApp, v, recallState); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	//go v.FramebufferRefresh()
 
 	// Randomly adjust an input.
@@ -84,3 +218,210 @@ func main() {
 		}
 	}
 }
+
+// runScript executes the workflow script at path.
+func runScript(ctx context.Context, v *venue.Venue, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.RunScript(ctx, f)
+}
+
+// printScript prints the events of the workflow script at path.
+func printScript(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l := venue.DefaultLayout()
+	if *venueLayout != "" {
+		if l, err = venue.LoadLayout(*venueLayout); err != nil {
+			return err
+		}
+	}
+	ui, err := venue.NewUI(l, busConfig)
+	if err != nil {
+		return err
+	}
+	wf := vnc.NewWorkflow(nil)
+	if err := venue.ParseScript(f, ui, wf); err != nil {
+		return err
+	}
+	for i, e := range wf.Events() {
+		fmt.Printf("%3d: %s\n", i+1, e)
+	}
+	return nil
+}
+
+// calibrateLayout writes an image of each page, overlaid with the widget
+// positions, to a PNG file named after prefix. With click, every widget is
+// clicked and those without a visible effect are reported.
+func calibrateLayout(ctx context.Context, v *venue.Venue, click bool, prefix string) error {
+	cs, err := v.Calibrate(ctx, click, func(p pages.Page, img image.Image) error {
+		path := fmt.Sprintf("%s_%s.png", prefix, p)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+		log.Printf("Wrote %s.", path)
+		return f.Close()
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if !c.Changed {
+			fmt.Printf("%s/%s: click had no visible effect\n", c.Page, c.Widget)
+		}
+	}
+	return nil
+}
+
+// captureGlyphs writes the glyphs of the named encoder, which shows text, to
+// PNG files in directory dir.
+func captureGlyphs(ctx context.Context, v *venue.Venue, widget, text, dir string) error {
+	imgs, err := v.CaptureGlyphs(ctx, widget, text)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for r, img := range imgs {
+		path := filepath.Join(dir, venue.GlyphFile(r))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("Wrote %s.", path)
+	}
+	return nil
+}
+
+// stateURL returns the URL of the server console state.
+func stateURL() string { return fmt.Sprintf("http://%s/state", *stateAddr) }
+
+// saveServerState saves the console state of the server to the file at path.
+func saveServerState(path string) error {
+	resp, err := http.Get(stateURL())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get the server state; %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s.", path)
+	return nil
+}
+
+// loadServerState loads the console state file at path into the server.
+func loadServerState(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, stateURL(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	log.Printf("Loaded %s into the server.", path)
+	return nil
+}
+
+// readState loads the console state file at path into a new Venue, so that it
+// is validated. An empty path returns the default state.
+func readState(path string) (*venue.State, error) {
+	v, err := venue.New(venue.Buses(busConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer v.Close()
+	if path == "" {
+		return v.State(), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := v.LoadState(f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return v.State(), nil
+}
+
+// diffStates prints the changes between the console state files at paths from
+// and to. An empty path is the default state.
+func diffStates(from, to string) error {
+	a, err := readState(from)
+	if err != nil {
+		return err
+	}
+	b, err := readState(to)
+	if err != nil {
+		return err
+	}
+	for _, d := range venue.DiffStates(a, b) {
+		fmt.Println(d)
+	}
+	return nil
+}
+
+// recall drives the console to the state in the file at path, reporting the
+// progress, and the controls that could not be recalled.
+func recall(ctx context.Context, v *venue.Venue, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	st, err := venue.ParseState(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	fs, err := v.Recall(ctx, st, func(p venue.RecallProgress) {
+		log.Printf("Recalled input %d (%d/%d).", p.Input, p.Done, p.Total)
+	})
+	for _, f := range fs {
+		fmt.Println(f)
+	}
+	if err != nil {
+		return err
+	}
+	if len(fs) > 0 {
+		return fmt.Errorf("unable to recall %d controls", len(fs))
+	}
+	return nil
+}
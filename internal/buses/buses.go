@@ -0,0 +1,182 @@
+// Package buses defines the VENUE bus configuration, i.e. how the mix buses are
+// split between auxes and variable groups, and which are stereo.
+package buses
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+const (
+	// MaxBuses is the number of mix buses shared by the auxes and groups.
+	MaxBuses = 24
+	// MaxSends is the number of buses of one kind that the INPUTS page shows,
+	// as eight rows of two.
+	MaxSends = 16
+)
+
+// Config is a VENUE bus configuration. Stereo buses are odd/even pairs.
+type Config struct {
+	Auxes        int  // Number of aux buses.
+	Groups       int  // Number of variable group buses.
+	StereoAuxes  bool // True if the auxes are stereo pairs.
+	StereoGroups bool // True if the groups are stereo pairs.
+}
+
+// Default returns the "16 Auxes + 8 Variable Groups (24 bus)" configuration,
+// with all buses stereo.
+func Default() Config { return Config{16, 8, true, true} }
+
+// Parse parses a configuration of the form "<auxes>+<groups>", e.g. "8+16".
+// Buses are stereo, unless their count is followed by an "m" (e.g. "16m+8").
+func Parse(s string) (Config, error) {
+	as, gs, ok := strings.Cut(s, "+")
+	if !ok {
+		return Config{}, venuelib.Errorf(codes.InvalidArgument, "invalid bus configuration %q; want <auxes>+<groups>", s)
+	}
+	var c Config
+	var err error
+	if c.Auxes, c.StereoAuxes, err = parseCount(as); err != nil {
+		return Config{}, venuelib.Errorf(codes.InvalidArgument, "invalid bus configuration %q; %s", s, err)
+	}
+	if c.Groups, c.StereoGroups, err = parseCount(gs); err != nil {
+		return Config{}, venuelib.Errorf(codes.InvalidArgument, "invalid bus configuration %q; %s", s, err)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// parseCount parses a bus count, and whether the buses are stereo.
+func parseCount(s string) (int, bool, error) {
+	stereo := true
+	switch {
+	case strings.HasSuffix(s, "m"):
+		s, stereo = strings.TrimSuffix(s, "m"), false
+	case strings.HasSuffix(s, "s"):
+		s = strings.TrimSuffix(s, "s")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid bus count %q", s)
+	}
+	return n, stereo, nil
+}
+
+// String returns the configuration in the form understood by Parse.
+func (c Config) String() string {
+	count := func(n int, stereo bool) string {
+		if stereo {
+			return strconv.Itoa(n)
+		}
+		return strconv.Itoa(n) + "m"
+	}
+	return count(c.Auxes, c.StereoAuxes) + "+" + count(c.Groups, c.StereoGroups)
+}
+
+// Validate returns an error unless VENUE supports the configuration.
+func (c Config) Validate() error {
+	for _, b := range []struct {
+		sig signals.Signal
+		n   int
+	}{
+		{signals.Aux, c.Auxes},
+		{signals.Group, c.Groups},
+	} {
+		if b.n < 0 || b.n > MaxSends || b.n%2 != 0 {
+			return venuelib.Errorf(codes.InvalidArgument, "%s count %d is not an even number of up to %d", b.sig, b.n, MaxSends)
+		}
+	}
+	if c.Auxes+c.Groups > MaxBuses {
+		return venuelib.Errorf(codes.InvalidArgument, "%d auxes and %d groups exceed %d buses", c.Auxes, c.Groups, MaxBuses)
+	}
+	return nil
+}
+
+// Count returns the number of buses of signal `sig`.
+func (c Config) Count(sig signals.Signal) int {
+	switch sig {
+	case signals.Aux:
+		return c.Auxes
+	case signals.Group:
+		return c.Groups
+	}
+	return 0
+}
+
+// Stereo returns true if the buses of signal `sig` are stereo pairs.
+func (c Config) Stereo(sig signals.Signal) bool {
+	switch sig {
+	case signals.Aux:
+		return c.StereoAuxes
+	case signals.Group:
+		return c.StereoGroups
+	}
+	return false
+}
+
+// Has returns true if bus `sig` number `sigNo` exists.
+func (c Config) Has(sig signals.Signal, sigNo signals.SignalNo) bool {
+	return sigNo >= 1 && int(sigNo) <= c.Count(sig)
+}
+
+// Send is a bus as sent to from an input; a stereo pair is a single send.
+type Send struct {
+	Signal   signals.Signal
+	SignalNo signals.SignalNo // First bus of a stereo pair.
+	Stereo   bool
+}
+
+// String returns the send name, e.g. "Aux 1/2" or "Group 3".
+func (s Send) String() string {
+	if s.Stereo {
+		return fmt.Sprintf("%s %d/%d", s.Signal, s.SignalNo, s.SignalNo+1)
+	}
+	return fmt.Sprintf("%s %d", s.Signal, s.SignalNo)
+}
+
+// Send returns the send of bus `sig` number `sigNo`.
+func (c Config) Send(sig signals.Signal, sigNo signals.SignalNo) (Send, error) {
+	if !c.Has(sig, sigNo) {
+		return Send{}, venuelib.Errorf(codes.NotFound, "no %s %d bus in the %s bus configuration", sig, sigNo, c)
+	}
+	if c.Stereo(sig) {
+		return Send{sig, sigNo - (sigNo+1)%2, true}, nil
+	}
+	return Send{sig, sigNo, false}, nil
+}
+
+// Sends returns the sends in order: the auxes, then the groups.
+func (c Config) Sends() []Send {
+	var ss []Send
+	for _, sig := range []signals.Signal{signals.Aux, signals.Group} {
+		step := 1
+		if c.Stereo(sig) {
+			step = 2
+		}
+		for n := 1; n <= c.Count(sig); n += step {
+			ss = append(ss, Send{sig, signals.SignalNo(n), c.Stereo(sig)})
+		}
+	}
+	return ss
+}
+
+// Bus returns the signal and number of bus `n` (1..MaxBuses), counting the
+// auxes and then the groups, as the OUTPUTS page shows them. False is returned
+// if there is no such bus.
+func (c Config) Bus(n int) (signals.Signal, signals.SignalNo, bool) {
+	switch {
+	case n < 1:
+	case n <= c.Auxes:
+		return signals.Aux, signals.SignalNo(n), true
+	case n <= c.Auxes+c.Groups:
+		return signals.Group, signals.SignalNo(n - c.Auxes), true
+	}
+	return signals.Unknown, 0, false
+}
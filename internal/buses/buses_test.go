@@ -0,0 +1,105 @@
+package buses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want Config
+		code codes.Code
+	}{
+		{"16+8", Default(), codes.OK},
+		{"8+16", Config{8, 16, true, true}, codes.OK},
+		{"16m+8s", Config{16, 8, false, true}, codes.OK},
+		{"0+0m", Config{0, 0, true, false}, codes.OK},
+		{"16", Config{}, codes.InvalidArgument},
+		{"x+8", Config{}, codes.InvalidArgument},
+		{"7+8", Config{}, codes.InvalidArgument},
+		{"18+6", Config{}, codes.InvalidArgument},
+		{"16+16", Config{}, codes.InvalidArgument},
+	} {
+		got, err := Parse(tt.s)
+		if code := venuelib.Code(err); code != tt.code {
+			t.Errorf("Parse(%q) error = %v, want %s", tt.s, err, tt.code)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+		if tt.code != codes.OK {
+			continue
+		}
+		if rt, err := Parse(got.String()); err != nil || rt != got {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", got.String(), rt, err, got)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	c := Config{Auxes: 8, Groups: 16, StereoGroups: true}
+	for _, tt := range []struct {
+		sig   signals.Signal
+		sigNo signals.SignalNo
+		want  string
+		code  codes.Code
+	}{
+		{signals.Aux, 1, "Aux 1", codes.OK},
+		{signals.Aux, 8, "Aux 8", codes.OK},
+		{signals.Aux, 9, "", codes.NotFound},
+		{signals.Group, 1, "Group 1/2", codes.OK},
+		{signals.Group, 16, "Group 15/16", codes.OK},
+		{signals.Group, 0, "", codes.NotFound},
+		{signals.Matrix, 1, "", codes.NotFound},
+	} {
+		s, err := c.Send(tt.sig, tt.sigNo)
+		if code := venuelib.Code(err); code != tt.code {
+			t.Errorf("Send(%s, %d) error = %v, want %s", tt.sig, tt.sigNo, err, tt.code)
+			continue
+		}
+		if tt.code == codes.OK && s.String() != tt.want {
+			t.Errorf("Send(%s, %d) = %s, want %s", tt.sig, tt.sigNo, s, tt.want)
+		}
+	}
+}
+
+func TestSends(t *testing.T) {
+	var got []string
+	for _, s := range (Config{Auxes: 4, Groups: 4, StereoAuxes: true}).Sends() {
+		got = append(got, s.String())
+	}
+	if want := []string{"Aux 1/2", "Aux 3/4", "Group 1", "Group 2", "Group 3", "Group 4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sends() = %v, want %v", got, want)
+	}
+	if got, want := len(Default().Sends()), 12; got != want {
+		t.Errorf("got %d default sends, want %d", got, want)
+	}
+}
+
+func TestBus(t *testing.T) {
+	c := Config{Auxes: 8, Groups: 16}
+	for _, tt := range []struct {
+		n     int
+		sig   signals.Signal
+		sigNo signals.SignalNo
+		ok    bool
+	}{
+		{0, signals.Unknown, 0, false},
+		{1, signals.Aux, 1, true},
+		{8, signals.Aux, 8, true},
+		{9, signals.Group, 1, true},
+		{24, signals.Group, 16, true},
+		{25, signals.Unknown, 0, false},
+	} {
+		sig, sigNo, ok := c.Bus(tt.n)
+		if sig != tt.sig || sigNo != tt.sigNo || ok != tt.ok {
+			t.Errorf("Bus(%d) = %s, %d, %t; want %s, %d, %t", tt.n, sig, sigNo, ok, tt.sig, tt.sigNo, tt.ok)
+		}
+	}
+}
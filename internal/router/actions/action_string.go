@@ -4,9 +4,9 @@ package actions
 
 import "fmt"
 
-const _Action_name = "UnknownNoopPingSelectInputInputBankInputGainInputGuessInputMuteInputSoloInputPadInputPhantomSelectOutputOutputLevel"
+const _Action_name = "UnknownNoopPingSelectInputInputBankInputGainInputGuessInputMuteInputSoloInputPadInputPhantomSelectOutputOutputLevelOutputMute"
 
-var _Action_index = [...]uint8{0, 7, 11, 15, 26, 35, 44, 54, 63, 72, 80, 92, 104, 115}
+var _Action_index = [...]uint8{0, 7, 11, 15, 26, 35, 44, 54, 63, 72, 80, 92, 104, 115, 125}
 
 func (i Action) String() string {
 	if i < 0 || i >= Action(len(_Action_index)-1) {
@@ -38,4 +38,6 @@ const (
 	SelectOutput
 	// OutputLevel sets the level of an output channel.
 	OutputLevel
+	// OutputMute sets the state of an output mute button.
+	OutputMute
 )
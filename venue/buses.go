@@ -0,0 +1,160 @@
+package venue
+
+import (
+	"fmt"
+
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/controls"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+/*
+The bus widgets of a layout are slots, which the bus configuration names. The
+INPUTS page shows the sends in rows of two encoders, "Send %d" and
+"Send %d Right". A stereo send is a level encoder and a pan encoder, e.g.
+"Aux 1" and "AuxPan 1/2", and a pair of mono sends two level encoders, e.g.
+"Aux 1" and "Aux 2". The auxes and the groups share the rows, as VENUE shows
+one or the other. The OUTPUTS page shows the buses in order, "Bus %d Solo",
+"Bus %d Mute" and "Bus %d Meter", the auxes first and then the groups, e.g.
+"Aux 1 Solo".
+
+Layouts naming the bus widgets directly are left as they are.
+*/
+
+// Bus slot widget names.
+const (
+	sendSlot      = "Send %d"
+	sendRightSlot = "Send %d Right"
+	busSoloSlot   = "Bus %d Solo"
+	busMuteSlot   = "Bus %d Mute"
+	busMeterSlot  = "Bus %d Meter"
+)
+
+// assignBuses names the bus slot widgets of pages ps after the buses of
+// configuration c. Unused slots are removed.
+func (ps Pages) assignBuses(c buses.Config) error {
+	if p, ok := ps[pages.Inputs]; ok {
+		if err := p.assignSends(c); err != nil {
+			return err
+		}
+	}
+	if p, ok := ps[pages.Outputs]; ok {
+		if err := p.assignOutputs(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignSends names the send slots of the INPUTS page.
+func (p *Page) assignSends(c buses.Config) error {
+	slots := map[string]Widget{}
+	for row := 1; row <= buses.MaxSends/2; row++ {
+		for _, n := range []string{fmt.Sprintf(sendSlot, row), fmt.Sprintf(sendRightSlot, row)} {
+			if w, ok := p.widgets[n]; ok {
+				slots[n] = w
+				delete(p.widgets, n)
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	for _, s := range c.Sends() {
+		row := (int(s.SignalNo) + 1) / 2
+		left, right := slots[fmt.Sprintf(sendSlot, row)], slots[fmt.Sprintf(sendRightSlot, row)]
+		switch {
+		case s.Stereo:
+			if err := p.addSendWidget(outputName(s.Signal, s.SignalNo), left); err != nil {
+				return err
+			}
+			if err := p.addSendWidget(panName(s.Signal, s.SignalNo), right); err != nil {
+				return err
+			}
+		case s.SignalNo%2 != 0:
+			if err := p.addSendWidget(outputName(s.Signal, s.SignalNo), left); err != nil {
+				return err
+			}
+		default:
+			// An even mono send is a level encoder like its odd neighbour.
+			if r, ok := right.(*Encoder); ok {
+				if l, ok := left.(*Encoder); ok {
+					right = &Encoder{r.center, r.window, l.hasOnOff, r.glyphs}
+				}
+			}
+			if err := p.addSendWidget(outputName(s.Signal, s.SignalNo), right); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// assignOutputs names the bus slots of the OUTPUTS page.
+func (p *Page) assignOutputs(c buses.Config) error {
+	for n := 1; n <= buses.MaxBuses; n++ {
+		sig, sigNo, ok := c.Bus(n)
+		for _, slot := range []struct{ name, widget string }{
+			{busSoloSlot, "Solo"},
+			{busMuteSlot, "Mute"},
+			{busMeterSlot, "Meter"},
+		} {
+			name := fmt.Sprintf(slot.name, n)
+			w, found := p.widgets[name]
+			if !found {
+				continue
+			}
+			delete(p.widgets, name)
+			if !ok {
+				continue
+			}
+			if err := p.addBusWidget(outputName(sig, sigNo)+" "+slot.widget, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addSendWidget adds a copy of the send slot widget w, when present, named
+// after a send. The auxes and the groups share the slots, so each send has its
+// own copy, as the widgets are moved when located.
+func (p *Page) addSendWidget(name string, w Widget) error {
+	switch w := w.(type) {
+	case nil:
+		return nil
+	case *Encoder:
+		c := *w
+		return p.addBusWidget(name, &c)
+	case *Switch:
+		c := *w
+		return p.addBusWidget(name, &c)
+	case *Meter:
+		c := *w
+		return p.addBusWidget(name, &c)
+	}
+	return p.addBusWidget(name, w)
+}
+
+// addBusWidget adds widget w, named after a bus.
+func (p *Page) addBusWidget(name string, w Widget) error {
+	if _, ok := p.widgets[name]; ok {
+		return venuelib.Errorf(codes.InvalidArgument, "%s page: bus widget %q duplicates a layout widget", p.page, name)
+	}
+	p.widgets[name] = w
+	return nil
+}
+
+// panName returns the pan control name of the stereo bus pair starting at bus
+// `sig` number `sigNo`, e.g. "AuxPan 1/2".
+func panName(sig signals.Signal, sigNo signals.SignalNo) string {
+	ctrl := controls.AuxPan
+	if sig == signals.Group {
+		ctrl = controls.GroupPan
+	}
+	return fmt.Sprintf("%s %d/%d", ctrl, sigNo, sigNo+1)
+}
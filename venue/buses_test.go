@@ -0,0 +1,176 @@
+package venue
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/encoders"
+	"github.com/kward/venue/venue/pages"
+)
+
+func TestUIBuses(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		c       buses.Config
+		widgets map[pages.Page]map[string]image.Point // Widget click points.
+		absent  map[pages.Page][]string
+	}{
+		{"default", buses.Default(),
+			map[pages.Page]map[string]image.Point{
+				pages.Inputs: {
+					"Aux 1":        {354, 87},
+					"AuxPan 1/2":   {435, 87},
+					"Aux 15":       {354, 444},
+					"AuxPan 15/16": {435, 444},
+					"Group 7":      {354, 240},
+					"GroupPan 7/8": {435, 240},
+				},
+				pages.Outputs: {
+					"Aux 1 Solo":   {15, 580},
+					"Aux 16 Solo":  {251, 580},
+					"Group 1 Solo": {539, 580},
+					"Group 8 Solo": {644, 580},
+				},
+			},
+			map[pages.Page][]string{
+				pages.Inputs:  {"Aux 2", "Group 9", "Send 1", "Send 1 Right"},
+				pages.Outputs: {"Group 9 Solo", "Bus 1 Solo"},
+			}},
+		{"8 auxes and 16 groups", buses.Config{Auxes: 8, Groups: 16, StereoAuxes: true, StereoGroups: true},
+			map[pages.Page]map[string]image.Point{
+				pages.Inputs: {
+					"Aux 7":          {354, 240},
+					"Group 15":       {354, 444},
+					"GroupPan 15/16": {435, 444},
+				},
+				pages.Outputs: {
+					"Aux 8 Solo":    {120, 580},
+					"Group 1 Solo":  {146, 580},
+					"Group 16 Solo": {644, 580},
+				},
+			},
+			map[pages.Page][]string{
+				pages.Inputs:  {"Aux 9", "AuxPan 9/10"},
+				pages.Outputs: {"Aux 9 Solo"},
+			}},
+		{"mono auxes", buses.Config{Auxes: 16, Groups: 8, StereoGroups: true},
+			map[pages.Page]map[string]image.Point{
+				pages.Inputs: {
+					"Aux 1":  {354, 87},
+					"Aux 2":  {435, 87},
+					"Aux 16": {435, 444},
+				},
+			},
+			map[pages.Page][]string{
+				pages.Inputs: {"AuxPan 1/2"},
+			}},
+	} {
+		ui, err := NewUI(DefaultLayout(), tt.c)
+		if err != nil {
+			t.Fatalf("%s: NewUI() unexpected error; %s", tt.desc, err)
+		}
+		for p, ws := range tt.widgets {
+			for name, want := range ws {
+				w, err := ui.pages[p].Widget(name)
+				if err != nil {
+					t.Errorf("%s: %s/%s: unexpected error; %s", tt.desc, p, name, err)
+					continue
+				}
+				if got := w.(target).clickPoint(); got != want {
+					t.Errorf("%s: %s/%s click point = %s, want %s", tt.desc, p, name, got, want)
+				}
+			}
+		}
+		for p, names := range tt.absent {
+			for _, name := range names {
+				if _, err := ui.pages[p].Widget(name); err == nil {
+					t.Errorf("%s: %s/%s unexpectedly present", tt.desc, p, name)
+				}
+			}
+		}
+	}
+
+	// Mono even sends take the on/off switch of their odd neighbour.
+	ui, err := NewUI(DefaultLayout(), buses.Config{Auxes: 16, Groups: 8})
+	if err != nil {
+		t.Fatalf("NewUI() unexpected error; %s", err)
+	}
+	w, _ := ui.pages[pages.Inputs].Widget("Group 8")
+	if got, want := w, (&Encoder{image.Point{473, 248}, encoders.TopLeft, true, nil}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Group 8 = %+v, want %+v", got, want)
+	}
+}
+
+func TestUIBusesShared(t *testing.T) {
+	// The auxes and the groups share the send slots, yet move independently.
+	ui, err := NewUI(DefaultLayout(), buses.Default())
+	if err != nil {
+		t.Fatalf("NewUI() unexpected error; %s", err)
+	}
+	page := ui.pages[pages.Inputs]
+	aux, _ := page.Widget("Aux 1")
+	group, _ := page.Widget("Group 1")
+	if aux == group {
+		t.Fatalf("Aux 1 and Group 1 share a widget")
+	}
+	want := group.(target).clickPoint()
+	aux.(target).moveBy(image.Point{5, 5})
+	if got := group.(target).clickPoint(); got != want {
+		t.Errorf("Group 1 click point = %s, want %s", got, want)
+	}
+}
+
+func TestUIBusesDuplicate(t *testing.T) {
+	l, err := ParseLayout(strings.NewReader(`{"version": 1, "pages": [{"page": "Outputs", "widgets": [
+	  {"name": "Aux 1 Solo", "type": "Toggle", "x": 1, "y": 2, "size": "Tiny"},
+	  {"name": "Bus %d Solo", "type": "Toggle", "x": 1, "y": 20, "size": "Tiny", "repeat": {"count": 2, "dx": 15}}]}]}`))
+	if err != nil {
+		t.Fatalf("ParseLayout() unexpected error; %s", err)
+	}
+	if _, err := NewUI(l, buses.Default()); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("NewUI() error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestNewInputBuses(t *testing.T) {
+	i := NewInput(signals.Input, 1, buses.Config{Auxes: 8, Groups: 16, StereoGroups: true})
+	for _, tt := range []struct {
+		name string
+		code codes.Code
+	}{
+		{"Aux 8", codes.OK},
+		{"AuxPan 1/2", codes.NotFound},
+		{"Aux 9", codes.NotFound},
+		{"Group 1", codes.NotFound},
+	} {
+		if _, err := i.Control(tt.name); venuelib.Code(err) != tt.code {
+			t.Errorf("Control(%q) error = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestVenueBuses(t *testing.T) {
+	if _, err := New(Buses(buses.Config{Auxes: 16, Groups: 16})); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("New(Buses(16+16)) error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	v, err := New(Buses(buses.Config{Auxes: 8, Groups: 16, StereoAuxes: true, StereoGroups: true}))
+	if err != nil {
+		t.Fatalf("New() unexpected error; %s", err)
+	}
+	if _, err := v.Output(signals.Group, 16); err != nil {
+		t.Errorf("Output(Group, 16) unexpected error; %s", err)
+	}
+	if _, err := v.Output(signals.Aux, 9); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("Output(Aux, 9) error = %v, want %s", err, codes.NotFound)
+	}
+	if got, want := len(v.State().Outputs), 8+16+8+3; got != want {
+		t.Errorf("got %d output states, want %d", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+package venue
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+// target is implemented by widgets that can be located on the screen.
+type target interface {
+	bounds() image.Rectangle
+	clickPoint() image.Point
+	moveBy(d image.Point)
+}
+
+// Verify that the expected interfaces are implemented properly.
+var _ target = new(Encoder)
+var _ target = new(Meter)
+var _ target = new(Switch)
+
+// Mark locates a widget on the reference screen.
+type Mark struct {
+	Name   string
+	Bounds image.Rectangle // Empty if unknown.
+	Click  image.Point
+}
+
+// Marks returns the marks of the widgets on page p, ordered by name.
+func (ui *UI) Marks(p pages.Page) []Mark {
+	page, ok := ui.pages[p]
+	if !ok {
+		return nil
+	}
+	var ms []Mark
+	for n, w := range page.widgets {
+		if t, ok := w.(target); ok {
+			ms = append(ms, Mark{n, t.bounds(), t.clickPoint()})
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Name < ms[j].Name })
+	return ms
+}
+
+var (
+	markBounds = color.RGBA{0, 255, 0, 255}   // Widget bounding boxes.
+	markClick  = color.RGBA{255, 0, 0, 255}   // Widget click points.
+	markLabel  = color.RGBA{255, 255, 0, 255} // Widget names.
+	markShadow = color.RGBA{0, 0, 0, 255}     // Behind the widget names.
+)
+
+// DrawMarks draws the bounding box, click point and name of each mark onto img.
+// Transform tf maps the marks onto the image.
+func DrawMarks(img draw.Image, marks []Mark, tf vnc.Transform) {
+	for _, m := range marks {
+		if r := tf.Rect(m.Bounds); !r.Empty() {
+			drawOutline(img, r, markBounds)
+		}
+		p := tf.Point(m.Click)
+		for d := -3; d <= 3; d++ {
+			img.Set(p.X+d, p.Y, markClick)
+			img.Set(p.X, p.Y+d, markClick)
+		}
+		drawLabel(img, p.Add(image.Point{5, -labelHeight - 2}), m.Name)
+	}
+}
+
+// drawOutline draws the one pixel wide outline of rectangle r.
+func drawOutline(img draw.Image, r image.Rectangle, c color.Color) {
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.Set(x, r.Min.Y, c)
+		img.Set(x, r.Max.Y-1, c)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.Set(r.Min.X, y, c)
+		img.Set(r.Max.X-1, y, c)
+	}
+}
+
+// Labels are drawn uppercase with a 3x5 pixel font. Each glyph is described row
+// by row, with '#' marking a lit pixel. Unknown runes are drawn as spaces.
+var labelRows = map[rune][labelHeight]string{
+	'A': {" # ", "# #", "###", "# #", "# #"},
+	'B': {"## ", "# #", "## ", "# #", "## "},
+	'C': {" ##", "#  ", "#  ", "#  ", " ##"},
+	'D': {"## ", "# #", "# #", "# #", "## "},
+	'E': {"###", "#  ", "## ", "#  ", "###"},
+	'F': {"###", "#  ", "## ", "#  ", "#  "},
+	'G': {" ##", "#  ", "# #", "# #", " ##"},
+	'H': {"# #", "# #", "###", "# #", "# #"},
+	'I': {"###", " # ", " # ", " # ", "###"},
+	'J': {"  #", "  #", "  #", "# #", " # "},
+	'K': {"# #", "# #", "## ", "# #", "# #"},
+	'L': {"#  ", "#  ", "#  ", "#  ", "###"},
+	'M': {"# #", "###", "###", "# #", "# #"},
+	'N': {"## ", "# #", "# #", "# #", "# #"},
+	'O': {" # ", "# #", "# #", "# #", " # "},
+	'P': {"## ", "# #", "## ", "#  ", "#  "},
+	'Q': {" # ", "# #", "# #", "## ", " ##"},
+	'R': {"## ", "# #", "## ", "# #", "# #"},
+	'S': {" ##", "#  ", " # ", "  #", "## "},
+	'T': {"###", " # ", " # ", " # ", " # "},
+	'U': {"# #", "# #", "# #", "# #", "###"},
+	'V': {"# #", "# #", "# #", "# #", " # "},
+	'W': {"# #", "# #", "###", "###", "# #"},
+	'X': {"# #", "# #", " # ", "# #", "# #"},
+	'Y': {"# #", "# #", " # ", " # ", " # "},
+	'Z': {"###", "  #", " # ", "#  ", "###"},
+	'0': {"###", "# #", "# #", "# #", "###"},
+	'1': {" # ", "## ", " # ", " # ", "###"},
+	'2': {"## ", "  #", " # ", "#  ", "###"},
+	'3': {"## ", "  #", " # ", "  #", "## "},
+	'4': {"# #", "# #", "###", "  #", "  #"},
+	'5': {"###", "#  ", "## ", "  #", "## "},
+	'6': {" ##", "#  ", "###", "# #", "###"},
+	'7': {"###", "  #", " # ", " # ", " # "},
+	'8': {"###", "# #", "###", "# #", "###"},
+	'9': {"###", "# #", "###", "  #", "## "},
+	'/': {"  #", "  #", " # ", "#  ", "#  "},
+	'-': {"   ", "   ", "###", "   ", "   "},
+}
+
+const (
+	labelHeight  = 5 // Height of a label glyph in pixels.
+	labelAdvance = 4 // Horizontal distance between label glyphs.
+)
+
+// drawLabel draws text s with its top-left corner at p, on a dark background.
+func drawLabel(img draw.Image, p image.Point, s string) {
+	rs := []rune(strings.ToUpper(s))
+	bg := image.Rect(0, 0, len(rs)*labelAdvance+1, labelHeight+2).Add(p.Sub(image.Point{1, 1}))
+	draw.Draw(img, bg, image.NewUniform(markShadow), image.Point{}, draw.Src)
+	for i, r := range rs {
+		rows, ok := labelRows[r]
+		if !ok {
+			continue
+		}
+		for y, row := range rows {
+			for x, c := range row {
+				if c == '#' {
+					img.Set(p.X+i*labelAdvance+x, p.Y+y, markLabel)
+				}
+			}
+		}
+	}
+}
+
+// Calibration is the outcome of clicking a widget while calibrating.
+type Calibration struct {
+	Page    pages.Page
+	Widget  string
+	Changed bool // True if the click visibly changed the widget.
+}
+
+// Calibrate selects each page of the layout in turn, and calls fn with an image
+// of the page overlaid with the marks of its widgets. Should click be true,
+// every widget of the page is then clicked in turn, and the outcome of each
+// click is returned. Clicks change the console state: toggles are clicked
+// again to restore them, and encoder fields are escaped from.
+func (v *Venue) Calibrate(ctx context.Context, click bool, fn func(p pages.Page, img image.Image) error) ([]Calibration, error) {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	ui := v.currentUI()
+	var ps []pages.Page
+	for p := range ui.pages {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+
+	var cs []Calibration
+	for _, p := range ps {
+		if err := v.showPage(ctx, ui, p); err != nil {
+			return cs, err
+		}
+		fb := v.client().Framebuffer()
+		if fb == nil {
+			return cs, venuelib.Errorf(codes.FailedPrecondition, "no framebuffer to calibrate with")
+		}
+		img := fb.Snapshot().Image()
+		DrawMarks(img, ui.Marks(p), screenTransform(img.Bounds().Size()))
+		if err := fn(p, img); err != nil {
+			return cs, err
+		}
+		if !click {
+			continue
+		}
+		for _, m := range ui.Marks(p) {
+			changed, err := v.calibrationClick(ctx, p, m.Name)
+			if err != nil {
+				return cs, err
+			}
+			if glog.V(2) {
+				glog.Infof("Clicking %s/%s changed it: %t", p, m.Name, changed)
+			}
+			cs = append(cs, Calibration{p, m.Name, changed})
+		}
+	}
+	return cs, nil
+}
+
+// calibrationClick clicks the named widget of page p, and returns true if the
+// widget visibly changed. The widget is restored afterwards where possible.
+func (v *Venue) calibrationClick(ctx context.Context, p pages.Page, name string) (bool, error) {
+	ui := v.currentUI()
+	if err := v.showPage(ctx, ui, p); err != nil {
+		return false, err
+	}
+	w, err := ui.pages[p].Widget(name)
+	if err != nil {
+		return false, err
+	}
+	t := w.(target)
+	r := t.bounds()
+	if r.Empty() {
+		r = image.Rectangle{t.clickPoint(), t.clickPoint().Add(image.Point{1, 1})}
+	}
+
+	wf := v.newWorkflow()
+	if err := w.Press(wf); err != nil {
+		return false, err
+	}
+	wf.WaitForRegionChange(r, v.verifyTimeout())
+	changed := true
+	if err := v.executeCtx(ctx, wf, vnc.Normal); err != nil {
+		if venuelib.Code(err) != codes.DeadlineExceeded {
+			return false, err
+		}
+		changed = false
+	}
+
+	wf = v.newWorkflow()
+	switch w := w.(type) {
+	case *Encoder:
+		wf.KeyPress(keys.Escape)
+	case *Switch:
+		if changed && w.IsToggle() {
+			w.Press(wf)
+		}
+	}
+	if wf.Len() == 0 {
+		return changed, nil
+	}
+	wf.Sleep(v.verifyTimeout())
+	return changed, v.executeCtx(ctx, wf, vnc.Normal)
+}
@@ -0,0 +1,74 @@
+package venue
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/venue/pages"
+)
+
+func TestMarks(t *testing.T) {
+	ui := defaultUI(t)
+	ms := ui.Marks(pages.Inputs)
+	if got, want := len(ms), len(ui.pages[pages.Inputs].widgets); got != want {
+		t.Errorf("got %d marks, want %d", got, want)
+	}
+	for i := 1; i < len(ms); i++ {
+		if ms[i-1].Name >= ms[i].Name {
+			t.Errorf("marks are not ordered by name; %q >= %q", ms[i-1].Name, ms[i].Name)
+		}
+	}
+
+	for _, tt := range []struct {
+		page pages.Page
+		mark Mark
+	}{
+		{pages.Inputs, Mark{"Mute", image.Rect(62, 451, 94, 469), image.Point{78, 460}}},
+		{pages.Inputs, Mark{"Gain", image.Rect(105, 282, 153, 293), image.Point{129, 287}}},
+		{pages.Outputs, Mark{"Aux 1 Meter", image.Rect(8, 512, 21, 562), image.Point{15, 537}}},
+	} {
+		found := false
+		for _, m := range ui.Marks(tt.page) {
+			if m.Name != tt.mark.Name {
+				continue
+			}
+			found = true
+			if got, want := m, tt.mark; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: mark = %+v, want %+v", tt.mark.Name, got, want)
+			}
+		}
+		if !found {
+			t.Errorf("%s: mark not found", tt.mark.Name)
+		}
+	}
+	if ms := ui.Marks(pages.Options); ms != nil {
+		t.Errorf("Marks(%s) = %v, want nil", pages.Options, ms)
+	}
+}
+
+func TestDrawMarks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	marks := []Mark{{"A1", image.Rect(10, 20, 30, 40), image.Point{20, 30}}}
+	DrawMarks(img, marks, vnc.Identity)
+
+	for _, tt := range []struct {
+		desc string
+		p    image.Point
+		want color.RGBA
+	}{
+		{"bounds top-left", image.Point{10, 20}, markBounds},
+		{"bounds bottom-right", image.Point{29, 39}, markBounds},
+		{"click point", image.Point{20, 30}, markClick},
+		{"crosshair", image.Point{20, 33}, markClick},
+		{"label background", image.Point{24, 22}, markShadow},
+		{"label", image.Point{26, 23}, markLabel}, // Top of the 'A'.
+		{"untouched", image.Point{50, 50}, color.RGBA{}},
+	} {
+		if got, want := img.RGBAAt(tt.p.X, tt.p.Y), tt.want; got != want {
+			t.Errorf("%s: pixel %s = %v, want %v", tt.desc, tt.p, got, want)
+		}
+	}
+}
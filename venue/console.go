@@ -5,9 +5,15 @@ signals that are not currently exposed by the UI.
 package venue
 
 import (
+	"fmt"
 	"math"
+	"strings"
 
+	"github.com/golang/glog"
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
 	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
 )
 
 const (
@@ -19,29 +25,62 @@ const (
 	panMax = 100.0
 )
 
+// Encoder step sizes, i.e. the value change of a single arrow key press.
+const (
+	gainStep  = 0.5 // dB
+	levelStep = 0.5 // dB, of faders and sends.
+	delayStep = 0.1 // ms
+	hpfStep   = 1.0 // Hz
+	panStep   = 1.0
+)
+
 var (
 	auxMin = math.Inf(-1)
 )
 
+// levelFloor is the lowest finite level, in dB, of a signal that can be turned
+// all the way down to -inf. Stepping up from -inf starts here, and stepping
+// below it reaches -inf.
+const levelFloor = -60.0
+
+// Signal is the modelled value of a console control. Values are changed
+// optimistically as the console is driven, and are estimated until confirmed
+// by reading them from VENUE.
 type Signal struct {
 	val, defVal, min, max float64 // Value
+	step                  float64 // Value change of a single encoder step
 	prec                  int     // Precision
 	unit                  string  // Measurement unit
 	ena, defEna           bool    // Enabled
+	estimated             bool    // Not yet confirmed by VENUE
 }
 type Signals map[string]*Signal
 
-func NewSignal(defVal, min, max float64, prec int, unit string, defEna bool) *Signal {
+// clone returns a deep copy of the signals.
+func (sigs Signals) clone() Signals {
+	c := Signals{}
+	for n, sig := range sigs {
+		v := *sig
+		c[n] = &v
+	}
+	return c
+}
+
+func NewSignal(defVal, min, max, step float64, prec int, unit string, defEna bool) *Signal {
 	return &Signal{
 		defVal: defVal,
 		min:    min,
 		max:    max,
+		step:   step,
 		prec:   prec,
 		unit:   unit,
 		defEna: defEna,
 	}
 }
 
+// newSwitch returns a signal modelling a switch, which is enabled when on.
+func newSwitch() *Signal { return NewSignal(0, 0, 0, 0, 0, "", false) }
+
 func (sig *Signal) Enabled() bool {
 	return sig.ena
 }
@@ -50,9 +89,79 @@ func (sig *Signal) Value() float64 {
 	return sig.val
 }
 
+// Estimated returns true if the value has not been confirmed by VENUE.
+func (sig *Signal) Estimated() bool {
+	return sig.estimated
+}
+
+// Reset the signal to its default, which is an estimate.
 func (sig *Signal) Reset() {
 	sig.val = sig.defVal
 	sig.ena = sig.defEna
+	sig.estimated = true
+}
+
+// Set the value, clamped to the signal range and rounded to its precision.
+func (sig *Signal) Set(v float64) {
+	switch {
+	case v < sig.min:
+		v = sig.min
+	case v > sig.max:
+		v = sig.max
+	}
+	if !math.IsInf(v, 0) {
+		p := math.Pow10(sig.prec)
+		v = math.Round(v*p) / p
+	}
+	sig.val = v
+	sig.estimated = true
+}
+
+// Step the value by `n` encoder steps.
+func (sig *Signal) Step(n int) {
+	v := sig.val
+	if math.IsInf(sig.min, -1) {
+		if math.IsInf(v, -1) && n > 0 {
+			v = levelFloor
+			n--
+		}
+		if v += float64(n) * sig.step; v < levelFloor {
+			v = math.Inf(-1)
+		}
+		sig.Set(v)
+		return
+	}
+	sig.Set(v + float64(n)*sig.step)
+}
+
+// stepsTo returns the number of encoder steps from the value to `v`.
+func (sig *Signal) stepsTo(v float64) int {
+	if sig.step == 0 {
+		return 0
+	}
+	// Position in steps, with -inf one step below the level floor.
+	pos := func(v float64) float64 {
+		switch {
+		case !math.IsInf(sig.min, -1):
+			return v / sig.step
+		case math.IsInf(v, -1):
+			return -1
+		}
+		return (v - levelFloor) / sig.step
+	}
+	v = math.Max(sig.min, math.Min(sig.max, v))
+	return int(math.Round(pos(v) - pos(sig.val)))
+}
+
+// SetEnabled sets whether the signal is enabled, e.g. a switch is on.
+func (sig *Signal) SetEnabled(on bool) {
+	sig.ena = on
+	sig.estimated = true
+}
+
+// Confirm that the signal matches VENUE.
+func (sig *Signal) Confirm() {
+	sig.estimated = false
 }
 
 // Input represents an input signal.
@@ -63,44 +172,39 @@ type Input struct {
 	sends Signals
 }
 
-func NewInput(sig signals.Signal, sigNo signals.SignalNo) *Input {
+// NewInput returns a reset input, sending to the aux buses of configuration c.
+func NewInput(sig signals.Signal, sigNo signals.SignalNo, c buses.Config) *Input {
 	i := &Input{
 		sig:   sig,
 		sigNo: sigNo,
 		prop: Signals{
-			"Fader": NewSignal(math.Inf(-1), math.Inf(-1), 15, 1, "dB", true),
-			"Gain":  NewSignal(10, 10, 60, 1, "dB", true),
-			"Delay": NewSignal(0, 0, 250, 0, "ms", false),
-			"HPF":   NewSignal(100, 20, 500, 0, "Hz", true),
+			"Fader": NewSignal(math.Inf(-1), math.Inf(-1), 15, levelStep, 1, "dB", true),
+			"Gain":  NewSignal(10, 10, 60, gainStep, 1, "dB", true),
+			"Delay": NewSignal(0, 0, 250, delayStep, 1, "ms", false),
+			"HPF":   NewSignal(100, 20, 500, hpfStep, 0, "Hz", true),
+			// Switches.
+			"Mute":    newSwitch(),
+			"Pad":     newSwitch(),
+			"Phantom": newSwitch(),
+			"Phase":   newSwitch(),
+			"Solo":    newSwitch(),
 		},
 		sends: Signals{
-			"Pan":          NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 1":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 2":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 1/2":   NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 3":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 4":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 3/4":   NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 5":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 6":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 5/6":   NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 7":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 8":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 7/8":   NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 9":        NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 10":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 9/10":  NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 11":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 12":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 11/12": NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 13":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 14":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 13/14": NewSignal(panDef, panMin, panMax, 0, "", false),
-			"Aux 15":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"Aux 16":       NewSignal(auxDef, auxMin, auxMax, 1, "dB", true),
-			"AuxPan 15/16": NewSignal(panDef, panMin, panMax, 0, "", false),
+			"Pan": NewSignal(panDef, panMin, panMax, panStep, 0, "", false),
 		},
 	}
+	// Group sends are not modelled, as the INPUTS page shows them in place of
+	// the aux sends, so they cannot be told apart when read.
+	for _, snd := range c.Sends() {
+		if snd.Signal != signals.Aux {
+			continue
+		}
+		i.sends[outputName(snd.Signal, snd.SignalNo)] = NewSignal(auxDef, auxMin, auxMax, levelStep, 1, "dB", true)
+		if snd.Stereo {
+			i.sends[outputName(snd.Signal, snd.SignalNo+1)] = NewSignal(auxDef, auxMin, auxMax, levelStep, 1, "dB", true)
+			i.sends[panName(snd.Signal, snd.SignalNo)] = NewSignal(panDef, panMin, panMax, panStep, 0, "", false)
+		}
+	}
 	i.Reset()
 	return i
 }
@@ -114,31 +218,185 @@ func (i *Input) Reset() {
 	}
 }
 
-// Output represents an input signal.
+// SignalNo returns the input signal number.
+func (i *Input) SignalNo() signals.SignalNo { return i.sigNo }
+
+// Control returns the named control of the input, e.g. "Gain" or "Aux 5".
+func (i *Input) Control(name string) (*Signal, error) {
+	if sig, ok := i.prop[name]; ok {
+		return sig, nil
+	}
+	if sig, ok := i.sends[name]; ok {
+		return sig, nil
+	}
+	return nil, venuelib.Errorf(codes.NotFound, "unknown input control %q", name)
+}
+
+// clone returns a deep copy of the input.
+func (i *Input) clone() *Input {
+	return &Input{sig: i.sig, sigNo: i.sigNo, prop: i.prop.clone(), sends: i.sends.clone()}
+}
+
+// confirm reads the controls of the input shown on page p from sampler s, and
+// confirms those read. Switches confirm their state, and encoders their value
+// should they have glyphs to read it with. Controls that cannot be read remain
+// estimated.
+func (i *Input) confirm(p *Page, s sampler) {
+	for _, sigs := range []Signals{i.prop, i.sends} {
+		for name, sig := range sigs {
+			w, err := p.Widget(name)
+			if err != nil {
+				continue
+			}
+			switch w := w.(type) {
+			case *Encoder:
+				if len(w.glyphs) == 0 {
+					continue
+				}
+				ev, err := w.read(s)
+				if err != nil || (ev.Unit != "" && !strings.EqualFold(ev.Unit, sig.unit)) {
+					if glog.V(4) {
+						glog.Infof("Unable to confirm input %d %s; %v %v", i.sigNo, name, ev, err)
+					}
+					continue
+				}
+				sig.Set(ev.Value)
+			case *Switch:
+				on, err := w.read(s)
+				if err != nil {
+					continue
+				}
+				sig.SetEnabled(on)
+			default:
+				continue
+			}
+			sig.Confirm()
+		}
+	}
+}
+
+// outputCount is the number of output buses of a signal.
+type outputCount struct {
+	sig signals.Signal
+	num int
+}
+
+// outputCounts returns the output bus counts of bus configuration c. The
+// variable groups are the Group buses of the configuration, so they are not
+// counted separately.
+func outputCounts(c buses.Config) []outputCount {
+	return []outputCount{
+		{signals.Aux, c.Auxes},
+		{signals.Group, c.Groups},
+		{signals.Matrix, 8},
+		{signals.Mains, 3},
+	}
+}
+
+// Outputs holds the output signals, keyed by output name (e.g. "Aux 5").
+type Outputs map[string]*Output
+
+// NewOutputs returns a reset model of every output bus of bus configuration c.
+func NewOutputs(c buses.Config) Outputs {
+	outs := Outputs{}
+	for _, c := range outputCounts(c) {
+		for n := 1; n <= c.num; n++ {
+			o := NewOutput(c.sig, signals.SignalNo(n))
+			outs[o.Name()] = o
+		}
+	}
+	return outs
+}
+
+// Output returns the output of signal `sig` number `sigNo`. Variable groups are
+// returned as the group buses they are.
+func (outs Outputs) Output(sig signals.Signal, sigNo signals.SignalNo) (*Output, error) {
+	o, ok := outs[outputName(outputSignal(sig), sigNo)]
+	if !ok {
+		return nil, venuelib.Errorf(codes.NotFound, "unknown %s %d output", sig, sigNo)
+	}
+	return o, nil
+}
+
+// Solo solos output `sig` number `sigNo`, clearing the solo of all others.
+func (outs Outputs) Solo(sig signals.Signal, sigNo signals.SignalNo) error {
+	o, err := outs.Output(sig, sigNo)
+	if err != nil {
+		return err
+	}
+	for _, o := range outs {
+		o.prop["Solo"].SetEnabled(false)
+	}
+	o.prop["Solo"].SetEnabled(true)
+	return nil
+}
+
+// outputSignal returns the signal modelling output `sig`. The variable groups
+// are the Group buses of the bus configuration.
+func outputSignal(sig signals.Signal) signals.Signal {
+	if sig == signals.VarGroup {
+		return signals.Group
+	}
+	return sig
+}
+
+// outputName returns the name of output `sig` number `sigNo`.
+func outputName(sig signals.Signal, sigNo signals.SignalNo) string {
+	return fmt.Sprintf("%s %d", sig, sigNo)
+}
+
+// Output represents an output signal. Switches, e.g. Mute, are enabled when on.
+// Only the controls that the handlers drive on VENUE are modelled; the master
+// level and pan of the outputs are not reachable through the UI layout.
 type Output struct {
 	sig   signals.Signal
 	sigNo signals.SignalNo
 	prop  Signals
-	sends Signals
 }
 
-// func NewOutput(sig signals.Signal, sigNo signals.SignalNo) *Output {
-// 	o := &Output{
-// 		sig:   sig,
-// 		sigNo: sigNo,
-// 		prop: Signals{
-// 			"Fader": NewSignal(math.Inf(-1), math.Inf(-1), 15, 1, "dB", true),
-// 		},
-// 		sends: Signals{
-// 			"Pan": NewSignal(panDef, panMin, panMax, 0, "", false),
-// 		},
-// 	}
-// 	o.Reset()
-// 	return o
-// }
-
-// func (o *Output) Reset() {
-// 	for _, p := range o.prop {
-// 		p.Reset()
-// 	}
-// }
+func NewOutput(sig signals.Signal, sigNo signals.SignalNo) *Output {
+	o := &Output{
+		sig:   sig,
+		sigNo: sigNo,
+		prop: Signals{
+			"Mute": newSwitch(),
+			"Solo": newSwitch(),
+		},
+	}
+	o.Reset()
+	return o
+}
+
+func (o *Output) Reset() {
+	for _, p := range o.prop {
+		p.Reset()
+	}
+}
+
+// clone returns a deep copy of the output.
+func (o *Output) clone() *Output {
+	return &Output{sig: o.sig, sigNo: o.sigNo, prop: o.prop.clone()}
+}
+
+// Control returns the named control of the output, e.g. "Mute" or "Solo".
+func (o *Output) Control(name string) (*Signal, error) {
+	if sig, ok := o.prop[name]; ok {
+		return sig, nil
+	}
+	return nil, venuelib.Errorf(codes.NotFound, "unknown %s control %q", o.Name(), name)
+}
+
+// Name returns the output name, e.g. "Aux 5".
+func (o *Output) Name() string { return outputName(o.sig, o.sigNo) }
+
+// Signal returns the output signal type.
+func (o *Output) Signal() signals.Signal { return o.sig }
+
+// SignalNo returns the output signal number.
+func (o *Output) SignalNo() signals.SignalNo { return o.sigNo }
+
+// Muted returns true if the output is muted.
+func (o *Output) Muted() bool { return o.prop["Mute"].Enabled() }
+
+// Soloed returns true if the output is soloed.
+func (o *Output) Soloed() bool { return o.prop["Solo"].Enabled() }
@@ -1,13 +1,292 @@
 package venue
 
 import (
+	"image"
+	"image/draw"
+	"math"
 	"testing"
 
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
 	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
 )
 
-// This test references Output fields to indicate intent and avoid U1000 until
-// Output is fully wired in production code.
-func TestOutputFieldsReferenced(t *testing.T) {
-	_ = Output{sig: signals.Aux, sigNo: 1}
+func TestNewOutputs(t *testing.T) {
+	outs := NewOutputs(buses.Default())
+	if got, want := len(outs), 16+8+8+3; got != want {
+		t.Errorf("got %d outputs, want %d", got, want)
+	}
+	for _, tt := range []struct {
+		sig   signals.Signal
+		sigNo signals.SignalNo
+		code  codes.Code
+	}{
+		{signals.Aux, 1, codes.OK},
+		{signals.Aux, 16, codes.OK},
+		{signals.Aux, 17, codes.NotFound},
+		{signals.Group, 8, codes.OK},
+		{signals.VarGroup, 8, codes.OK},
+		{signals.VarGroup, 9, codes.NotFound},
+		{signals.Matrix, 8, codes.OK},
+		{signals.Mains, 3, codes.OK},
+		{signals.Input, 1, codes.NotFound},
+	} {
+		o, err := outs.Output(tt.sig, tt.sigNo)
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("Output(%s, %d) error code = %s, want %s", tt.sig, tt.sigNo, got, want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got, want := o.Signal(), outputSignal(tt.sig); got != want {
+			t.Errorf("Output(%s, %d) signal = %s, want %s", tt.sig, tt.sigNo, got, want)
+		}
+		if got, want := o.SignalNo(), tt.sigNo; got != want {
+			t.Errorf("Output(%s, %d) signal number = %d, want %d", tt.sig, tt.sigNo, got, want)
+		}
+	}
+}
+
+func TestOutputReset(t *testing.T) {
+	o := NewOutput(signals.Aux, 5)
+	if got, want := o.Name(), "Aux 5"; got != want {
+		t.Errorf("Name() = %q, want %q", got, want)
+	}
+	o.prop["Mute"].ena = true
+	o.prop["Solo"].ena = true
+	o.Reset()
+	if o.Muted() {
+		t.Error("Muted() = true after Reset()")
+	}
+	if o.Soloed() {
+		t.Error("Soloed() = true after Reset()")
+	}
+	if _, err := o.Control("Master"); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("Control(Master) error = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestOutputsSolo(t *testing.T) {
+	outs := NewOutputs(buses.Default())
+	for _, tt := range []struct {
+		sig   signals.Signal
+		sigNo signals.SignalNo
+	}{
+		{signals.Aux, 1},
+		{signals.Group, 3},
+		{signals.Aux, 5},
+	} {
+		if err := outs.Solo(tt.sig, tt.sigNo); err != nil {
+			t.Fatalf("Solo(%s, %d) unexpected error; %s", tt.sig, tt.sigNo, err)
+		}
+		for name, o := range outs {
+			if got, want := o.Soloed(), name == outputName(tt.sig, tt.sigNo); got != want {
+				t.Errorf("Solo(%s, %d): %s soloed = %t, want %t", tt.sig, tt.sigNo, name, got, want)
+			}
+		}
+	}
+	if err := outs.Solo(signals.Aux, 17); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("Solo(Aux, 17) error = %v, want %s", err, codes.NotFound)
+	}
+	if !outs["Aux 5"].Soloed() {
+		t.Error("failed Solo() changed the soloed output")
+	}
+}
+
+func TestVenueOutput(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("New() unexpected error; %s", err)
+	}
+	if err := v.soloOutput(signals.Aux, 5); err != nil {
+		t.Fatalf("soloOutput() unexpected error; %s", err)
+	}
+	o, err := v.Output(signals.Aux, 5)
+	if err != nil {
+		t.Fatalf("Output() unexpected error; %s", err)
+	}
+	if !o.Soloed() {
+		t.Error("Aux 5 not soloed")
+	}
+	// The returned output is a copy.
+	o.prop["Solo"].ena = false
+	if o, _ := v.Output(signals.Aux, 5); !o.Soloed() {
+		t.Error("changing the returned output changed the model")
+	}
+}
+
+func TestSignalSet(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		sig  *Signal
+		val  float64
+		want float64
+	}{
+		{"in range", NewSignal(10, 10, 60, 1, 1, "dB", true), 24, 24},
+		{"rounded", NewSignal(10, 10, 60, 1, 1, "dB", true), 24.06, 24.1},
+		{"below", NewSignal(10, 10, 60, 1, 1, "dB", true), 5, 10},
+		{"above", NewSignal(10, 10, 60, 1, 1, "dB", true), 70, 60},
+		{"-inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(-1), math.Inf(-1)},
+		{"+inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(1), auxMax},
+	} {
+		tt.sig.Reset()
+		tt.sig.Confirm()
+		tt.sig.Set(tt.val)
+		if got, want := tt.sig.Value(), tt.want; got != want {
+			t.Errorf("%s: Set(%v) value = %v, want %v", tt.desc, tt.val, got, want)
+		}
+		if !tt.sig.Estimated() {
+			t.Errorf("%s: Set(%v) value not estimated", tt.desc, tt.val)
+		}
+	}
+}
+
+func TestSignalStep(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		sig  *Signal
+		val  float64
+		n    int
+		want float64
+	}{
+		{"up", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, 3, 23},
+		{"down", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, -3, 17},
+		{"clamped", NewSignal(10, 10, 60, 1, 1, "dB", true), 12, -5, 10},
+		{"half steps", NewSignal(0, -10, 10, 0.5, 1, "dB", true), 0, 3, 1.5},
+		{"up from -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(-1), 1, levelFloor},
+		{"further from -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(-1), 3, levelFloor + 2},
+		{"down to -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), levelFloor + 1, -2, math.Inf(-1)},
+		{"down at -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(-1), -1, math.Inf(-1)},
+		{"up to max", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), 10, 5, auxMax},
+	} {
+		tt.sig.Set(tt.val)
+		tt.sig.Step(tt.n)
+		if got, want := tt.sig.Value(), tt.want; got != want {
+			t.Errorf("%s: Step(%d) from %v = %v, want %v", tt.desc, tt.n, tt.val, got, want)
+		}
+	}
+}
+
+func TestSignalEstimated(t *testing.T) {
+	sig := newSwitch()
+	sig.Reset()
+	if !sig.Estimated() {
+		t.Error("reset signal not estimated")
+	}
+	sig.SetEnabled(true)
+	if !sig.Enabled() || !sig.Estimated() {
+		t.Errorf("SetEnabled(true): enabled = %t, estimated = %t; want true, true", sig.Enabled(), sig.Estimated())
+	}
+	sig.Confirm()
+	if sig.Estimated() {
+		t.Error("confirmed signal estimated")
+	}
+}
+
+func TestInputControl(t *testing.T) {
+	i := NewInput(signals.Input, 3, buses.Default())
+	for _, tt := range []struct {
+		name string
+		code codes.Code
+	}{
+		{"Gain", codes.OK},
+		{"Mute", codes.OK},
+		{"Aux 5", codes.OK},
+		{"AuxPan 1/2", codes.OK},
+		{"Aux 17", codes.NotFound},
+		{"Group 1", codes.NotFound},
+	} {
+		if _, err := i.Control(tt.name); venuelib.Code(err) != tt.code {
+			t.Errorf("Control(%q) error = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestInputConfirm(t *testing.T) {
+	ui := defaultUI(t)
+	page := ui.pages[pages.Inputs]
+	w, err := page.Widget("Gain")
+	if err != nil {
+		t.Fatalf("Widget() unexpected error; %s", err)
+	}
+	img := newTextImage(image.Rectangle{Max: screenSize}, w.(*Encoder).windowRect().Min.Add(image.Point{2, 2}), "+24")
+	w, err = page.Widget("Mute")
+	if err != nil {
+		t.Fatalf("Widget() unexpected error; %s", err)
+	}
+	draw.Draw(img, image.Rect(4, 4, 28, 14).Add(w.(*Switch).pos), image.NewUniform(ledOn), image.Point{}, draw.Src)
+
+	// Encoder values are only recognized with glyphs.
+	i := NewInput(signals.Input, 1, buses.Default())
+	i.confirm(page, img)
+	if sig, _ := i.Control("Gain"); !sig.Estimated() {
+		t.Errorf("Gain confirmed without glyphs")
+	}
+
+	ui.setGlyphs(testGlyphs(t))
+	i = NewInput(signals.Input, 1, buses.Default())
+	i.confirm(page, img)
+	for _, tt := range []struct {
+		name      string
+		val       float64
+		ena       bool
+		estimated bool
+	}{
+		{"Gain", 24, true, false},
+		{"Mute", 0, true, false},
+		{"Pad", 0, false, false},
+		{"Aux 1", auxDef, true, true}, // Blank value window.
+		{"Fader", math.Inf(-1), true, true},
+	} {
+		sig, err := i.Control(tt.name)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.name, err)
+			continue
+		}
+		if got, want := sig.Value(), tt.val; got != want {
+			t.Errorf("%s: value = %v, want %v", tt.name, got, want)
+		}
+		if got, want := sig.Enabled(), tt.ena; got != want {
+			t.Errorf("%s: enabled = %t, want %t", tt.name, got, want)
+		}
+		if got, want := sig.Estimated(), tt.estimated; got != want {
+			t.Errorf("%s: estimated = %t, want %t", tt.name, got, want)
+		}
+	}
+}
+
+func TestUpdateInput(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("New() unexpected error; %s", err)
+	}
+
+	// No input is selected, so nothing changes.
+	v.updateInput("Gain", func(sig *Signal) { sig.Step(10) })
+	v.selectInput(2)
+	v.updateInput("Gain", func(sig *Signal) { sig.Step(10) })
+	v.updateInput("Unknown", func(sig *Signal) { t.Error("unknown control updated") })
+
+	for _, tt := range []struct {
+		sigNo signals.SignalNo
+		want  float64
+	}{
+		{1, 10},
+		{2, 15},
+	} {
+		i, err := v.Input(tt.sigNo)
+		if err != nil {
+			t.Fatalf("Input(%d) unexpected error; %s", tt.sigNo, err)
+		}
+		sig, _ := i.Control("Gain")
+		if got, want := sig.Value(), tt.want; got != want {
+			t.Errorf("input %d gain = %v, want %v", tt.sigNo, got, want)
+		}
+	}
+	if _, err := v.Input(numInputs + 1); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("Input(%d) error = %v, want %s", numInputs+1, err, codes.NotFound)
+	}
 }
@@ -0,0 +1,502 @@
+package venue
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/api/vnc/vnctest"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router"
+	"github.com/kward/venue/internal/router/actions"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+	"github.com/kward/venue/venue/switches"
+)
+
+var (
+	e2eBackground = color.RGBA{40, 40, 40, 255}
+	e2eLit        = color.RGBA{250, 210, 30, 255}
+	e2eUnlit      = color.RGBA{70, 70, 70, 255}
+)
+
+// newConsole returns a fake VENUE console serving the page tabs, the selected
+// channel and the input mute switch on a screen of the given size.
+func newConsole(t *testing.T, size image.Point) (*vnctest.Server, *vnctest.Tabs, *vnctest.Toggle) {
+	t.Helper()
+	s, err := vnctest.NewServer(
+		vnctest.Password("venue"),
+		vnctest.Size(size.X, size.Y),
+		vnctest.Background(e2eBackground))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	tf := screenTransform(size)
+	tabs := &vnctest.Tabs{
+		Tabs: []vnctest.Tab{
+			{Rect: tf.Rect(pageTabs[pages.Inputs]), Key: keys.F1},
+			{Rect: tf.Rect(pageTabs[pages.Outputs]), Key: keys.F2},
+		},
+		Off: e2eUnlit, On: e2eLit, Selected: -1,
+	}
+	s.Add(tabs)
+	s.Add(&vnctest.Channel{Rect: tf.Rect(channelName), Off: e2eBackground, On: e2eLit, Digits: 2})
+	mute := NewToggle(62, 451, switches.Large, switches.Disabled)
+	toggle := &vnctest.Toggle{Rect: tf.Rect(mute.bounds()), Off: e2eUnlit, On: e2eLit}
+	s.Add(toggle)
+	return s, tabs, toggle
+}
+
+func TestEndToEndInputMute(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	for _, size := range []image.Point{{1024, 768}, {1280, 1024}} {
+		t.Run(fmt.Sprintf("%dx%d", size.X, size.Y), func(t *testing.T) {
+			testEndToEndInputMute(t, size)
+		})
+	}
+}
+
+func testEndToEndInputMute(t *testing.T, size image.Point) {
+	s, tabs, mute := newConsole(t, size)
+	defer s.Close()
+
+	v, err := New(Refresh(20 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	if err := v.Initialize(); err != nil {
+		t.Fatalf("unexpected error initializing; %s", err)
+	}
+	if o, err := v.Output(signals.Aux, 1); err != nil || !o.Soloed() {
+		t.Errorf("Aux 1 output not soloed after initializing; %v", err)
+	}
+	if len(v.client().Regions()) == 0 {
+		t.Error("no regions of interest registered after initializing")
+	}
+
+	lit := false
+	outputs := screenTransform(size).Rect(pageTabs[pages.Outputs]).Min.Add(image.Point{2, 2})
+	for _, tt := range []struct {
+		desc    string
+		on      bool
+		outputs bool // Display the OUTPUTS page beforehand.
+	}{
+		{"mute", true, false},
+		{"mute again", true, false},
+		{"unmute", false, false},
+		{"mute from the outputs page", true, true},
+	} {
+		// Wait for the client to see the console state of the previous step.
+		waitForPixel(t, v, mute.Rect.Min.Add(image.Point{4, 4}), lit)
+		if tt.outputs {
+			s.Do(func() { tabs.Selected = 1 })
+			waitForPixel(t, v, outputs, true)
+		}
+		if err := v.handle(&router.Packet{Action: actions.InputMute, Value: tt.on}); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		got := waitForState(s, mute, tt.on)
+		if want := tt.on; got != want {
+			t.Errorf("%s: mute state = %t, want %t; %v", tt.desc, got, want, s.Events())
+		}
+		if tt.outputs {
+			var selected int
+			if s.Do(func() { selected = tabs.Selected }); selected != 0 {
+				t.Errorf("%s: selected tab = %d, want 0", tt.desc, selected)
+			}
+		}
+		if i, err := v.Input(1); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		} else if sig, _ := i.Control("Mute"); sig.Enabled() != tt.on || sig.Estimated() {
+			t.Errorf("%s: modelled mute = %t (estimated %t), want %t", tt.desc, sig.Enabled(), sig.Estimated(), tt.on)
+		}
+		lit = got
+	}
+
+	// Only the regions of the displayed page are refreshed.
+	tf := screenTransform(size)
+	meter := tf.Rect(image.Rect(8, 512, 21, 562)) // Aux 1 Meter.
+	if !regionsHold(v, tf.Rect(channelName)) || regionsHold(v, meter) {
+		t.Errorf("INPUTS page regions = %v", v.client().Regions())
+	}
+	wf := v.newWorkflow()
+	if _, err := v.currentUI().selectPage(wf, pages.Outputs); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		t.Fatalf("unexpected error selecting the OUTPUTS page; %s", err)
+	}
+	if regionsHold(v, tf.Rect(channelName)) || !regionsHold(v, meter) {
+		t.Errorf("OUTPUTS page regions = %v", v.client().Regions())
+	}
+}
+
+// regionsHold returns true if a region of interest of v holds rectangle r.
+func regionsHold(v *Venue, r image.Rectangle) bool {
+	for _, rr := range v.client().Regions() {
+		if r.In(rr.Rect) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEndToEndOutputMute(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	s, tabs, _ := newConsole(t, screenSize)
+	defer s.Close()
+	tf := screenTransform(screenSize)
+	mute := &vnctest.Toggle{Rect: tf.Rect(NewToggle(8, 588, switches.Tiny, false).bounds()), Off: e2eUnlit, On: e2eLit} // Aux 1 Mute.
+	s.Add(mute)
+
+	v, err := New(Refresh(20 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	if err := v.Initialize(); err != nil {
+		t.Fatalf("unexpected error initializing; %s", err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		on   bool
+	}{
+		{"mute", true},
+		{"unmute", false},
+	} {
+		if err := v.handle(&router.Packet{Action: actions.OutputMute, Signal: signals.Aux, SignalNo: 1, Value: tt.on}); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if got, want := waitForState(s, mute, tt.on), tt.on; got != want {
+			t.Errorf("%s: mute state = %t, want %t; %v", tt.desc, got, want, s.Events())
+		}
+		var selected int
+		if s.Do(func() { selected = tabs.Selected }); selected != 1 {
+			t.Errorf("%s: selected tab = %d, want 1", tt.desc, selected)
+		}
+		if o, err := v.Output(signals.Aux, 1); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		} else if got, want := o.Muted(), tt.on; got != want {
+			t.Errorf("%s: modelled mute = %t, want %t", tt.desc, got, want)
+		}
+		// Wait for the client to see the console state before the next step.
+		waitForPixel(t, v, mute.Rect.Min.Add(image.Point{2, 2}), tt.on)
+	}
+}
+
+func TestEndToEndPreempt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	s, _, mute := newConsole(t, screenSize)
+	defer s.Close()
+
+	v, err := New(Refresh(20 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	if err := v.Initialize(); err != nil {
+		t.Fatalf("unexpected error initializing; %s", err)
+	}
+
+	// Reselecting the selected input sleeps until VENUE is done typing.
+	s.ClearEvents()
+	selected := make(chan error, 1)
+	go func() {
+		selected <- v.handle(&router.Packet{Action: actions.SelectInput, SignalNo: 1})
+	}()
+	for typed := false; !typed; {
+		events, err := s.WaitForEvents(len(s.Events())+1, 5*time.Second)
+		if err != nil {
+			t.Fatalf("input never selected; %s", err)
+		}
+		e := events[len(events)-1]
+		typed = e.Type == messages.KeyEvent && e.Key == keys.Digit1 && !e.Down
+	}
+
+	if err := v.handle(&router.Packet{Action: actions.InputMute, Value: true}); err != nil {
+		t.Fatalf("unexpected error muting; %s", err)
+	}
+	select {
+	case err := <-selected:
+		t.Fatalf("input selected before muting; %v", err)
+	default:
+	}
+	if got := waitForState(s, mute, true); !got {
+		t.Errorf("mute state = %t, want true; %v", got, s.Events())
+	}
+	if err := <-selected; err != nil {
+		t.Errorf("unexpected error selecting the input; %s", err)
+	}
+}
+
+func TestEndToEndCalibrate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	s, _, mute := newConsole(t, screenSize)
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "layout.json")
+	layout := `{"version": 1, "pages": [{"page": "Inputs", "widgets": [
+	  {"name": "Mute", "type": "Toggle", "x": 62, "y": 451, "size": "Large"},
+	  {"name": "Pad", "type": "Toggle", "x": 153, "y": 196, "size": "Medium"}]}]}`
+	if err := os.WriteFile(path, []byte(layout), 0644); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	v, err := New(Refresh(20*time.Millisecond), LayoutFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	waitForPixel(t, v, mute.Rect.Min.Add(image.Point{4, 4}), false)
+
+	var imgs []pages.Page
+	cs, err := v.Calibrate(ctx, true, func(p pages.Page, img image.Image) error {
+		imgs = append(imgs, p)
+		if got, want := img.At(78, 460), color.Color(markClick); got != want {
+			t.Errorf("%s: Mute click point = %v, want %v", p, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := imgs, []pages.Page{pages.Inputs}; !reflect.DeepEqual(got, want) {
+		t.Errorf("images = %v, want %v", got, want)
+	}
+	want := []Calibration{
+		{pages.Inputs, "Mute", true},
+		{pages.Inputs, "Pad", false}, // Not served by the console.
+	}
+	if got := cs; !reflect.DeepEqual(got, want) {
+		t.Errorf("calibrations = %v, want %v", got, want)
+	}
+	if got := waitForState(s, mute, false); got {
+		t.Errorf("mute was not restored")
+	}
+}
+
+func TestEndToEndRecall(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	s, _, mute := newConsole(t, screenSize)
+	defer s.Close()
+
+	v, err := New(Refresh(20 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	if err := v.Initialize(); err != nil {
+		t.Fatalf("unexpected error initializing; %s", err)
+	}
+
+	ups := func() int {
+		n := 0
+		for _, e := range s.Events() {
+			if e.Type == messages.KeyEvent && e.Key == keys.Up && e.Down {
+				n++
+			}
+		}
+		return n
+	}
+	before := ups()
+	var ps []RecallProgress
+	fs, err := v.Recall(ctx, &State{
+		Version: stateVersion,
+		Inputs: []InputState{{
+			Input: 2,
+			Prop: map[string]SignalState{
+				"Gain": {Value: 12, Enabled: true},
+				"Mute": {Enabled: true},
+			},
+			Sends: map[string]SignalState{"Aux 2": {Value: -10, Enabled: true}},
+		}},
+	}, func(p RecallProgress) { ps = append(ps, p) })
+	if err != nil {
+		t.Fatalf("unexpected error recalling; %s", err)
+	}
+	if len(fs) != 1 || fs[0].Control != "Aux 2" {
+		t.Errorf("failures = %v, want only Aux 2", fs)
+	}
+	if got, want := ps, []RecallProgress{{2, 1, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("progress = %v, want %v", got, want)
+	}
+	if got := waitForState(s, mute, true); !got {
+		t.Errorf("mute was not recalled; %v", s.Events())
+	}
+	if got, want := ups()-before, 4; got != want {
+		t.Errorf("gain adjusted by %d steps, want %d", got, want)
+	}
+	i, err := v.Input(2)
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if sig, _ := i.Control("Gain"); sig.Value() != 12 {
+		t.Errorf("modelled gain = %v, want 12", sig.Value())
+	}
+	if sig, _ := i.Control("Mute"); !sig.Enabled() || sig.Estimated() {
+		t.Errorf("modelled mute = %t (estimated %t), want true", sig.Enabled(), sig.Estimated())
+	}
+	if got, want := v.SelectedInput(), signals.SignalNo(2); got != want {
+		t.Errorf("selected input = %d, want %d", got, want)
+	}
+}
+
+func TestEndToEndReconnect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	s, _, _ := newConsole(t, screenSize)
+	defer s.Close()
+
+	v, err := New(Refresh(20*time.Millisecond), Reconnect(10*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := v.Connect(ctx, s.Host(), s.Port(), "venue"); err != nil {
+		t.Fatalf("unexpected error connecting; %s", err)
+	}
+	defer v.Close()
+	go v.ListenAndHandleCtx(ctx)
+	if err := v.Initialize(); err != nil {
+		t.Fatalf("unexpected error initializing; %s", err)
+	}
+
+	// The model is kept as the connection is reestablished.
+	if err := v.LoadState(strings.NewReader(`{"version": 1, "inputs": [{"input": 3, "prop": {"Gain": {"value": 40, "enabled": true}}}]}`)); err != nil {
+		t.Fatalf("unexpected error loading state; %s", err)
+	}
+
+	// Keep handling requests while the connection is reestablished.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			v.handle(&router.Packet{Action: actions.InputMute, Value: true})
+		}
+	}()
+
+	old := v.client()
+	s.Disconnect()
+	for deadline := time.Now().Add(15 * time.Second); v.client() == old || !v.Connected(); {
+		if time.Now().After(deadline) {
+			t.Fatal("connection never reestablished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	i, err := v.Input(3)
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if sig, _ := i.Control("Gain"); sig.Value() != 40 {
+		t.Errorf("input 3 gain = %v after reconnecting, want 40", sig.Value())
+	}
+}
+
+func TestEndToEndUnsupportedResolution(t *testing.T) {
+	s, err := vnctest.NewServer(vnctest.Size(800, 600))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer s.Close()
+
+	v, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer v.exec.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = v.Connect(ctx, s.Host(), s.Port(), "")
+	if got, want := venuelib.Code(err), codes.FailedPrecondition; got != want {
+		t.Errorf("Connect() error code = %s, want %s; %v", got, want, err)
+	}
+}
+
+// waitForState waits for the console to process the click on toggle w, and
+// returns its state.
+func waitForState(s *vnctest.Server, w *vnctest.Toggle, want bool) bool {
+	var got bool
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if s.Do(func() { got = w.State }); got == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return got
+}
+
+// waitForPixel waits for the client framebuffer pixel at p to be lit (or
+// unlit), so that the workflows act on the current console state.
+func waitForPixel(t *testing.T, v *Venue, p image.Point, lit bool) {
+	t.Helper()
+	want := e2eUnlit
+	if lit {
+		want = e2eLit
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if v.client().Framebuffer().RGBAAt(p.X, p.Y) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pixel %v never became %v", p, want)
+}
@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"image"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/kward/go-vnc/buttons"
 	"github.com/kward/go-vnc/keys"
 	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/codes"
 	"github.com/kward/venue/internal/router"
 	"github.com/kward/venue/internal/router/actions"
@@ -22,14 +23,24 @@ import (
 const (
 	refresh   = 1000 * time.Millisecond
 	numInputs = 48
-	// Maximum number of consecutive arrow key presses.
+	// Maximum number of consecutive arrow key presses. Queued encoder
+	// adjustments are coalesced up to this many presses, i.e. four presses of
+	// the outer TouchOSC gain pads, which step by 5.
 	// WiFi connections have low enough latency to not need more.
-	maxArrowKeys = 4
+	maxArrowKeys = 20
 	// Maximum number of signal inputs the code can handle.
 	maxInputs = 96
-	// The amount of time to delay after a keyboard input was made. It takes this
-	// long for the VENUE UI to stop waiting for additional input.
+	// The time VENUE waits for additional digits of a typed channel number
+	// before selecting the channel.
 	inputWait = 1750 * time.Millisecond
+	// The time allowed beyond a framebuffer refresh for VENUE to reflect a change.
+	verifyWait = 500 * time.Millisecond
+	// Meter refresh period, should the framebuffer refresh be slower.
+	meterRefresh = 250 * time.Millisecond
+	// Reconnect backoff delays, and the time allowed for each VNC connect.
+	minBackoff     = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+	connectTimeout = 15 * time.Second
 )
 
 // Endpoint handlers.
@@ -48,6 +59,7 @@ func init() {
 		{Action: actions.InputSolo, Handler: InputSolo},
 		{Action: actions.SelectOutput, Handler: SelectOutput},
 		{Action: actions.OutputLevel, Handler: OutputLevel},
+		{Action: actions.OutputMute, Handler: OutputMute},
 	}
 	handlers = make(router.Handlers, len(specs))
 	for _, spec := range specs {
@@ -59,12 +71,21 @@ func init() {
 type Venue struct {
 	opts *options
 
-	vnc *vnc.VNC
+	host   string // VENUE VNC host, kept for reconnects.
+	port   uint   // VENUE VNC port, kept for reconnects.
+	passwd string // VENUE VNC password, kept for reconnects.
 
-	ui       *UI
-	currPage pages.Page
-	inputs   [numInputs]*Input
-	outputs  map[string]*Output
+	mu        sync.Mutex // Protects vnc, connected and ui.
+	vnc       *vnc.VNC
+	connected bool
+	ui        *UI
+
+	exec *vnc.Executor // Serializes the workflows of concurrent clients.
+
+	model   sync.Mutex       // Protects input, inputs and outputs.
+	input   signals.SignalNo // Selected input; zero when unknown.
+	inputs  [numInputs]*Input
+	outputs Outputs
 }
 
 // Verify that the expected interface is implemented properly.
@@ -75,52 +96,157 @@ func New(opts ...func(*options) error) (*Venue, error) {
 	o := &options{}
 	o.setInputs(numInputs)
 	o.setRefresh(refresh)
+	o.setReconnect(minBackoff, maxBackoff)
+	o.setLayout(DefaultLayout())
+	o.setBuses(buses.Default())
 	for _, opt := range opts {
 		if err := opt(o); err != nil {
 			return nil, err
 		}
 	}
-	return &Venue{opts: o}, nil
+	exec, err := vnc.NewExecutor(vnc.Coalesce(maxArrowKeys))
+	if err != nil {
+		return nil, err
+	}
+	v := &Venue{opts: o, exec: exec}
+	if v.ui, err = v.newUI(); err != nil {
+		exec.Close()
+		return nil, err
+	}
+	v.resetModel()
+	return v, nil
+}
+
+// newUI returns a UI built from the layout and bus configuration options, which
+// verifies its page changes.
+func (v *Venue) newUI() (*UI, error) {
+	ui, err := NewUI(v.opts.layout, v.opts.buses)
+	if err != nil {
+		return nil, err
+	}
+	ui.verify = v.verifyTimeout()
+	ui.setGlyphs(v.opts.glyphs)
+	ui.onPage = v.pageSelected
+	return ui, nil
 }
 
-// Close a Venue session.
+// Close a Venue session. Workflows still executing are interrupted.
 func (v *Venue) Close() error {
-	return v.vnc.Close()
+	v.exec.Close()
+	if handle := v.client(); handle != nil {
+		return handle.Close()
+	}
+	return nil
 }
 
-// Connect to a VENUE VNC server.
+// Connect to a VENUE VNC server. The connection details are retained, so that
+// the connection can be reestablished should it drop.
 func (v *Venue) Connect(ctx context.Context, h string, p uint, pw string) error {
 	if glog.V(3) {
 		glog.Infof("Venue.%s", venuelib.FnName())
 	}
-	// Establish a connection to the VENUE VNC server.
-	handle, err := vnc.New(vnc.Host(h), vnc.Port(p), vnc.Password(pw))
+	v.host, v.port, v.passwd = h, p, pw
+	handle, err := v.dial(ctx)
 	if err != nil {
 		return err
 	}
+	v.setClient(handle, true)
+	return nil
+}
+
+// dial establishes a connection to the VENUE VNC server.
+func (v *Venue) dial(ctx context.Context) (*vnc.VNC, error) {
+	handle, err := vnc.New(vnc.Host(v.host), vnc.Port(v.port), vnc.Password(v.passwd))
+	if err != nil {
+		return nil, err
+	}
 	if err := handle.Connect(ctx); err != nil {
-		return err
+		return nil, err
 	}
-	v.vnc = handle
-	return nil
+	if err := checkScreen(handle.Framebuffer().Bounds().Size()); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return handle, nil
+}
+
+// client returns the current VNC connection.
+func (v *Venue) client() *vnc.VNC {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.vnc
+}
+
+// setClient sets the current VNC connection, and whether it is connected.
+func (v *Venue) setClient(handle *vnc.VNC, connected bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.vnc, v.connected = handle, connected
+}
+
+// currentUI returns the UI of the VENUE console.
+func (v *Venue) currentUI() *UI {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.ui
+}
+
+// setUI sets the UI of the VENUE console.
+func (v *Venue) setUI(ui *UI) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.ui = ui
+}
+
+// Connected returns true if the VENUE VNC connection is up.
+func (v *Venue) Connected() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.connected
+}
+
+func (v *Venue) setConnected(connected bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.connected = connected
 }
 
-// Initialize the in-memory state representation of a VENUE console.
+// Initialize brings VENUE into a known state, and confirms the model of the
+// selected input against it. It is called again as the connection is
+// reestablished, so the rest of the model is left as it is.
 func (v *Venue) Initialize() error {
 	if glog.V(3) {
 		glog.Infof("Venue.%s", venuelib.FnName())
 	}
 
-	v.ui = NewUI()
+	// Nothing can be read from VENUE until its screen was received.
+	wf := v.newWorkflow()
+	wf.WaitFor("the VENUE screen", image.Rectangle{Max: screenSize}, func(s *vnc.Snapshot) bool {
+		return s.Version > 0
+	}, connectTimeout)
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		return err
+	}
 
-	// Initialize inputs.
-	if glog.V(2) {
-		glog.Info("Initializing inputs.")
+	// The UI is only published once its widgets are in place, as the handlers
+	// of other clients use it concurrently.
+	ui, err := v.newUI()
+	if err != nil {
+		return err
 	}
-	for sigNo := 0; sigNo < numInputs; sigNo++ {
-		input := NewInput(signals.Input, signals.SignalNo(sigNo+1))
-		v.inputs[sigNo] = input
+	if len(v.opts.templates) > 0 {
+		if glog.V(2) {
+			glog.Info("Locating widgets.")
+		}
+		if _, err := v.locateWidgets(context.Background(), ui); err != nil {
+			return err
+		}
 	}
+	v.setUI(ui)
+
+	// The selected input is unknown until selected, as the console may have
+	// changed while disconnected.
+	v.selectInput(0)
 
 	// Choose output before input so that later when the Inputs page is selected,
 	// it shows first bank of channels.
@@ -128,18 +254,20 @@ func (v *Venue) Initialize() error {
 	if glog.V(2) {
 		glog.Info("Selecting I/O.")
 	}
-	if err := SelectOutput(v, &router.Packet{Signal: signals.Aux, SignalNo: 1}); err != nil {
-		return err
+	if ss := v.opts.buses.Sends(); len(ss) > 0 {
+		if err := SelectOutput(v, &router.Packet{Signal: ss[0].Signal, SignalNo: ss[0].SignalNo}); err != nil {
+			return err
+		}
 	}
 	if err := SelectInput(v, &router.Packet{SignalNo: 1}); err != nil {
 		return err
 	}
 
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	wf = v.newWorkflow()
 	if glog.V(2) {
 		glog.Infof("Clearing input solo.")
 	}
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	p, err := v.currentUI().selectPage(wf, pages.Inputs)
 	if err != nil {
 		return err
 	}
@@ -150,18 +278,129 @@ func (v *Venue) Initialize() error {
 	if err := w.Press(wf); err != nil {
 		return err
 	}
-	return wf.Execute()
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		return err
+	}
+
+	if err := v.ReadInput(context.Background()); err != nil {
+		glog.Errorf("Unable to read input %d; %s", v.SelectedInput(), err)
+	}
+
+	// The widgets are in place, so only their regions need refreshing.
+	return v.watchRegions()
+}
+
+// watchRegions restricts the framebuffer refresh of the VENUE VNC connection to
+// the regions read from the UI. It is called on every (re)initialization, as a
+// new connection refreshes the full screen, and whenever the selected page
+// changes, as only the regions of the displayed page are read.
+func (v *Venue) watchRegions() error {
+	if v.opts.refresh <= 0 {
+		return nil // Refreshed once; there is no refresh to restrict.
+	}
+	handle := v.client()
+	if handle == nil {
+		return venuelib.Errorf(codes.FailedPrecondition, "not connected")
+	}
+	fb := handle.Framebuffer()
+	if fb == nil {
+		return venuelib.Errorf(codes.FailedPrecondition, "not connected")
+	}
+	return handle.SetRegions(v.currentUI().regions(screenTransform(fb.Bounds().Size()), v.opts.refresh, meterRefresh)...)
+}
+
+// pageSelected watches the regions of page p, once selected. Until the
+// connection is initialized, it refreshes the full screen.
+func (v *Venue) pageSelected(p pages.Page) {
+	if handle := v.client(); handle == nil || len(handle.Regions()) == 0 {
+		return
+	}
+	if err := v.watchRegions(); err != nil {
+		glog.Errorf("Unable to watch the %s page regions; %s", p, err)
+	}
 }
 
 // ListenAndHandle connections and incoming requests.
 // ListenAndHandle maintains backward compatibility; prefer ListenAndHandleCtx.
 func (v *Venue) ListenAndHandle() { v.ListenAndHandleCtx(context.Background()) }
 
-// ListenAndHandleCtx starts goroutines to listen for messages and refresh the
-// framebuffer until the context is cancelled.
+// ListenAndHandleCtx listens for VNC messages and refreshes the framebuffer
+// until the context is cancelled. Should the VNC connection drop, it is
+// reestablished with exponential backoff, and the console state is
+// reinitialized. Packets received while disconnected are rejected.
 func (v *Venue) ListenAndHandleCtx(ctx context.Context) {
-	go v.vnc.ListenAndHandleCtx(ctx)
-	go v.vnc.FramebufferRefreshCtx(ctx, v.opts.refresh)
+	resync := false
+	for {
+		handle := v.client()
+		connCtx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		go func() { errCh <- handle.ListenAndHandleCtx(connCtx) }()
+		go handle.FramebufferRefreshCtx(connCtx, v.opts.refresh)
+
+		if resync {
+			if err := v.Initialize(); err != nil {
+				glog.Errorf("Unable to reinitialize VENUE; %s", err)
+				cancel()
+			} else {
+				v.setConnected(true)
+				glog.Info("VENUE connection reestablished.")
+			}
+		}
+
+		err := <-errCh
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		v.setConnected(false)
+		glog.Errorf("VENUE connection lost; %v", err)
+		if err := v.reconnect(ctx); err != nil {
+			if glog.V(2) {
+				glog.Infof("Venue.ListenAndHandleCtx stopping: %v", err)
+			}
+			return
+		}
+		resync = true
+	}
+}
+
+// reconnect reestablishes the VENUE VNC connection, doubling the delay
+// between attempts up to the maximum backoff. It only returns an error when
+// the context is cancelled.
+func (v *Venue) reconnect(ctx context.Context) error {
+	// Release the lost connection before replacing it.
+	if err := v.client().Close(); err != nil && glog.V(2) {
+		glog.Infof("Unable to close the lost VENUE connection; %s", err)
+	}
+	backoff := v.opts.minBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if glog.V(2) {
+			glog.Infof("Reconnecting to %s:%d.", v.host, v.port)
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		handle, err := v.dial(dialCtx)
+		cancel()
+		if err == nil {
+			v.setClient(handle, false)
+			return nil
+		}
+		glog.Errorf("Unable to reconnect to VENUE; %s", err)
+		backoff = nextBackoff(backoff, v.opts.maxBackoff)
+	}
+}
+
+// nextBackoff returns the delay following `d`, capped at `max`.
+func nextBackoff(d, max time.Duration) time.Duration {
+	if d *= 2; d > max {
+		return max
+	}
+	return d
 }
 
 // EndpointName implements router.Endpoint.
@@ -172,11 +411,19 @@ func (v *Venue) Handle(pkt *router.Packet) {
 	if glog.V(3) {
 		glog.Infof("Venue.%s", venuelib.FnName())
 	}
-	if err := router.Handle(v, pkt, handlers); err != nil {
+	if err := v.handle(pkt); err != nil {
 		glog.Errorf("Error handling %s packet; %s", pkt.Action, err)
 	}
 }
 
+// handle routes a packet to its handler, provided VENUE is connected.
+func (v *Venue) handle(pkt *router.Packet) error {
+	if !v.Connected() {
+		return venuelib.Errorf(codes.Unavailable, "VENUE is disconnected")
+	}
+	return router.Handle(v, pkt, handlers)
+}
+
 //-----------------------------------------------------------------------------
 // router.Handler functions
 
@@ -188,7 +435,7 @@ func Ping(ep router.Endpoint, _ *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
-	ep.(*Venue).vnc.DebugMetrics()
+	ep.(*Venue).client().DebugMetrics()
 	return nil
 }
 
@@ -206,32 +453,55 @@ func SelectInput(ep router.Endpoint, pkt *router.Packet) error {
 	}
 
 	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	wf := v.newWorkflow()
+	if err := v.selectInputEvents(wf, pkt.SignalNo); err != nil {
+		return err
+	}
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		v.selectInput(0) // The selected input is no longer known.
+		return err
+	}
+	v.selectInput(pkt.SignalNo)
+	return nil
+}
 
+// selectInputEvents adds the events selecting input `sigNo` to workflow wf.
+// The workflow completes once VENUE has selected the input.
+func (v *Venue) selectInputEvents(wf *vnc.Workflow, sigNo signals.SignalNo) error {
 	// Select the INPUTS page.
-	_, err := v.ui.selectPage(wf, pages.Inputs)
+	p, err := v.currentUI().selectPage(wf, pages.Inputs)
 	if err != nil {
 		return err
 	}
 
 	// Select channels 1-48.
-	// TODO(kward:20170226) Handle this with UI element.
-	wf.MouseClick(buttons.Left, image.Point{932, 524})
+	w, err := p.Widget("ChannelRange")
+	if err != nil {
+		return err
+	}
+	if err := w.Press(wf); err != nil {
+		return err
+	}
 
 	// Type the channel number.
 	ks := keys.Keys{}
-	if pkt.SignalNo < 10 {
+	if sigNo < 10 {
 		ks = append(ks, keys.Digit0)
 	}
-	ks = append(ks, keys.IntToKeys(int(pkt.SignalNo))...)
+	ks = append(ks, keys.IntToKeys(int(sigNo))...)
 	for _, k := range ks {
 		wf.KeyPress(k)
 	}
-	// TODO(kward:20161126): Start a timer that expires after 1750ms. Additional
-	// key presses aren't allowed until the time expires, but mouse input is.
-	wf.Sleep(inputWait)
-
-	return wf.Execute()
+	// Additional key presses aren't allowed until VENUE selects the channel, so
+	// wait for the channel name to change. Should the selected input be
+	// unknown, or already be this one, the name need not change, so the
+	// typing can only be waited out.
+	if curr := v.SelectedInput(); curr == 0 || curr == sigNo {
+		wf.Sleep(inputWait)
+	} else {
+		wf.WaitForRegionChange(channelName, inputWait+v.verifyTimeout())
+	}
+	return nil
 }
 
 // InputGain adjustment.
@@ -240,14 +510,14 @@ func InputGain(ep router.Endpoint, pkt *router.Packet) error {
 		glog.Info(venuelib.FnName())
 	}
 	if glog.V(2) {
-		glog.Infof("Adjusting input gain by %d dB.", pkt.Value)
+		glog.Infof("Adjusting input gain by %d steps.", pkt.Value)
 	}
 
 	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	wf := v.newWorkflow()
 
 	// Select the INPUTS page.
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	p, err := v.currentUI().selectPage(wf, pages.Inputs)
 	if err != nil {
 		return err
 	}
@@ -259,7 +529,11 @@ func InputGain(ep router.Endpoint, pkt *router.Packet) error {
 		return err
 	}
 
-	return wf.Execute()
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		return err
+	}
+	v.updateInput("Gain", func(sig *Signal) { sig.Step(pkt.Value.(int)) })
+	return nil
 }
 
 // InputGuess button push.
@@ -274,9 +548,9 @@ func InputGuess(ep router.Endpoint, pkt *router.Packet) error {
 	// TODO(kward:20170209) Guess needs both press/release support as the user
 	// will hold the button for some amount of time, O(seconds).
 	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	wf := v.newWorkflow()
 
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	p, err := v.currentUI().selectPage(wf, pages.Inputs)
 	if err != nil {
 		return err
 	}
@@ -284,99 +558,71 @@ func InputGuess(ep router.Endpoint, pkt *router.Packet) error {
 		return err
 	}
 
-	return wf.Execute()
+	return v.execute(wf, vnc.Normal)
 }
 
-// InputMute button push.
+// InputMute sets the state of the input mute button.
 func InputMute(ep router.Endpoint, pkt *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
-	if glog.V(2) {
-		glog.Info("Toggle the input mute.")
-	}
-
-	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
-
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	on, err := toggleValue(pkt)
 	if err != nil {
 		return err
 	}
-	if err := pressWidget(wf, p, "Mute"); err != nil {
-		return err
+	if glog.V(2) {
+		glog.Infof("Setting the input mute to %t.", on)
 	}
 
-	return wf.Execute()
+	return setInputSwitch(ep.(*Venue), "Mute", on, vnc.High)
 }
 
-// InputPad toggles the state of the input pad button.
+// InputPad sets the state of the input pad button.
 func InputPad(ep router.Endpoint, pkt *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
-	if glog.V(2) {
-		glog.Info("Toggle the input pad.")
-	}
-
-	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
-
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	on, err := toggleValue(pkt)
 	if err != nil {
 		return err
 	}
-	if err := pressWidget(wf, p, "Pad"); err != nil {
-		return err
+	if glog.V(2) {
+		glog.Infof("Setting the input pad to %t.", on)
 	}
 
-	return wf.Execute()
+	return setInputSwitch(ep.(*Venue), "Pad", on, vnc.Normal)
 }
 
-// InputPhantom toggles the state of the input phantom button.
+// InputPhantom sets the state of the input phantom button.
 func InputPhantom(ep router.Endpoint, pkt *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
-	if glog.V(2) {
-		glog.Info("Toggle the input phantom.")
-	}
-
-	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
-
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	on, err := toggleValue(pkt)
 	if err != nil {
 		return err
 	}
-	if err := pressWidget(wf, p, "Phantom"); err != nil {
-		return err
+	if glog.V(2) {
+		glog.Infof("Setting the input phantom to %t.", on)
 	}
 
-	return wf.Execute()
+	return setInputSwitch(ep.(*Venue), "Phantom", on, vnc.Normal)
 }
 
-// InputSolo toggles the state of the input solo button.
+// InputSolo sets the state of the input solo button.
 func InputSolo(ep router.Endpoint, pkt *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
 	}
-	if glog.V(2) {
-		glog.Info("Toggle the input solo.")
-	}
-
-	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
-
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	on, err := toggleValue(pkt)
 	if err != nil {
 		return err
 	}
-	if err := pressWidget(wf, p, "Solo"); err != nil {
-		return err
+	if glog.V(2) {
+		glog.Infof("Setting the input solo to %t.", on)
 	}
 
-	return wf.Execute()
+	return setInputSwitch(ep.(*Venue), "Solo", on, vnc.High)
 }
 
 // SelectOutput for adjustment.
@@ -389,11 +635,14 @@ func SelectOutput(ep router.Endpoint, pkt *router.Packet) error {
 	}
 
 	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	wf := v.newWorkflow()
 	if err := selectOutput(v, wf, pkt); err != nil {
 		return err
 	}
-	return wf.Execute()
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		return err
+	}
+	return v.soloOutput(pkt.Signal, pkt.SignalNo)
 }
 
 func selectOutput(v *Venue, wf *vnc.Workflow, pkt *router.Packet) error {
@@ -401,26 +650,31 @@ func selectOutput(v *Venue, wf *vnc.Workflow, pkt *router.Packet) error {
 		glog.Info(venuelib.FnName())
 	}
 
-	ctrlName := signalControlName(pkt.Signal, pkt.SignalNo)
-	if ctrlName == "Invalid" {
-		return venuelib.Errorf(codes.Internal, "invalid control name for %s %d signal combination", pkt.Signal, pkt.SignalNo)
+	ctrlName, err := signalControlName(v.opts.buses, pkt.Signal, pkt.SignalNo)
+	if err != nil {
+		return err
 	}
 
 	// Select the OUTPUTS page.
-	p, err := v.ui.selectPage(wf, pages.Outputs)
+	p, err := v.currentUI().selectPage(wf, pages.Outputs)
 	if err != nil {
 		return err
 	}
 
 	// Select OUTPUTS tab (i.e. not USER).
-	// TODO(kward:20170226) Handle this with UI element.
-	wf.MouseClick(buttons.Left, image.Point{932, 524})
+	w, err := p.Widget("ChannelRange")
+	if err != nil {
+		return err
+	}
+	if err := w.Press(wf); err != nil {
+		return err
+	}
 
 	// Clear the output solo.
 	if glog.V(2) {
 		glog.Infof("Clearing output solo.")
 	}
-	w, err := p.Widget("SoloClear")
+	w, err = p.Widget("SoloClear")
 	if err != nil {
 		return err
 	}
@@ -444,7 +698,7 @@ func selectOutput(v *Venue, wf *vnc.Workflow, pkt *router.Packet) error {
 }
 
 // OutputLevel for the specified output. This handler operates on the
-// currently selected input.
+// currently selected input, adjusting and recording its send to the output.
 func OutputLevel(ep router.Endpoint, pkt *router.Packet) error {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
@@ -453,13 +707,12 @@ func OutputLevel(ep router.Endpoint, pkt *router.Packet) error {
 		glog.Infof("Adjusting %s %d output level by %d dB.", pkt.Signal, pkt.SignalNo, pkt.Value)
 	}
 
-	ctrlName := signalControlName(pkt.Signal, pkt.SignalNo)
-	if ctrlName == "Invalid" {
-		return venuelib.Errorf(codes.InvalidArgument, "invalid control name for %s %d signal combination", pkt.Signal, pkt.SignalNo)
-	}
-
 	v := ep.(*Venue)
-	wf := vnc.NewWorkflow(v.vnc.ClientConn())
+	ctrlName, err := signalControlName(v.opts.buses, pkt.Signal, pkt.SignalNo)
+	if err != nil {
+		return err
+	}
+	wf := v.newWorkflow()
 
 	// Select output. Needed to select correct Aux or VarGroup.
 	if err := selectOutput(v, wf, pkt); err != nil {
@@ -467,7 +720,7 @@ func OutputLevel(ep router.Endpoint, pkt *router.Packet) error {
 	}
 
 	// Select the INPUTS page.
-	p, err := v.ui.selectPage(wf, pages.Inputs)
+	p, err := v.currentUI().selectPage(wf, pages.Inputs)
 	if err != nil {
 		return err
 	}
@@ -481,27 +734,346 @@ func OutputLevel(ep router.Endpoint, pkt *router.Packet) error {
 		return err
 	}
 
-	return wf.Execute()
+	if err := v.execute(wf, vnc.Normal); err != nil {
+		return err
+	}
+	v.updateInput(ctrlName, func(sig *Signal) { sig.Step(pkt.Value.(int)) })
+	return v.soloOutput(pkt.Signal, pkt.SignalNo)
+}
+
+// OutputMute sets the state of the mute button of the specified output.
+func OutputMute(ep router.Endpoint, pkt *router.Packet) error {
+	if glog.V(3) {
+		glog.Info(venuelib.FnName())
+	}
+	on, err := toggleValue(pkt)
+	if err != nil {
+		return err
+	}
+	if glog.V(2) {
+		glog.Infof("Setting the %s %d output mute to %t.", pkt.Signal, pkt.SignalNo, on)
+	}
+
+	v := ep.(*Venue)
+	ctrlName, err := signalControlName(v.opts.buses, pkt.Signal, pkt.SignalNo)
+	if err != nil {
+		return err
+	}
+	if err := setSwitch(v, pages.Outputs, ctrlName+" Mute", on, vnc.High); err != nil {
+		return err
+	}
+	return v.muteOutput(pkt.Signal, pkt.SignalNo, on)
 }
 
 //-----------------------------------------------------------------------------
 // Misc
 
 // signalControlName returns a control name for a `signal` and `signalNo`
-// combination.
-func signalControlName(sig signals.Signal, sigNo signals.SignalNo) string {
+// combination. Aux and group buses are named after their send under bus
+// configuration c, i.e. the first bus of a stereo pair.
+func signalControlName(c buses.Config, sig signals.Signal, sigNo signals.SignalNo) (string, error) {
 	switch sig {
 	case signals.Input, signals.FXReturn:
-		return controls.Fader.String()
-	case signals.Direct:
-		return "Invalid"
-	case signals.Aux:
-		return fmt.Sprintf("%s %d", controls.Aux, sigNo)
-	case signals.Group:
-		return fmt.Sprintf("%s %d", controls.Group, sigNo)
-	default:
-		return controls.Unknown.String()
+		return controls.Fader.String(), nil
+	case signals.Aux, signals.Group, signals.VarGroup:
+		s, err := c.Send(outputSignal(sig), sigNo)
+		if err != nil {
+			return "", venuelib.Errorf(codes.InvalidArgument, "%s", venuelib.ErrorDesc(err))
+		}
+		return outputName(s.Signal, s.SignalNo), nil
+	}
+	return "", venuelib.Errorf(codes.InvalidArgument, "no control for the %s %d signal", sig, sigNo)
+}
+
+// Output returns a copy of the modelled state of output `sig` number `sigNo`.
+// The model is updated as outputs are changed, without reading VENUE.
+func (v *Venue) Output(sig signals.Signal, sigNo signals.SignalNo) (*Output, error) {
+	v.model.Lock()
+	defer v.model.Unlock()
+	o, err := v.outputs.Output(sig, sigNo)
+	if err != nil {
+		return nil, err
+	}
+	return o.clone(), nil
+}
+
+// resetModel resets the inputs and outputs to their defaults.
+func (v *Venue) resetModel() {
+	v.model.Lock()
+	defer v.model.Unlock()
+	v.input = 0
+	for sigNo := 0; sigNo < numInputs; sigNo++ {
+		input := NewInput(signals.Input, signals.SignalNo(sigNo+1), v.opts.buses)
+		v.inputs[sigNo] = input
+	}
+	v.outputs = NewOutputs(v.opts.buses)
+}
+
+// Input returns a copy of the modelled state of input `sigNo`. The model is
+// updated as inputs are changed, and confirmed by reading VENUE.
+func (v *Venue) Input(sigNo signals.SignalNo) (*Input, error) {
+	if sigNo < 1 || sigNo > numInputs {
+		return nil, venuelib.Errorf(codes.NotFound, "input %d is not modelled", sigNo)
+	}
+	v.model.Lock()
+	defer v.model.Unlock()
+	return v.inputs[sigNo-1].clone(), nil
+}
+
+// SelectedInput returns the selected input, or zero when it is unknown.
+func (v *Venue) SelectedInput() signals.SignalNo {
+	v.model.Lock()
+	defer v.model.Unlock()
+	return v.input
+}
+
+// selectInput records that input `sigNo` is selected.
+func (v *Venue) selectInput(sigNo signals.SignalNo) {
+	v.model.Lock()
+	defer v.model.Unlock()
+	v.input = sigNo
+}
+
+// selectedInput returns the model of the selected input, or nil when it is not
+// modelled. The model lock must be held.
+func (v *Venue) selectedInput() *Input {
+	if v.input < 1 || v.input > numInputs {
+		return nil
+	}
+	return v.inputs[v.input-1]
+}
+
+// updateInput applies fn to the named control of the selected input. Controls
+// that are not modelled are ignored.
+func (v *Venue) updateInput(name string, fn func(*Signal)) {
+	v.model.Lock()
+	defer v.model.Unlock()
+	i := v.selectedInput()
+	if i == nil {
+		if glog.V(2) {
+			glog.Infof("Input %d is not modelled; ignoring %s change.", v.input, name)
+		}
+		return
+	}
+	sig, err := i.Control(name)
+	if err != nil {
+		if glog.V(2) {
+			glog.Infof("Ignoring input %d change; %s", v.input, err)
+		}
+		return
+	}
+	fn(sig)
+}
+
+// ReadInput confirms the model of the selected input by reading the controls
+// shown on the INPUTS page. Controls that cannot be read remain estimated.
+func (v *Venue) ReadInput(ctx context.Context) error {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	if _, err := framebuffer(v.newWorkflow()); err != nil {
+		return err
+	}
+	ui := v.currentUI()
+	if err := v.showPage(ctx, ui, pages.Inputs); err != nil {
+		return err
+	}
+	// Give VENUE time to reflect earlier changes.
+	wf := v.newWorkflow()
+	wf.Sleep(v.verifyTimeout())
+	if err := v.executeCtx(ctx, wf, vnc.Normal); err != nil {
+		return err
+	}
+	s, err := framebuffer(v.newWorkflow())
+	if err != nil {
+		return err
+	}
+
+	v.model.Lock()
+	defer v.model.Unlock()
+	i := v.selectedInput()
+	if i == nil {
+		return venuelib.Errorf(codes.FailedPrecondition, "input %d is not modelled", v.input)
+	}
+	i.confirm(ui.pages[pages.Inputs], s)
+	return nil
+}
+
+// soloOutput records that output `sig` number `sigNo` is the soloed output.
+func (v *Venue) soloOutput(sig signals.Signal, sigNo signals.SignalNo) error {
+	sig, sigNo = v.outputBus(sig, sigNo)
+	v.model.Lock()
+	defer v.model.Unlock()
+	return v.outputs.Solo(sig, sigNo)
+}
+
+// muteOutput records the confirmed mute state of output `sig` number `sigNo`.
+func (v *Venue) muteOutput(sig signals.Signal, sigNo signals.SignalNo, on bool) error {
+	sig, sigNo = v.outputBus(sig, sigNo)
+	v.model.Lock()
+	defer v.model.Unlock()
+	o, err := v.outputs.Output(sig, sigNo)
+	if err != nil {
+		return err
+	}
+	o.prop["Mute"].SetEnabled(on)
+	o.prop["Mute"].Confirm()
+	return nil
+}
+
+// outputBus returns the bus modelling output `sig` number `sigNo`. VENUE
+// controls a stereo bus pair through its odd bus, so the even bus of a pair is
+// modelled by it.
+func (v *Venue) outputBus(sig signals.Signal, sigNo signals.SignalNo) (signals.Signal, signals.SignalNo) {
+	sig = outputSignal(sig)
+	if s, err := v.opts.buses.Send(sig, sigNo); err == nil {
+		return s.Signal, s.SignalNo
+	}
+	return sig, sigNo
+}
+
+// newWorkflow returns a new workflow for the VENUE VNC connection.
+func (v *Venue) newWorkflow() *vnc.Workflow {
+	handle := v.client()
+	wf := vnc.NewWorkflow(handle.ClientConn())
+	if fb := handle.Framebuffer(); fb != nil {
+		wf.SetFramebuffer(fb)
+		wf.SetTransform(screenTransform(fb.Bounds().Size()))
+	}
+	wf.SetRecovery(v.recover)
+	return wf
+}
+
+// execute queues workflow wf for execution, and waits for it to complete.
+// Workflows of concurrent clients are executed one at a time. Should the
+// workflow fail, the cached UI state is no longer trusted.
+func (v *Venue) execute(wf *vnc.Workflow, prio vnc.Priority) error {
+	return v.executeCtx(context.Background(), wf, prio)
+}
+
+// executeCtx is execute, abandoning the workflow should ctx be done.
+func (v *Venue) executeCtx(ctx context.Context, wf *vnc.Workflow, prio vnc.Priority) error {
+	err := v.exec.Execute(ctx, wf, prio)
+	if err != nil {
+		v.currentUI().invalidate()
+	}
+	return err
+}
+
+// recover builds the workflow returning VENUE to a known state after a
+// workflow failed partway through. Any focused field is escaped from, and the
+// last known page is reselected. Should the page not be reselectable, the UI
+// is left stale.
+func (v *Venue) recover(r *vnc.Workflow) error {
+	r.KeyPress(keys.Escape)
+	ui := v.currentUI()
+	p, ok := ui.lastPage()
+	if !ok {
+		return nil
+	}
+	w, ok := ui.pages[p]
+	if !ok {
+		ui.invalidate()
+		return venuelib.Errorf(codes.Unimplemented, "support for %q page unimplemented", p)
+	}
+	if err := w.Press(r); err != nil {
+		ui.invalidate()
+		return err
+	}
+	return nil
+}
+
+// setSwitch sets the state of the named toggle switch on page `p`, executing
+// the workflow with priority `prio`.
+//
+// The page is selected, and the switch read, when the workflow runs, so that
+// a queued change acts on the page displayed at the time. The switch is
+// pressed only if its state differs, and the change is confirmed on the
+// framebuffer.
+func setSwitch(v *Venue, p pages.Page, widget string, on bool, prio vnc.Priority) error {
+	ui := v.currentUI()
+	wf := v.newWorkflow()
+	page, err := ui.selectPage(wf, p)
+	if err != nil {
+		return err
+	}
+	w, err := page.Widget(widget)
+	if err != nil {
+		return err
+	}
+	sw, ok := w.(*Switch)
+	if !ok || !sw.IsToggle() {
+		return venuelib.Errorf(codes.InvalidArgument, "%q is not a toggle switch", widget)
+	}
+	timeout := v.verifyTimeout()
+	// Switches are clicked, so no keys are pressed.
+	keyFree := func() bool { return true }
+	wf.ExpandKeyFree(fmt.Sprintf("set the %s/%s switch", p, widget), keyFree, func(x *vnc.Workflow) error {
+		got, err := sw.Read(x)
+		if err != nil {
+			return err
+		}
+		if got.(bool) == on {
+			return nil
+		}
+		if err := sw.Press(x); err != nil {
+			return err
+		}
+		tf := x.Transform()
+		x.WaitFor(fmt.Sprintf("the %s/%s switch", p, widget), sw.bounds(), func(s *vnc.Snapshot) bool {
+			got, err := sw.read(sample(s, tf))
+			return err == nil && got == on
+		}, timeout)
+		return nil
+	})
+	if err := v.execute(wf, prio); err != nil {
+		return err
+	}
+	ui.recordSwitch(sw, on)
+	return nil
+}
+
+// setInputSwitch sets the state of the named switch of the selected input, and
+// records the state, as confirmed on the framebuffer, in the model.
+func setInputSwitch(v *Venue, widget string, on bool, prio vnc.Priority) error {
+	if err := setSwitch(v, pages.Inputs, widget, on, prio); err != nil {
+		return err
+	}
+	v.updateInput(widget, func(sig *Signal) {
+		sig.SetEnabled(on)
+		sig.Confirm()
+	})
+	return nil
+}
+
+// showPage selects page p of UI ui, and gives VENUE time to redraw it, so that
+// the widgets of the page can be read from the framebuffer.
+func (v *Venue) showPage(ctx context.Context, ui *UI, p pages.Page) error {
+	if _, ok := ui.pages[p]; !ok {
+		return venuelib.Errorf(codes.Unimplemented, "support for %q page unimplemented", p)
+	}
+	wf := v.newWorkflow()
+	wf.Expand(fmt.Sprintf("show the %s page", p), func(x *vnc.Workflow) error {
+		changed, err := ui.navigate(x, p)
+		if changed {
+			x.Sleep(v.verifyTimeout())
+		}
+		return err
+	})
+	return v.executeCtx(ctx, wf, vnc.Normal)
+}
+
+// verifyTimeout returns how long to wait for VENUE to reflect a change on the
+// framebuffer. The framebuffer is refreshed only periodically.
+func (v *Venue) verifyTimeout() time.Duration { return v.opts.refresh + verifyWait }
+
+// toggleValue returns the requested state of a toggle packet.
+func toggleValue(pkt *router.Packet) (bool, error) {
+	on, ok := pkt.Value.(bool)
+	if !ok {
+		return false, venuelib.Errorf(codes.InvalidArgument, "invalid toggle value %v", pkt.Value)
 	}
+	return on, nil
 }
 
 func pressWidget(wf *vnc.Workflow, page *Page, widget string) error {
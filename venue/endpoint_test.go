@@ -1,24 +1,237 @@
 package venue
 
 import (
+	"context"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router"
+	"github.com/kward/venue/internal/router/actions"
 	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
 )
 
 func TestSignalControlName(t *testing.T) {
+	mono := buses.Config{Auxes: 8, Groups: 16, StereoGroups: true}
 	for _, tt := range []struct {
+		c     buses.Config
 		sig   signals.Signal
 		sigNo signals.SignalNo
 		name  string
+		code  codes.Code
 	}{
-		{signals.Aux, 1, "Aux 1"},
-		{signals.Group, 2, "Group 2"},
-		{signals.Direct, 3, "Invalid"},
+		{buses.Default(), signals.Input, 3, "Fader", codes.OK},
+		{buses.Default(), signals.Aux, 1, "Aux 1", codes.OK},
+		{buses.Default(), signals.Aux, 2, "Aux 1", codes.OK},
+		{buses.Default(), signals.Group, 8, "Group 7", codes.OK},
+		{buses.Default(), signals.Aux, 17, "", codes.InvalidArgument},
+		{buses.Default(), signals.Group, 9, "", codes.InvalidArgument},
+		{buses.Default(), signals.Direct, 3, "", codes.InvalidArgument},
+		{mono, signals.Aux, 2, "Aux 2", codes.OK},
+		{mono, signals.Aux, 9, "", codes.InvalidArgument},
+		{mono, signals.Group, 16, "Group 15", codes.OK},
+		{buses.Default(), signals.VarGroup, 4, "Group 3", codes.OK},
 	} {
-		if got, want := signalControlName(tt.sig, tt.sigNo), tt.name; got != want {
-			t.Errorf("signalControlName(%s, %d) = %s, want %s", tt.sig, tt.sigNo, got, want)
+		got, err := signalControlName(tt.c, tt.sig, tt.sigNo)
+		if code := venuelib.Code(err); code != tt.code {
+			t.Errorf("signalControlName(%s, %s, %d) error = %v, want %s", tt.c, tt.sig, tt.sigNo, err, tt.code)
 			continue
 		}
+		if want := tt.name; got != want {
+			t.Errorf("signalControlName(%s, %s, %d) = %s, want %s", tt.c, tt.sig, tt.sigNo, got, want)
+		}
+	}
+}
+
+func TestOutputModel(t *testing.T) {
+	mono := buses.Config{Auxes: 8, Groups: 16, StereoGroups: true}
+	for _, tt := range []struct {
+		desc  string
+		c     buses.Config
+		sig   signals.Signal
+		sigNo signals.SignalNo
+		bus   string // The output modelling the bus.
+	}{
+		{"odd stereo aux", buses.Default(), signals.Aux, 1, "Aux 1"},
+		{"even stereo aux", buses.Default(), signals.Aux, 2, "Aux 1"},
+		{"even mono aux", mono, signals.Aux, 2, "Aux 2"},
+		{"var group", buses.Default(), signals.VarGroup, 8, "Group 7"},
+		{"matrix", buses.Default(), signals.Matrix, 2, "Matrix 2"},
+	} {
+		v, err := New(Buses(tt.c))
+		if err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if err := v.soloOutput(tt.sig, tt.sigNo); err != nil {
+			t.Errorf("%s: soloOutput() unexpected error; %s", tt.desc, err)
+		}
+		if err := v.muteOutput(tt.sig, tt.sigNo, true); err != nil {
+			t.Errorf("%s: muteOutput() unexpected error; %s", tt.desc, err)
+		}
+		var soloed, muted []string
+		for _, o := range v.outputs {
+			if o.Soloed() {
+				soloed = append(soloed, o.Name())
+			}
+			if o.Muted() {
+				muted = append(muted, o.Name())
+			}
+		}
+		if got, want := soloed, []string{tt.bus}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: soloed = %q, want %q", tt.desc, got, want)
+		}
+		if got, want := muted, []string{tt.bus}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: muted = %q, want %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestHandleDisconnected(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	err = v.handle(&router.Packet{Action: actions.Noop})
+	if got, want := venuelib.Code(err), codes.Unavailable; got != want {
+		t.Errorf("handle() error code = %s, want %s", got, want)
+	}
+
+	v.setConnected(true)
+	if err := v.handle(&router.Packet{Action: actions.Noop}); err != nil {
+		t.Errorf("unexpected error; %s", err)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		d, max time.Duration
+		want   time.Duration
+	}{
+		{time.Second, 30 * time.Second, 2 * time.Second},
+		{8 * time.Second, 30 * time.Second, 16 * time.Second},
+		{16 * time.Second, 30 * time.Second, 30 * time.Second},
+		{30 * time.Second, 30 * time.Second, 30 * time.Second},
+	} {
+		if got, want := nextBackoff(tt.d, tt.max), tt.want; got != want {
+			t.Errorf("nextBackoff(%s, %s) = %s, want %s", tt.d, tt.max, got, want)
+		}
+	}
+}
+
+func TestReconnectOption(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		min, max time.Duration
+		ok       bool
+	}{
+		{"valid", time.Second, time.Minute, true},
+		{"equal", time.Second, time.Second, true},
+		{"zero min", 0, time.Minute, false},
+		{"max below min", time.Minute, time.Second, false},
+	} {
+		_, err := New(Reconnect(tt.min, tt.max))
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer v.exec.Close()
+
+	for _, tt := range []struct {
+		desc string
+		page *pages.Page // Last selected page.
+		keys keys.Keys
+		ok   bool
+	}{
+		{"unknown page", nil, keys.Keys{keys.Escape}, true},
+		{"outputs", &[]pages.Page{pages.Outputs}[0], keys.Keys{keys.Escape, keys.F2}, true},
+		{"invalidated", nil, keys.Keys{keys.Escape}, true},
+		{"unsupported page", &[]pages.Page{pages.Filing}[0], keys.Keys{keys.Escape}, false},
+	} {
+		if tt.page != nil {
+			v.ui.setPage(*tt.page)
+		} else {
+			v.ui.invalidate()
+		}
+		conn := &mockConn{}
+		r := vnc.NewWorkflow(conn)
+		err := v.recover(r)
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+		if got, want := v.ui.isStale(), !tt.ok || tt.page == nil; got != want {
+			t.Errorf("%s: stale = %t, want %t", tt.desc, got, want)
+		}
+		if err := r.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if got, want := conn.keys, tt.keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestCoalesceGainPads(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	defer v.exec.Close()
+	gain, err := v.ui.pages[pages.Inputs].Widget("Gain")
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+
+	conn := &mockConn{}
+	busy := vnc.NewWorkflow(conn)
+	busy.Sleep(100 * time.Millisecond)
+	busyCh := v.exec.Submit(context.Background(), busy, vnc.Normal)
+
+	// The outer gain pads step by 5, and are pressed while the console is busy.
+	var chs []<-chan vnc.Result
+	for _, steps := range []int{5, 5, -5, 5} {
+		wf := vnc.NewWorkflow(conn)
+		if err := gain.(*Encoder).Adjust(wf, steps); err != nil {
+			t.Fatalf("unexpected error; %s", err)
+		}
+		chs = append(chs, v.exec.Submit(context.Background(), wf, vnc.Normal))
+	}
+	if r := <-busyCh; r.Err != nil {
+		t.Fatalf("unexpected error; %s", r.Err)
+	}
+	for _, ch := range chs {
+		if r := <-ch; r.Err != nil {
+			t.Fatalf("unexpected error; %s", r.Err)
+		}
+	}
+
+	if got, want := len(conn.pointer), 1; got != want {
+		t.Errorf("encoder clicked %d times, want %d", got, want)
+	}
+	want := keys.Keys{}
+	for i := 0; i < 10; i++ {
+		want = append(want, keys.Up)
+	}
+	want = append(want, keys.Return)
+	if got := conn.keys; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
 	}
 }
@@ -0,0 +1,239 @@
+package venue
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/encoders"
+	"github.com/kward/venue/venue/meters"
+	"github.com/kward/venue/venue/switches"
+)
+
+/*
+Layouts describe the widgets of each VENUE page, so that other VENUE software
+versions or bus configurations are supported without recompiling. A layout is
+a versioned JSON file.
+
+	{
+	  "version": 1,
+	  "software": "VENUE Profile",
+	  "pages": [{
+	    "page": "Inputs",
+	    "widgets": [
+	      {"name": "Mute", "type": "Toggle", "x": 62, "y": 451, "size": "Large"},
+	      {"name": "Gain", "type": "Encoder", "x": 167, "y": 279, "window": "BottomLeft", "onOff": true},
+	      {"name": "Aux %d Solo", "type": "Toggle", "x": 8, "y": 573, "size": "Tiny",
+	       "repeat": {"count": 8, "dx": 15}}
+	    ]
+	  }]
+	}
+
+Widget types are Encoder, Meter, PushButton and Toggle. Positions are given for
+the reference screen. A repeated widget is placed count times, dx and dy apart,
+with its name formatted with numbers counting up from first (default 1). The
+bus widgets are slots named after the buses of the bus configuration; see
+buses.go.
+*/
+
+// layoutVersion is the layout file version understood by this package.
+const layoutVersion = 1
+
+// Layout describes the VENUE pages and their widgets.
+type Layout struct {
+	Version  int          `json:"version"`
+	Software string       `json:"software,omitempty"` // VENUE software the layout is for.
+	Pages    []PageLayout `json:"pages"`
+}
+
+// PageLayout describes the widgets of a VENUE page.
+type PageLayout struct {
+	Page    string         `json:"page"`
+	Widgets []WidgetLayout `json:"widgets"`
+}
+
+// WidgetLayout describes a widget, or a repeated run of widgets.
+type WidgetLayout struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Size    string  `json:"size,omitempty"`    // Switch or meter size.
+	Window  string  `json:"window,omitempty"`  // Encoder value window position.
+	OnOff   bool    `json:"onOff,omitempty"`   // Encoder has an on/off switch.
+	Default bool    `json:"default,omitempty"` // Toggle is enabled by default.
+	Stereo  bool    `json:"stereo,omitempty"`  // Meter is stereo.
+	Repeat  *Repeat `json:"repeat,omitempty"`
+}
+
+// Repeat describes how a widget is repeated.
+type Repeat struct {
+	Count int `json:"count"`
+	DX    int `json:"dx,omitempty"`
+	DY    int `json:"dy,omitempty"`
+	First int `json:"first,omitempty"` // Number of the first widget. Defaults to 1.
+}
+
+//go:embed layouts/default.json
+var defaultLayoutJSON []byte
+
+var (
+	defaultLayoutOnce sync.Once
+	defaultLayout     *Layout
+)
+
+// DefaultLayout returns the embedded layout of the supported VENUE software.
+func DefaultLayout() *Layout {
+	defaultLayoutOnce.Do(func() {
+		l, err := ParseLayout(bytes.NewReader(defaultLayoutJSON))
+		if err != nil {
+			panic(fmt.Sprintf("invalid default layout; %s", err))
+		}
+		defaultLayout = l
+	})
+	return defaultLayout
+}
+
+// LoadLayout loads the layout file at path.
+func LoadLayout(path string) (*Layout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.NotFound, "unable to open layout; %s", err)
+	}
+	defer f.Close()
+	l, err := ParseLayout(f)
+	if err != nil {
+		return nil, venuelib.Errorf(venuelib.Code(err), "%s: %s", path, venuelib.ErrorDesc(err))
+	}
+	return l, nil
+}
+
+// ParseLayout parses and validates a JSON layout.
+func ParseLayout(r io.Reader) (*Layout, error) {
+	l := &Layout{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(l); err != nil {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "invalid layout; %s", err)
+	}
+	if l.Version != layoutVersion {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "unsupported layout version %d; want %d", l.Version, layoutVersion)
+	}
+	if _, err := l.NewPages(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewPages returns newly populated pages of the layout.
+func (l *Layout) NewPages() (Pages, error) {
+	ps := Pages{}
+	for _, pl := range l.Pages {
+		p, err := parsePage(pl.Page)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ps[p]; ok {
+			return nil, venuelib.Errorf(codes.InvalidArgument, "duplicate %s page", p)
+		}
+		widgets := Widgets{}
+		for _, wl := range pl.Widgets {
+			if err := wl.add(widgets); err != nil {
+				return nil, venuelib.Errorf(venuelib.Code(err), "%s page: %s", p, venuelib.ErrorDesc(err))
+			}
+		}
+		ps[p] = &Page{p, widgets}
+	}
+	return ps, nil
+}
+
+// add adds the widgets described by the layout to widgets ws.
+func (wl WidgetLayout) add(ws Widgets) error {
+	r := Repeat{Count: 1, First: 1}
+	if wl.Repeat != nil {
+		r = *wl.Repeat
+		if r.Count < 1 {
+			return venuelib.Errorf(codes.InvalidArgument, "widget %q repeat count %d is not positive", wl.Name, r.Count)
+		}
+		if r.First == 0 {
+			r.First = 1
+		}
+		if !strings.Contains(wl.Name, "%d") {
+			return venuelib.Errorf(codes.InvalidArgument, "repeated widget %q is missing a %%d number", wl.Name)
+		}
+	}
+	for i := 0; i < r.Count; i++ {
+		n := wl.Name
+		if wl.Repeat != nil {
+			n = fmt.Sprintf(wl.Name, r.First+i)
+		}
+		if n == "" {
+			return venuelib.Errorf(codes.InvalidArgument, "widget is missing a name")
+		}
+		if _, ok := ws[n]; ok {
+			return venuelib.Errorf(codes.InvalidArgument, "duplicate widget %q", n)
+		}
+		w, err := wl.widget(image.Point{wl.X + i*r.DX, wl.Y + i*r.DY})
+		if err != nil {
+			return venuelib.Errorf(venuelib.Code(err), "widget %q: %s", n, venuelib.ErrorDesc(err))
+		}
+		ws[n] = w
+	}
+	return nil
+}
+
+// widget returns the widget described by the layout, positioned at p.
+func (wl WidgetLayout) widget(p image.Point) (Widget, error) {
+	switch {
+	case strings.EqualFold(wl.Type, "Encoder"):
+		window, err := parseName("encoder window", wl.Window, encoders.BottomRight)
+		if err != nil {
+			return nil, err
+		}
+		return &Encoder{p, window, wl.OnOff, nil}, nil
+
+	case strings.EqualFold(wl.Type, "Meter"):
+		size, err := parseName("meter size", wl.Size, meters.LargeVertical)
+		if err != nil {
+			return nil, err
+		}
+		return &Meter{pos: p, size: size, isStereo: wl.Stereo}, nil
+
+	case strings.EqualFold(wl.Type, switches.PushButton.String()):
+		size, err := parseName("switch size", wl.Size, switches.Large)
+		if err != nil {
+			return nil, err
+		}
+		return NewPushButton(p.X, p.Y, size), nil
+
+	case strings.EqualFold(wl.Type, switches.Toggle.String()):
+		size, err := parseName("switch size", wl.Size, switches.Large)
+		if err != nil {
+			return nil, err
+		}
+		return NewToggle(p.X, p.Y, size, wl.Default), nil
+	}
+	return nil, venuelib.Errorf(codes.InvalidArgument, "unknown widget type %q", wl.Type)
+}
+
+// parseName returns the value, from zero to last, with the case-insensitive
+// name s. Kind describes the value in errors.
+func parseName[T interface {
+	~int
+	String() string
+}](kind, s string, last T) (T, error) {
+	for v := T(0); v <= last; v++ {
+		if strings.EqualFold(v.String(), s) {
+			return v, nil
+		}
+	}
+	return 0, venuelib.Errorf(codes.InvalidArgument, "unknown %s %q", kind, s)
+}
@@ -0,0 +1,138 @@
+package venue
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/encoders"
+	"github.com/kward/venue/venue/meters"
+	"github.com/kward/venue/venue/pages"
+	"github.com/kward/venue/venue/switches"
+)
+
+func TestDefaultLayout(t *testing.T) {
+	ps, err := DefaultLayout().NewPages()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := len(ps[pages.Outputs].widgets), 2+3*3*8; got != want {
+		t.Errorf("got %d Outputs page widgets, want %d", got, want)
+	}
+
+	for _, tt := range []struct {
+		page   pages.Page
+		widget string
+		want   Widget
+	}{
+		{pages.Inputs, "Mute", NewToggle(62, 451, switches.Large, switches.Disabled)},
+		{pages.Inputs, "Guess", NewPushButton(153, 221, switches.Medium)},
+		{pages.Inputs, "Gain", &Encoder{image.Point{167, 279}, encoders.BottomLeft, true, nil}},
+		{pages.Inputs, "Send 8 Right", &Encoder{image.Point{473, 452}, encoders.TopLeft, false, nil}},
+		{pages.Inputs, "ChannelRange", NewPushButton(919, 516, switches.Medium)},
+		{pages.Outputs, "Bus 1 Solo", NewToggle(8, 573, switches.Tiny, false)},
+		{pages.Outputs, "Bus 16 Solo", NewToggle(244, 573, switches.Tiny, false)},
+		{pages.Outputs, "Bus 24 Meter", &Meter{pos: image.Point{637, 512}, size: meters.SmallVertical}},
+	} {
+		w, err := ps[tt.page].Widget(tt.widget)
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error; %s", tt.page, tt.widget, err)
+			continue
+		}
+		if got, want := w, tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s/%s: got %+v, want %+v", tt.page, tt.widget, got, want)
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		layout string
+		code   codes.Code
+	}{
+		{"valid",
+			`{"version": 1, "pages": [{"page": "inputs", "widgets": [
+			  {"name": "Mute", "type": "toggle", "x": 1, "y": 2, "size": "large", "default": true},
+			  {"name": "Ch %d", "type": "Meter", "x": 1, "y": 2, "size": "SmallVertical", "repeat": {"count": 2, "dx": 15}}]}]}`,
+			codes.OK},
+		{"bad json", `{"version": 1,`, codes.InvalidArgument},
+		{"unknown field", `{"version": 1, "pages": [], "extra": 1}`, codes.InvalidArgument},
+		{"unsupported version", `{"version": 2, "pages": []}`, codes.InvalidArgument},
+		{"unknown page", `{"version": 1, "pages": [{"page": "Mixer"}]}`, codes.InvalidArgument},
+		{"duplicate page", `{"version": 1, "pages": [{"page": "Inputs"}, {"page": "Inputs"}]}`, codes.InvalidArgument},
+		{"unknown type",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [{"name": "A", "type": "Fader"}]}]}`,
+			codes.InvalidArgument},
+		{"unknown size",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [{"name": "A", "type": "Toggle", "size": "Huge"}]}]}`,
+			codes.InvalidArgument},
+		{"unknown window",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [{"name": "A", "type": "Encoder"}]}]}`,
+			codes.InvalidArgument},
+		{"missing name",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [{"type": "Toggle", "size": "Tiny"}]}]}`,
+			codes.InvalidArgument},
+		{"duplicate widget",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [
+			  {"name": "Ch 2", "type": "Toggle", "size": "Tiny"},
+			  {"name": "Ch %d", "type": "Toggle", "size": "Tiny", "repeat": {"count": 2}}]}]}`,
+			codes.InvalidArgument},
+		{"repeat without number",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [
+			  {"name": "Ch", "type": "Toggle", "size": "Tiny", "repeat": {"count": 2}}]}]}`,
+			codes.InvalidArgument},
+		{"repeat without count",
+			`{"version": 1, "pages": [{"page": "Inputs", "widgets": [
+			  {"name": "Ch %d", "type": "Toggle", "size": "Tiny", "repeat": {"dx": 2}}]}]}`,
+			codes.InvalidArgument},
+	} {
+		_, err := ParseLayout(strings.NewReader(tt.layout))
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("%s: error code = %s, want %s; %v", tt.desc, got, want, err)
+		}
+	}
+}
+
+func TestLayoutFileOption(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "layout.json")
+	layout := `{"version": 1, "pages": [{"page": "Inputs", "widgets": [
+	  {"name": "Mute", "type": "Toggle", "x": 100, "y": 200, "size": "Large"}]}]}`
+	if err := os.WriteFile(path, []byte(layout), 0644); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		path string
+		code codes.Code
+		mute *Switch
+	}{
+		{"default", "", codes.OK, NewToggle(62, 451, switches.Large, switches.Disabled)},
+		{"file", path, codes.OK, NewToggle(100, 200, switches.Large, switches.Disabled)},
+		{"missing file", filepath.Join(dir, "missing.json"), codes.NotFound, nil},
+	} {
+		v, err := New(LayoutFile(tt.path))
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("%s: error code = %s, want %s; %v", tt.desc, got, want, err)
+		}
+		if err != nil {
+			continue
+		}
+		v.exec.Close()
+		w, err := v.ui.pages[pages.Inputs].Widget("Mute")
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := w, tt.mute; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.desc, got, want)
+		}
+	}
+}
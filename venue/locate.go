@@ -0,0 +1,263 @@
+package venue
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+/*
+Widgets are located by matching reference images (templates) against the
+framebuffer, so that a VENUE skin or version change that moves them is noticed
+rather than silently misrouting clicks. Templates are PNG images centered on
+the click point of a widget, captured at the reference screen size. They are
+kept in a directory holding a subdirectory per page, with one file per widget
+named after it, and any '/' in the name replaced with '_'.
+
+	templates/Inputs/SoloClear.png
+	templates/Inputs/AuxPan 1_2.png
+*/
+
+const (
+	// locateRadius is how far in pixels a widget is searched for around its
+	// expected position.
+	locateRadius = 12
+	// locateScore is the minimum normalized cross-correlation of a match.
+	locateScore = 0.8
+)
+
+// Template is the reference image of a widget, centered on its click point.
+type Template struct {
+	lumas  []float64 // Row-major.
+	size   image.Point
+	mean   float64
+	stddev float64 // Unnormalized; the root of the summed squared deviations.
+}
+
+// Templates holds the widget templates of each page.
+type Templates map[pages.Page]map[string]*Template
+
+// NewTemplate returns the template of image img.
+func NewTemplate(img image.Image) (*Template, error) {
+	b := img.Bounds()
+	t := &Template{size: b.Size()}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			t.lumas = append(t.lumas, float64(luma(c)))
+		}
+	}
+	t.mean, t.stddev = meanDev(t.lumas)
+	if t.stddev == 0 {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "template is featureless")
+	}
+	return t, nil
+}
+
+// LoadTemplates loads the widget templates in directory dir.
+func LoadTemplates(dir string) (Templates, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.NotFound, "unable to read templates; %s", err)
+	}
+	ts := Templates{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p, err := parsePage(e.Name())
+		if err != nil {
+			return nil, venuelib.Errorf(codes.InvalidArgument, "invalid template directory %q; %s", e.Name(), venuelib.ErrorDesc(err))
+		}
+		files, err := filepath.Glob(filepath.Join(dir, e.Name(), "*.png"))
+		if err != nil {
+			return nil, venuelib.Errorf(codes.Internal, "unable to list templates; %s", err)
+		}
+		for _, path := range files {
+			t, err := loadTemplate(path)
+			if err != nil {
+				return nil, err
+			}
+			if ts[p] == nil {
+				ts[p] = map[string]*Template{}
+			}
+			name := strings.ReplaceAll(strings.TrimSuffix(filepath.Base(path), ".png"), "_", "/")
+			ts[p][name] = t
+		}
+	}
+	return ts, nil
+}
+
+// loadTemplate loads the template PNG at path.
+func loadTemplate(path string) (*Template, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.NotFound, "unable to open template; %s", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "invalid template %s; %s", path, err)
+	}
+	t, err := NewTemplate(img)
+	if err != nil {
+		return nil, venuelib.Errorf(venuelib.Code(err), "%s: %s", path, venuelib.ErrorDesc(err))
+	}
+	return t, nil
+}
+
+// match returns the top-left position within rectangle r of sampler s that
+// best matches template t, and the normalized cross-correlation of the match.
+func (t *Template) match(s sampler, r image.Rectangle) (image.Point, float64) {
+	var (
+		best      image.Point
+		bestScore = math.Inf(-1)
+		lumas     = make([]float64, len(t.lumas))
+	)
+	r = image.Rectangle{r.Min, r.Max.Sub(t.size).Add(image.Point{1, 1})}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !(image.Rectangle{image.Point{x, y}, image.Point{x, y}.Add(t.size)}).In(s.Bounds()) {
+				continue
+			}
+			i := 0
+			for dy := 0; dy < t.size.Y; dy++ {
+				for dx := 0; dx < t.size.X; dx++ {
+					lumas[i] = float64(luma(s.RGBAAt(x+dx, y+dy)))
+					i++
+				}
+			}
+			if score := t.ncc(lumas); score > bestScore {
+				best, bestScore = image.Point{x, y}, score
+			}
+		}
+	}
+	return best, bestScore
+}
+
+// ncc returns the normalized cross-correlation of the template with lumas.
+// Featureless areas score zero.
+func (t *Template) ncc(lumas []float64) float64 {
+	mean, dev := meanDev(lumas)
+	if dev == 0 {
+		return 0
+	}
+	var sum float64
+	for i, l := range lumas {
+		sum += (l - mean) * (t.lumas[i] - t.mean)
+	}
+	return sum / (dev * t.stddev)
+}
+
+// meanDev returns the mean of vs, and the root of their summed squared
+// deviations from it.
+func meanDev(vs []float64) (float64, float64) {
+	var mean, dev float64
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(len(vs))
+	for _, v := range vs {
+		dev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(dev)
+}
+
+// Location is the outcome of locating a widget from its template.
+type Location struct {
+	Page   pages.Page
+	Widget string
+	Found  bool
+	Score  float64     // Normalized cross-correlation of the best match.
+	Offset image.Point // Distance of the best match from the expected position.
+}
+
+// locate matches the templates of page p against sampler s, moving the widgets
+// found to where they are displayed. Locations are ordered by widget name.
+func (ui *UI) locate(p pages.Page, s sampler, ts map[string]*Template) []Location {
+	page, ok := ui.pages[p]
+	if !ok {
+		return nil
+	}
+	var ls []Location
+	for name, t := range ts {
+		l := Location{Page: p, Widget: name}
+		w, err := page.Widget(name)
+		if err != nil {
+			glog.Errorf("No %s page widget matches the %q template.", p, name)
+			ls = append(ls, l)
+			continue
+		}
+		tw, ok := w.(target)
+		if !ok {
+			glog.Errorf("The %s/%s widget cannot be located.", p, name)
+			ls = append(ls, l)
+			continue
+		}
+		want := tw.clickPoint().Sub(t.size.Div(2))
+		r := image.Rectangle{want, want.Add(t.size)}.Inset(-locateRadius)
+		got, score := t.match(s, r)
+		l.Score, l.Offset = score, got.Sub(want)
+		if l.Found = score >= locateScore; l.Found {
+			tw.moveBy(l.Offset)
+		}
+		ls = append(ls, l)
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Widget < ls[j].Widget })
+	return ls
+}
+
+// LocateWidgets displays each page with widget templates, and moves the widgets
+// to where their templates match. Widgets that cannot be found are reported,
+// and keep their layout position.
+func (v *Venue) LocateWidgets(ctx context.Context) ([]Location, error) {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	return v.locateWidgets(ctx, v.currentUI())
+}
+
+// locateWidgets locates the widgets of UI ui.
+func (v *Venue) locateWidgets(ctx context.Context, ui *UI) ([]Location, error) {
+	var ps []pages.Page
+	for p := range v.opts.templates {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+
+	var ls []Location
+	for _, p := range ps {
+		if _, ok := ui.pages[p]; !ok {
+			glog.Errorf("The layout has no %s page to locate widgets on.", p)
+			continue
+		}
+		if err := v.showPage(ctx, ui, p); err != nil {
+			return ls, err
+		}
+		s, err := framebuffer(v.newWorkflow())
+		if err != nil {
+			return ls, err
+		}
+		for _, l := range ui.locate(p, s, v.opts.templates[p]) {
+			switch {
+			case !l.Found:
+				glog.Errorf("Unable to locate the %s/%s widget; best match %.2f at offset %s.", l.Page, l.Widget, l.Score, l.Offset)
+			case l.Offset != image.Point{} && bool(glog.V(1)):
+				glog.Infof("Moved the %s/%s widget by %s.", l.Page, l.Widget, l.Offset)
+			}
+			ls = append(ls, l)
+		}
+	}
+	return ls, nil
+}
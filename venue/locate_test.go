@@ -0,0 +1,202 @@
+package venue
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+func TestTemplateMatch(t *testing.T) {
+	tmpl, err := NewTemplate(newPattern(0, 1))
+	if err != nil {
+		t.Fatalf("NewTemplate() unexpected error: %s", err)
+	}
+	search := image.Rect(10, 10, 50, 50)
+
+	for _, tt := range []struct {
+		desc  string
+		at    image.Point // Pattern position; negative is absent.
+		bias  int
+		gain  int
+		found bool
+	}{
+		{"exact", image.Point{20, 20}, 0, 1, true},
+		{"shifted", image.Point{27, 14}, 0, 1, true},
+		{"brighter", image.Point{15, 31}, 40, 1, true},
+		{"higher contrast", image.Point{33, 25}, 10, 2, true},
+		{"absent", image.Point{-1, -1}, 0, 1, false},
+		{"outside search", image.Point{60, 60}, 0, 1, false},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+		for i := range img.Pix {
+			img.Pix[i] = 64
+		}
+		if tt.at.X >= 0 {
+			pat := newPattern(tt.bias, tt.gain)
+			for y := 0; y < pat.Bounds().Dy(); y++ {
+				for x := 0; x < pat.Bounds().Dx(); x++ {
+					img.Set(tt.at.X+x, tt.at.Y+y, pat.At(x, y))
+				}
+			}
+		}
+		p, score := tmpl.match(img, search)
+		if got, want := score >= locateScore, tt.found; got != want {
+			t.Errorf("%s: found = %t (score %.2f), want %t", tt.desc, got, score, want)
+			continue
+		}
+		if !tt.found {
+			continue
+		}
+		if got, want := p, tt.at; got != want {
+			t.Errorf("%s: match = %s, want %s", tt.desc, got, want)
+		}
+	}
+}
+
+func TestNewTemplateFeatureless(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	if _, err := NewTemplate(img); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("NewTemplate() error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "Inputs", "SoloClear.png"), newPattern(0, 1))
+	writePNG(t, filepath.Join(dir, "Outputs", "AuxPan 1_2.png"), newPattern(0, 1))
+	ts, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("LoadTemplates() unexpected error: %s", err)
+	}
+	for _, tt := range []struct {
+		page pages.Page
+		name string
+	}{
+		{pages.Inputs, "SoloClear"},
+		{pages.Outputs, "AuxPan 1/2"},
+	} {
+		if _, ok := ts[tt.page][tt.name]; !ok {
+			t.Errorf("%s/%s template not loaded", tt.page, tt.name)
+		}
+	}
+
+	for _, tt := range []struct {
+		desc string
+		path string
+		code codes.Code
+	}{
+		{"unknown page", filepath.Join("Unknown", "Mute.png"), codes.InvalidArgument},
+		{"featureless", filepath.Join("Inputs", "Mute.png"), codes.InvalidArgument},
+	} {
+		dir := t.TempDir()
+		writePNG(t, filepath.Join(dir, tt.path), image.NewGray(image.Rect(0, 0, 8, 8)))
+		if _, err := LoadTemplates(dir); venuelib.Code(err) != tt.code {
+			t.Errorf("%s: LoadTemplates() error = %v, want %s", tt.desc, err, tt.code)
+		}
+	}
+	if _, err := LoadTemplates(filepath.Join(dir, "missing")); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("LoadTemplates(missing) error = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestUILocate(t *testing.T) {
+	tmpl, err := NewTemplate(newPattern(0, 1))
+	if err != nil {
+		t.Fatalf("NewTemplate() unexpected error: %s", err)
+	}
+	ts := map[string]*Template{"SoloClear": tmpl, "Mute": tmpl, "Unknown": tmpl}
+
+	ui := defaultUI(t)
+	w, err := ui.pages[pages.Inputs].Widget("SoloClear")
+	if err != nil {
+		t.Fatalf("Widget() unexpected error: %s", err)
+	}
+	click := w.(target).clickPoint()
+
+	// Draw the SoloClear pattern displaced from its layout position.
+	offset := image.Point{5, -3}
+	img := image.NewRGBA(image.Rectangle{Max: screenSize})
+	pat := newPattern(0, 1)
+	at := click.Add(offset).Sub(pat.Bounds().Size().Div(2))
+	for y := 0; y < pat.Bounds().Dy(); y++ {
+		for x := 0; x < pat.Bounds().Dx(); x++ {
+			img.Set(at.X+x, at.Y+y, pat.At(x, y))
+		}
+	}
+
+	ls := ui.locate(pages.Inputs, img, ts)
+	if got, want := len(ls), len(ts); got != want {
+		t.Fatalf("got %d locations, want %d", got, want)
+	}
+	for _, tt := range []struct {
+		name   string
+		found  bool
+		offset image.Point
+	}{
+		{"Mute", false, image.Point{}},
+		{"SoloClear", true, offset},
+		{"Unknown", false, image.Point{}},
+	} {
+		var l *Location
+		for i := range ls {
+			if ls[i].Widget == tt.name {
+				l = &ls[i]
+			}
+		}
+		if l == nil {
+			t.Errorf("%s: no location", tt.name)
+			continue
+		}
+		if got, want := l.Found, tt.found; got != want {
+			t.Errorf("%s: found = %t (score %.2f), want %t", tt.name, got, l.Score, want)
+		}
+		if tt.found {
+			if got, want := l.Offset, tt.offset; got != want {
+				t.Errorf("%s: offset = %s, want %s", tt.name, got, want)
+			}
+		}
+	}
+	if got, want := w.(target).clickPoint(), click.Add(offset); got != want {
+		t.Errorf("SoloClear click point = %s, want %s", got, want)
+	}
+}
+
+// newPattern returns a 12x10 test pattern, with its brightness offset by bias
+// and its contrast multiplied by gain.
+func newPattern(bias, gain int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 12, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 12; x++ {
+			v := 20 + 8*((x*7+y*3)%11)
+			if x > 3 && x < 8 && y > 2 && y < 7 {
+				v = 100
+			}
+			v = bias + gain*v
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+	return img
+}
+
+// writePNG writes img as a PNG to path, creating its directory.
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
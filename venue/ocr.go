@@ -0,0 +1,361 @@
+package venue
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+/*
+Encoder values are recognized by matching the text of their value windows
+against glyphs captured from VENUE, so that recognition follows the font VENUE
+renders. A glyph is a PNG crop of one character, glyphHeight pixels high and
+captured at the reference screen size. Glyphs are kept in a directory with one
+file per character, named after it, or after its name for the characters that
+are unfit for file names.
+
+	glyphs/0.png
+	glyphs/plus.png
+	glyphs/k.png
+
+Glyphs are captured from a value window showing known text, e.g. with
+venue_cli glyphs.
+*/
+
+// glyphNames names the characters that are unfit for file names.
+var glyphNames = map[rune]string{'+': "plus", '-': "minus", '.': "dot"}
+
+const (
+	glyphHeight   = 7    // Height of a glyph in pixels.
+	glyphSpace    = 3    // Minimum gap in pixels that separates words.
+	glyphMismatch = 0.15 // Maximum fraction of mismatched glyph pixels.
+	textLuma      = 128  // Minimum luma of a text pixel.
+)
+
+// Glyph is the image of a character, trimmed of blank columns.
+type Glyph struct {
+	r      rune
+	width  int
+	pixels []bool // Row-major, glyphHeight rows of width columns.
+}
+
+// Glyphs holds the glyphs that text is recognized with.
+type Glyphs []*Glyph
+
+// NewGlyph returns the glyph of character r in image img, which is glyphHeight
+// pixels high.
+func NewGlyph(r rune, img image.Image) (*Glyph, error) {
+	b := img.Bounds()
+	if b.Dy() != glyphHeight {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "glyph %q is %dpx high, want %dpx", r, b.Dy(), glyphHeight)
+	}
+	lit := func(x, y int) bool {
+		return luma(color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)) >= textLuma
+	}
+	minX, maxX := b.Max.X, b.Min.X-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if lit(x, y) {
+				minX, maxX = min(minX, x), max(maxX, x)
+			}
+		}
+	}
+	if maxX < minX {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "glyph %q is blank", r)
+	}
+	g := &Glyph{r: r, width: maxX - minX + 1}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := minX; x <= maxX; x++ {
+			g.pixels = append(g.pixels, lit(x, y))
+		}
+	}
+	return g, nil
+}
+
+// GlyphFile returns the file name of the glyph of character r.
+func GlyphFile(r rune) string {
+	if n, ok := glyphNames[r]; ok {
+		return n + ".png"
+	}
+	return string(r) + ".png"
+}
+
+// glyphRune returns the character of glyph file name n.
+func glyphRune(n string) (rune, error) {
+	n = strings.TrimSuffix(n, ".png")
+	for r, name := range glyphNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	if r, size := utf8.DecodeRuneInString(n); r != utf8.RuneError && size == len(n) {
+		return r, nil
+	}
+	return 0, venuelib.Errorf(codes.InvalidArgument, "glyph file %q names no character", n)
+}
+
+// LoadGlyphs loads the glyphs in directory dir.
+func LoadGlyphs(dir string) (Glyphs, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, venuelib.Errorf(codes.Internal, "unable to list glyphs; %s", err)
+	}
+	if len(files) == 0 {
+		return nil, venuelib.Errorf(codes.NotFound, "no glyphs in %s", dir)
+	}
+	var gs Glyphs
+	for _, path := range files {
+		g, err := loadGlyph(path)
+		if err != nil {
+			return nil, err
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// loadGlyph loads the glyph PNG at path.
+func loadGlyph(path string) (*Glyph, error) {
+	r, err := glyphRune(filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.NotFound, "unable to open glyph; %s", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "invalid glyph %s; %s", path, err)
+	}
+	g, err := NewGlyph(r, img)
+	if err != nil {
+		return nil, venuelib.Errorf(venuelib.Code(err), "%s: %s", path, venuelib.ErrorDesc(err))
+	}
+	return g, nil
+}
+
+// textCell is the cell of a glyph within a line of text.
+type textCell struct {
+	r     image.Rectangle
+	space bool // True if a word gap precedes the cell.
+}
+
+// segmentText splits the single line of text displayed within rectangle r into
+// glyph cells separated by blank columns. A blank window has no cells.
+func segmentText(s sampler, r image.Rectangle) ([]textCell, error) {
+	r = r.Intersect(s.Bounds())
+	lit := func(x, y int) bool { return luma(s.RGBAAt(x, y)) >= textLuma }
+
+	// The baseline is the lowest row containing text, as every glyph that can
+	// end a value sits on it.
+	baseline := -1
+	for y := r.Max.Y - 1; y >= r.Min.Y && baseline < 0; y-- {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if lit(x, y) {
+				baseline = y
+				break
+			}
+		}
+	}
+	if baseline < 0 {
+		return nil, nil // Blank window.
+	}
+	top := baseline - glyphHeight + 1
+	if top < r.Min.Y {
+		return nil, venuelib.Errorf(codes.OutOfRange, "text at %v exceeds window %v", baseline, r)
+	}
+	colLit := func(x int) bool {
+		for y := top; y <= baseline; y++ {
+			if lit(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var cs []textCell
+	gap := 0
+	for x := r.Min.X; x < r.Max.X; {
+		if !colLit(x) {
+			gap++
+			x++
+			continue
+		}
+		x0 := x
+		for x < r.Max.X && colLit(x) {
+			x++
+		}
+		cs = append(cs, textCell{image.Rect(x0, top, x, baseline+1), len(cs) > 0 && gap >= glyphSpace})
+		gap = 0
+	}
+	return cs, nil
+}
+
+// readText recognizes the single line of text displayed within rectangle r,
+// with glyphs gs.
+func readText(s sampler, r image.Rectangle, gs Glyphs) (string, error) {
+	cs, err := segmentText(s, r)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, c := range cs {
+		if c.space {
+			sb.WriteRune(' ')
+		}
+		g, err := gs.match(s, c.r)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(g)
+	}
+	return sb.String(), nil
+}
+
+// match returns the character of the glyph that best matches cell r of s.
+func (gs Glyphs) match(s sampler, r image.Rectangle) (rune, error) {
+	if len(gs) == 0 {
+		return 0, venuelib.Errorf(codes.FailedPrecondition, "no glyphs to recognize text with")
+	}
+	w := r.Dx()
+	best, bestScore := rune(0), math.MaxFloat64
+	for _, g := range gs {
+		if g.width != w {
+			continue
+		}
+		miss := 0
+		for dy := 0; dy < glyphHeight; dy++ {
+			for dx := 0; dx < w; dx++ {
+				if lit := luma(s.RGBAAt(r.Min.X+dx, r.Min.Y+dy)) >= textLuma; lit != g.pixels[dy*w+dx] {
+					miss++
+				}
+			}
+		}
+		if score := float64(miss) / float64(w*glyphHeight); score < bestScore {
+			best, bestScore = g.r, score
+		}
+	}
+	if bestScore > glyphMismatch {
+		return 0, venuelib.Errorf(codes.NotFound, "unrecognized %dpx glyph at %s", w, r.Min)
+	}
+	return best, nil
+}
+
+// captureGlyphs returns the images of the glyphs of the single line of text
+// displayed within rectangle r of s, which shows `text`. The first image of
+// each character is kept.
+func captureGlyphs(s sampler, r image.Rectangle, text string) (map[rune]image.Image, error) {
+	cs, err := segmentText(s, r)
+	if err != nil {
+		return nil, err
+	}
+	rs := []rune(strings.ReplaceAll(text, " ", ""))
+	if len(cs) != len(rs) {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "window shows %d characters, not the %d of %q", len(cs), len(rs), text)
+	}
+	imgs := map[rune]image.Image{}
+	for i, c := range cs {
+		if _, ok := imgs[rs[i]]; ok {
+			continue
+		}
+		img := image.NewRGBA(image.Rectangle{Max: c.r.Size()})
+		for y := c.r.Min.Y; y < c.r.Max.Y; y++ {
+			for x := c.r.Min.X; x < c.r.Max.X; x++ {
+				img.SetRGBA(x-c.r.Min.X, y-c.r.Min.Y, s.RGBAAt(x, y))
+			}
+		}
+		imgs[rs[i]] = img
+	}
+	return imgs, nil
+}
+
+// CaptureGlyphs returns the images of the glyphs shown by the value window of
+// the named encoder of the INPUTS page, which shows `text`, keyed by character.
+// The glyphs are recognized with once saved, see LoadGlyphs and GlyphFile.
+func (v *Venue) CaptureGlyphs(ctx context.Context, widget, text string) (map[rune]image.Image, error) {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	ui := v.currentUI()
+	w, err := ui.pages[pages.Inputs].Widget(widget)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := w.(*Encoder)
+	if !ok {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "%q is not an encoder", widget)
+	}
+	if err := v.showPage(ctx, ui, pages.Inputs); err != nil {
+		return nil, err
+	}
+	s, err := framebuffer(v.newWorkflow())
+	if err != nil {
+		return nil, err
+	}
+	return captureGlyphs(s, e.windowRect(), text)
+}
+
+// EncoderValue is the typed value displayed in an encoder window.
+type EncoderValue struct {
+	Value float64
+	Unit  string // Measurement unit, e.g. "dB", "Hz" or "ms". May be empty.
+}
+
+// String returns a human readable representation of the value.
+func (v EncoderValue) String() string {
+	if v.Unit == "" {
+		return fmt.Sprintf("%g", v.Value)
+	}
+	return fmt.Sprintf("%g %s", v.Value, v.Unit)
+}
+
+var valueRE = regexp.MustCompile(`^([+-]?(?:[0-9]+(?:\.[0-9]+)?|INF))\s*([A-Za-z]*)$`)
+
+// parseValue parses encoder text, e.g. "-12.5", "+3", "-INF" or "250 Hz".
+// Kilohertz values are converted into Hz.
+func parseValue(s string) (EncoderValue, error) {
+	m := valueRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return EncoderValue{}, venuelib.Errorf(codes.InvalidArgument, "unable to parse encoder value %q", s)
+	}
+	num, unit := m[1], m[2]
+
+	var val float64
+	switch strings.TrimLeft(num, "+-") {
+	case "INF":
+		val = math.Inf(1)
+		if num[0] == '-' {
+			val = math.Inf(-1)
+		}
+	default:
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return EncoderValue{}, venuelib.Errorf(codes.InvalidArgument, "invalid encoder number %q; %s", num, err)
+		}
+		val = v
+	}
+	switch unit {
+	case "kHz":
+		val, unit = val*1000, "Hz"
+	case "", "dB", "Hz", "ms":
+	default:
+		return EncoderValue{}, venuelib.Errorf(codes.InvalidArgument, "unrecognized encoder unit %q", unit)
+	}
+	return EncoderValue{val, unit}, nil
+}
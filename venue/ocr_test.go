@@ -0,0 +1,268 @@
+package venue
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/encoders"
+)
+
+var text = color.RGBA{230, 230, 230, 255}
+
+// testFont is a 5x7 pixel font standing in for VENUE screens. Each glyph is
+// described row by row, with '#' marking a lit pixel. It exercises the text
+// segmentation, glyph capture and matching, not the glyphs of VENUE itself.
+var testFont = map[rune][glyphHeight]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'+': {"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "},
+	'-': {"     ", "     ", "     ", "#####", "     ", "     ", "     "},
+	'.': {"  ", "  ", "  ", "  ", "  ", "##", "##"},
+	'B': {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'F': {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "},
+	'H': {"#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'I': {"###", " # ", " # ", " # ", " # ", " # ", "###"},
+	'N': {"#   #", "##  #", "# # #", "#  ##", "#   #", "#   #", "#   #"},
+	'd': {"    #", "    #", " ## #", "#  ##", "#   #", "#   #", " ####"},
+	'k': {"#    ", "#    ", "#  # ", "# #  ", "##   ", "# #  ", "#  # "},
+	'm': {"     ", "     ", "## # ", "# # #", "# # #", "#   #", "#   #"},
+	's': {"     ", "     ", " ####", "#    ", " ### ", "    #", "#### "},
+	'z': {"     ", "     ", "#####", "   # ", "  #  ", " #   ", "#####"},
+}
+
+// testFontChars holds every character of testFont.
+const testFontChars = "0123456789+-.BFHINdkmsz"
+
+// testGlyphs returns the glyphs of testFont.
+func testGlyphs(t *testing.T) Glyphs {
+	t.Helper()
+	var gs Glyphs
+	for _, c := range testFontChars {
+		g, err := NewGlyph(c, newTextImage(image.Rect(0, 0, 8, glyphHeight), image.Point{1, 0}, string(c)))
+		if err != nil {
+			t.Fatalf("NewGlyph(%q) unexpected error; %s", c, err)
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+var readTextTests = []struct {
+	desc string
+	s    string
+}{
+	{"blank", ""},
+	{"integer", "10"},
+	{"negative decimal", "-12.5"},
+	{"positive", "+3"},
+	{"infinity", "-INF"},
+	{"frequency", "250 Hz"},
+	{"kilohertz", "1.2 kHz"},
+	{"delay", "35 ms"},
+	{"all digits", "1234567890"},
+}
+
+func TestReadText(t *testing.T) {
+	gs := testGlyphs(t)
+	for _, tt := range readTextTests {
+		img := newTextImage(image.Rect(0, 0, 80, 11), image.Point{2, 2}, tt.s)
+		got, err := readText(img, img.Bounds(), gs)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if want := tt.s; got != want {
+			t.Errorf("%s: readText() = %q, want %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestReadTextUnrecognized(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 11))
+	draw.Draw(img, image.Rect(2, 2, 12, 9), image.NewUniform(text), image.Point{}, draw.Src)
+	if _, err := readText(img, img.Bounds(), testGlyphs(t)); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("readText() error = %v, want %s", err, codes.NotFound)
+	}
+	if _, err := readText(img, img.Bounds(), nil); venuelib.Code(err) != codes.FailedPrecondition {
+		t.Errorf("readText() without glyphs error = %v, want %s", err, codes.FailedPrecondition)
+	}
+}
+
+// TestCaptureGlyphs captures the glyphs of a value window, saves and loads
+// them, as venue_cli glyphs and the --venue_glyphs flag do, and recognizes text
+// with them.
+func TestCaptureGlyphs(t *testing.T) {
+	shown := "+-.0123456789 INF dB Hz kms"
+	img := newTextImage(image.Rect(0, 0, 200, 11), image.Point{2, 2}, shown)
+	imgs, err := captureGlyphs(img, img.Bounds(), shown)
+	if err != nil {
+		t.Fatalf("captureGlyphs() unexpected error; %s", err)
+	}
+	if got, want := len(imgs), len(testFontChars); got != want {
+		t.Errorf("captureGlyphs() returned %d glyphs, want %d", got, want)
+	}
+	dir := t.TempDir()
+	for r, img := range imgs {
+		f, err := os.Create(filepath.Join(dir, GlyphFile(r)))
+		if err != nil {
+			t.Fatalf("unexpected error; %s", err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatalf("unexpected error; %s", err)
+		}
+		f.Close()
+	}
+	gs, err := LoadGlyphs(dir)
+	if err != nil {
+		t.Fatalf("LoadGlyphs() unexpected error; %s", err)
+	}
+	for _, tt := range readTextTests {
+		img := newTextImage(image.Rect(0, 0, 80, 11), image.Point{2, 2}, tt.s)
+		got, err := readText(img, img.Bounds(), gs)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if want := tt.s; got != want {
+			t.Errorf("%s: readText() = %q, want %q", tt.desc, got, want)
+		}
+	}
+
+	if _, err := captureGlyphs(img, img.Bounds(), "+-"); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("captureGlyphs() of mismatched text error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestLoadGlyphsErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadGlyphs(dir); venuelib.Code(err) != codes.NotFound {
+		t.Errorf("LoadGlyphs() of an empty directory error = %v, want %s", err, codes.NotFound)
+	}
+	f, err := os.Create(filepath.Join(dir, "ten.png"))
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	png.Encode(f, newTextImage(image.Rect(0, 0, 8, glyphHeight), image.Point{1, 0}, "1"))
+	f.Close()
+	if _, err := LoadGlyphs(dir); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("LoadGlyphs() of an unnamed glyph error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestGlyphFile(t *testing.T) {
+	for _, r := range testFontChars {
+		got, err := glyphRune(GlyphFile(r))
+		if err != nil {
+			t.Errorf("glyphRune(%q) unexpected error; %s", GlyphFile(r), err)
+			continue
+		}
+		if got != r {
+			t.Errorf("glyphRune(%q) = %q, want %q", GlyphFile(r), got, r)
+		}
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want EncoderValue
+		ok   bool
+	}{
+		{"-12.5", EncoderValue{-12.5, ""}, true},
+		{"+3", EncoderValue{3, ""}, true},
+		{"-INF", EncoderValue{math.Inf(-1), ""}, true},
+		{"10.0 dB", EncoderValue{10, "dB"}, true},
+		{"250 Hz", EncoderValue{250, "Hz"}, true},
+		{"1.2 kHz", EncoderValue{1200, "Hz"}, true},
+		{"35 ms", EncoderValue{35, "ms"}, true},
+		{"", EncoderValue{}, false},
+		{"12 parsecs", EncoderValue{}, false},
+		{"1-2", EncoderValue{}, false},
+	} {
+		got, err := parseValue(tt.s)
+		if err != nil && tt.ok {
+			t.Errorf("parseValue(%q) unexpected error; %s", tt.s, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("parseValue(%q) expected an error", tt.s)
+		}
+		if !tt.ok {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseValue(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderRead(t *testing.T) {
+	gs := testGlyphs(t)
+	for _, tt := range []struct {
+		desc string
+		enc  *Encoder
+		s    string
+		want EncoderValue
+	}{
+		{"gain", &Encoder{image.Point{167, 279}, encoders.BottomLeft, true, gs}, "+24", EncoderValue{24, ""}},
+		{"aux", &Encoder{image.Point{316, 95}, encoders.TopRight, true, gs}, "-INF", EncoderValue{math.Inf(-1), ""}},
+		{"hpf", &Encoder{image.Point{168, 454}, encoders.BottomLeft, true, gs}, "120 Hz", EncoderValue{120, "Hz"}},
+	} {
+		r := tt.enc.windowRect()
+		img := newTextImage(image.Rect(0, 0, 600, 600), r.Min.Add(image.Point{2, 2}), tt.s)
+		got, err := tt.enc.read(img)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: read() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderReadNoGlyphs(t *testing.T) {
+	e := &Encoder{image.Point{167, 279}, encoders.BottomLeft, true, nil}
+	img := newTextImage(image.Rect(0, 0, 600, 600), e.windowRect().Min.Add(image.Point{2, 2}), "+24")
+	if _, err := e.read(img); venuelib.Code(err) != codes.FailedPrecondition {
+		t.Errorf("read() error = %v, want %s", err, codes.FailedPrecondition)
+	}
+}
+
+// newTextImage returns an image of size r with string s rendered at point p in
+// testFont.
+func newTextImage(r image.Rectangle, p image.Point, s string) *image.RGBA {
+	img := image.NewRGBA(r)
+	draw.Draw(img, r, image.NewUniform(background), image.Point{}, draw.Src)
+	for _, c := range s {
+		if c == ' ' {
+			p.X += glyphSpace + 1
+			continue
+		}
+		rows := testFont[c]
+		for y, row := range rows {
+			for x, px := range row {
+				if px == '#' {
+					img.SetRGBA(p.X+x, p.Y+y, text)
+				}
+			}
+		}
+		p.X += len(rows[0]) + 1
+	}
+	return img
+}
@@ -0,0 +1,140 @@
+package venue
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+// sampler provides access to the pixels of a framebuffer image. It is
+// satisfied by vnc.Framebuffer, vnc.Snapshot and image.RGBA.
+type sampler interface {
+	Bounds() image.Rectangle
+	RGBAAt(x, y int) color.RGBA
+}
+
+// Verify that the expected interface is implemented properly.
+var _ sampler = new(vnc.Framebuffer)
+var _ sampler = new(vnc.Snapshot)
+var _ sampler = new(image.RGBA)
+
+// framebuffer returns the sampler for the workflow framebuffer. The sampler is
+// the current snapshot, so that a widget reads its pixels from a single frame,
+// and is addressed in reference screen coordinates. Until the first update is
+// received from VENUE there is nothing to read.
+func framebuffer(wf *vnc.Workflow) (sampler, error) {
+	fb := wf.Framebuffer()
+	if fb == nil {
+		return nil, venuelib.Errorf(codes.FailedPrecondition, "workflow has no framebuffer")
+	}
+	snap := fb.Snapshot()
+	if snap.Version == 0 {
+		return nil, venuelib.Errorf(codes.Unavailable, "no framebuffer update received yet")
+	}
+	return sample(snap, wf.Transform()), nil
+}
+
+// sample returns a sampler of snapshot snap, addressed in reference screen
+// coordinates through transform tf.
+func sample(snap *vnc.Snapshot, tf vnc.Transform) sampler {
+	if !tf.IsIdentity() {
+		return &transformed{snap, tf}
+	}
+	return snap
+}
+
+// transformed is a sampler presenting the framebuffer pixels at the reference
+// screen size, so that widgets read the same positions at any resolution.
+type transformed struct {
+	s  sampler
+	tf vnc.Transform
+}
+
+// Verify that the expected interface is implemented properly.
+var _ sampler = new(transformed)
+
+func (t *transformed) Bounds() image.Rectangle {
+	return image.Rectangle{Max: screenSize}
+}
+
+func (t *transformed) RGBAAt(x, y int) color.RGBA {
+	p := t.tf.Pixel(x, y)
+	return t.s.RGBAAt(p.X, p.Y)
+}
+
+// luma returns the perceived brightness (0..255) of color c.
+func luma(c color.RGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
+
+// litFraction returns the fraction of pixels within rectangle r that have a
+// luma of at least min.
+func litFraction(s sampler, r image.Rectangle, min int) float64 {
+	r = r.Intersect(s.Bounds())
+	if r.Empty() {
+		return 0
+	}
+	lit := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if luma(s.RGBAAt(x, y)) >= min {
+				lit++
+			}
+		}
+	}
+	return float64(lit) / float64(r.Dx()*r.Dy())
+}
+
+const (
+	meterLuma = 80 // Minimum luma of a lit meter segment.
+	meterGap  = 2  // Maximum gap in pixels between lit meter segments.
+)
+
+// meterScale maps the lit fraction of a meter onto its dBFS markings. The
+// scale is linear between the points.
+var meterScale = []struct {
+	frac, db float64
+}{
+	{0.0, -60},
+	{0.2, -40},
+	{0.4, -24},
+	{0.6, -12},
+	{0.8, -6},
+	{1.0, 0},
+}
+
+// litHeight returns the height of the lit meter segments in column x, between
+// rows y0 (top) and y1 (bottom, exclusive). Segments are lit from the bottom
+// up, and a detached peak-hold indicator is ignored.
+func litHeight(s sampler, x, y0, y1 int) int {
+	top, gap := y1, 0
+	for y := y1 - 1; y >= y0; y-- {
+		if luma(s.RGBAAt(x, y)) < meterLuma {
+			gap++
+			if gap > meterGap {
+				break
+			}
+			continue
+		}
+		top, gap = y, 0
+	}
+	return y1 - top
+}
+
+// meterLevel converts the lit fraction of a meter into dBFS.
+func meterLevel(frac float64) float64 {
+	if frac <= 0 {
+		return math.Inf(-1)
+	}
+	for i := 1; i < len(meterScale); i++ {
+		lo, hi := meterScale[i-1], meterScale[i]
+		if frac <= hi.frac {
+			return lo.db + (frac-lo.frac)/(hi.frac-lo.frac)*(hi.db-lo.db)
+		}
+	}
+	return meterScale[len(meterScale)-1].db
+}
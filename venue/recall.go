@@ -0,0 +1,278 @@
+package venue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+/*
+A console state is recalled by driving VENUE from the modelled state to the
+target state, one input at a time. Each input that differs is recalled in a
+single workflow: the input is selected and read back once VENUE shows it, so
+that the changes start from what VENUE shows, and then:
+
+  - encoders are adjusted by the number of steps between the values, and
+  - switches are toggled and confirmed on the framebuffer.
+
+Controls that cannot be driven from the INPUTS page are reported as failures,
+as are output changes. Solos are left alone, as soloing is exclusive and
+transient.
+*/
+
+// RecallProgress reports the progress of a recall, after each input.
+type RecallProgress struct {
+	Input       signals.SignalNo // Input just recalled.
+	Done, Total int              // Inputs recalled, and to recall.
+}
+
+// RecallFailure is a control that could not be recalled.
+type RecallFailure struct {
+	Signal  string // Input or output name, e.g. "Input 3" or "Aux 5".
+	Control string // Empty when the whole signal failed.
+	Err     error
+}
+
+// Error implements the error interface.
+func (f RecallFailure) Error() string {
+	if f.Control == "" {
+		return fmt.Sprintf("%s: %s", f.Signal, f.Err)
+	}
+	return fmt.Sprintf("%s %s: %s", f.Signal, f.Control, f.Err)
+}
+
+// recallChange is a change of an input control.
+type recallChange struct {
+	control string
+	steps   int  // Encoder steps.
+	toggle  bool // Whether the control is a switch.
+	on      bool // Switch state.
+}
+
+// planRecall returns the changes driving input i to state `is` from the widgets
+// of page, and the controls that cannot be driven. Changes are ordered by
+// control name.
+func planRecall(i *Input, is InputState, page *Page) ([]recallChange, []RecallFailure) {
+	name := fmt.Sprintf("%s %d", signals.Input, i.sigNo)
+	want := map[string]SignalState{}
+	for n, st := range is.Prop {
+		want[n] = st
+	}
+	for n, st := range is.Sends {
+		want[n] = st
+	}
+	var names []string
+	for n := range want {
+		if n != "Solo" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	var (
+		cs []recallChange
+		fs []RecallFailure
+	)
+	for _, n := range names {
+		sig, err := i.Control(n)
+		if err != nil {
+			fs = append(fs, RecallFailure{name, n, err})
+			continue
+		}
+		st := want[n]
+		valDiff, enaDiff := float64(st.Value) != sig.val, st.Enabled != sig.ena
+		if !valDiff && !enaDiff {
+			continue
+		}
+		w, err := page.Widget(n)
+		if err != nil {
+			fs = append(fs, RecallFailure{name, n, venuelib.Errorf(codes.Unimplemented, "no %s page widget", page.page)})
+			continue
+		}
+		switch w := w.(type) {
+		case *Encoder:
+			if enaDiff {
+				fs = append(fs, RecallFailure{name, n, venuelib.Errorf(codes.Unimplemented, "unable to turn an encoder on or off")})
+			}
+			if steps := sig.stepsTo(float64(st.Value)); steps != 0 {
+				cs = append(cs, recallChange{control: n, steps: steps})
+			}
+		case *Switch:
+			if w.IsPushButton() {
+				fs = append(fs, RecallFailure{name, n, venuelib.Errorf(codes.Unimplemented, "unable to set a push button")})
+				continue
+			}
+			if enaDiff {
+				cs = append(cs, recallChange{control: n, toggle: true, on: st.Enabled})
+			}
+		default:
+			fs = append(fs, RecallFailure{name, n, venuelib.Errorf(codes.Unimplemented, "unable to set a %T", w)})
+		}
+	}
+	return cs, fs
+}
+
+// Recall drives VENUE to console state `target`. Controls missing from the
+// target are left unchanged. Progress, when not nil, is called after each
+// input. Controls that could not be recalled are returned; an error is
+// returned only should the target be invalid, or ctx be done.
+func (v *Venue) Recall(ctx context.Context, target *State, progress func(RecallProgress)) ([]RecallFailure, error) {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+
+	// Only the inputs with changes from the model are selected.
+	v.model.Lock()
+	if err := v.checkState(target); err != nil {
+		v.model.Unlock()
+		return nil, err
+	}
+	var (
+		iss []InputState
+		fs  []RecallFailure
+	)
+	for _, is := range target.Inputs {
+		cs, ifs := planRecall(v.inputs[is.Input-1], is, v.currentUI().pages[pages.Inputs])
+		if len(cs) > 0 {
+			iss = append(iss, is)
+			continue
+		}
+		fs = append(fs, ifs...)
+	}
+	for _, ost := range target.Outputs {
+		for _, d := range DiffStates(
+			&State{Outputs: []OutputState{{ost.Output, signalStates(v.outputs[ost.Output].prop)}}},
+			&State{Outputs: []OutputState{ost}}) {
+			if d.Control != "Solo" {
+				fs = append(fs, RecallFailure{d.Signal, d.Control, venuelib.Errorf(codes.Unimplemented, "unable to recall outputs")})
+			}
+		}
+	}
+	v.model.Unlock()
+	sort.Slice(iss, func(i, j int) bool { return iss[i].Input < iss[j].Input })
+
+	for n, is := range iss {
+		if err := ctx.Err(); err != nil {
+			return fs, err
+		}
+		ifs, err := v.recallInput(ctx, is)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fs, ctx.Err()
+			}
+			ifs = append(ifs, RecallFailure{Signal: fmt.Sprintf("%s %d", signals.Input, is.Input), Err: err})
+		}
+		fs = append(fs, ifs...)
+		if progress != nil {
+			progress(RecallProgress{is.Input, n + 1, len(iss)})
+		}
+	}
+
+	if target.Selected != 0 && target.Selected != v.SelectedInput() {
+		if err := SelectInput(v, &router.Packet{Signal: signals.Input, SignalNo: target.Selected}); err != nil {
+			fs = append(fs, RecallFailure{Signal: fmt.Sprintf("%s %d", signals.Input, target.Selected), Err: err})
+		}
+	}
+	return fs, nil
+}
+
+// recallInput selects input `is.Input`, and drives it to state `is`. The
+// input is recalled in a single workflow, so that no other workflow acts on
+// VENUE between the selection and the changes.
+func (v *Venue) recallInput(ctx context.Context, is InputState) ([]RecallFailure, error) {
+	if glog.V(2) {
+		glog.Infof("Recalling input #%d.", is.Input)
+	}
+	ui := v.currentUI()
+	page, ok := ui.pages[pages.Inputs]
+	if !ok {
+		return nil, venuelib.Errorf(codes.Unimplemented, "support for %q page unimplemented", pages.Inputs)
+	}
+	wf := v.newWorkflow()
+	if err := v.selectInputEvents(wf, is.Input); err != nil {
+		return nil, err
+	}
+	// Give VENUE time to show the selected input.
+	wf.Sleep(v.verifyTimeout())
+
+	// The changes are planned once the input is shown, starting from what
+	// VENUE shows, and falling back on the estimates.
+	var (
+		cs      []recallChange
+		fs      []RecallFailure
+		planned bool
+	)
+	timeout := v.verifyTimeout()
+	wf.Expand(fmt.Sprintf("recall input %d", is.Input), func(x *vnc.Workflow) error {
+		s, err := framebuffer(x)
+		v.model.Lock()
+		i := v.inputs[is.Input-1]
+		if err != nil {
+			glog.Warningf("Recalling input %d from estimates; %s", is.Input, err)
+		} else {
+			i.confirm(page, s)
+		}
+		cs, fs = planRecall(i, is, page)
+		v.model.Unlock()
+		planned = true
+
+		tf := x.Transform()
+		for _, c := range cs {
+			w, err := page.Widget(c.control)
+			if err != nil {
+				return err
+			}
+			if !c.toggle {
+				if err := w.(*Encoder).Adjust(x, c.steps); err != nil {
+					return err
+				}
+				continue
+			}
+			sw := w.(*Switch)
+			if err := sw.Press(x); err != nil {
+				return err
+			}
+			on := c.on
+			x.WaitFor(fmt.Sprintf("the %s/%s switch", pages.Inputs, c.control), sw.bounds(), func(s *vnc.Snapshot) bool {
+				got, err := sw.read(sample(s, tf))
+				return err == nil && got == on
+			}, timeout)
+		}
+		return nil
+	})
+
+	if err := v.executeCtx(ctx, wf, vnc.Normal); err != nil {
+		if !planned {
+			v.selectInput(0) // The selected input is no longer known.
+			return nil, err
+		}
+		v.selectInput(is.Input)
+		name := fmt.Sprintf("%s %d", signals.Input, is.Input)
+		for _, c := range cs {
+			fs = append(fs, RecallFailure{name, c.control, err})
+		}
+		return fs, nil
+	}
+	v.selectInput(is.Input)
+	for _, c := range cs {
+		if !c.toggle {
+			v.updateInput(c.control, func(sig *Signal) { sig.Step(c.steps) })
+			continue
+		}
+		w, _ := page.Widget(c.control)
+		ui.recordSwitch(w.(*Switch), c.on)
+		v.updateInput(c.control, func(sig *Signal) {
+			sig.SetEnabled(c.on)
+			sig.Confirm()
+		})
+	}
+	return fs, nil
+}
@@ -0,0 +1,122 @@
+package venue
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+func TestSignalStepsTo(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		sig  *Signal
+		from float64
+		to   float64
+		want int
+	}{
+		{"same", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, 20, 0},
+		{"up", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, 24, 4},
+		{"down", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, 11, -9},
+		{"clamped", NewSignal(10, 10, 60, 1, 1, "dB", true), 20, 80, 40},
+		{"half steps", NewSignal(0, -10, 10, 0.5, 1, "dB", true), 0, -1.5, -3},
+		{"up from -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), math.Inf(-1), levelFloor + 2, 3},
+		{"down to -inf", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), levelFloor + 1, math.Inf(-1), -2},
+		{"finite", NewSignal(auxDef, auxMin, auxMax, 1, 1, "dB", true), -20, -5, 15},
+		{"switch", newSwitch(), 0, 0, 0},
+	} {
+		tt.sig.Set(tt.from)
+		got := tt.sig.stepsTo(tt.to)
+		if want := tt.want; got != want {
+			t.Errorf("%s: stepsTo(%v) from %v = %d, want %d", tt.desc, tt.to, tt.from, got, want)
+			continue
+		}
+		// Stepping must land on the target.
+		if tt.sig.step == 0 {
+			continue
+		}
+		tt.sig.Step(got)
+		if got, want := tt.sig.Value(), math.Max(tt.sig.min, math.Min(tt.sig.max, tt.to)); got != want {
+			t.Errorf("%s: Step(%d) from %v = %v, want %v", tt.desc, tt.want, tt.from, got, want)
+		}
+	}
+}
+
+func TestPlanRecall(t *testing.T) {
+	page := defaultUI(t).pages[pages.Inputs]
+	i := NewInput(signals.Input, 2, buses.Default())
+	i.prop["Gain"].Set(20)
+	i.prop["Mute"].SetEnabled(true)
+
+	cs, fs := planRecall(i, InputState{
+		Input: 2,
+		Prop: map[string]SignalState{
+			"Gain":  {Value: 24, Enabled: true},
+			"HPF":   {Value: 100, Enabled: true}, // Unchanged.
+			"Mute":  {Value: 0, Enabled: false},
+			"Phase": {Value: 0, Enabled: false}, // Unchanged.
+			"Solo":  {Value: 0, Enabled: true},  // Never recalled.
+			"Fader": {Value: 0, Enabled: true},  // No widget.
+			"Delay": {Value: 0, Enabled: true},  // Encoders cannot be turned on.
+		},
+		Sends: map[string]SignalState{
+			"Aux 3": {Value: StateValue(math.Inf(-1)), Enabled: true},
+			"Aux 2": {Value: -10, Enabled: true}, // No widget.
+		},
+	}, page)
+
+	if got, want := cs, []recallChange{
+		{control: "Aux 3", steps: -81},
+		{control: "Gain", steps: 8},
+		{control: "Mute", toggle: true, on: false},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("planRecall() changes = %+v, want %+v", got, want)
+	}
+	var got []string
+	for _, f := range fs {
+		if venuelib.Code(f.Err) != codes.Unimplemented {
+			t.Errorf("%s: code = %s, want %s", f.Control, venuelib.Code(f.Err), codes.Unimplemented)
+		}
+		got = append(got, f.Control)
+	}
+	if want := []string{"Aux 2", "Delay", "Fader"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("planRecall() failures = %v, want %v", got, want)
+	}
+}
+
+func TestRecallOffline(t *testing.T) {
+	v := newModelVenue(t)
+
+	// Invalid targets are rejected before anything is changed.
+	if _, err := v.Recall(context.Background(), &State{Version: stateVersion, Inputs: []InputState{{Input: 49}}}, nil); venuelib.Code(err) != codes.InvalidArgument {
+		t.Errorf("Recall(invalid) error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	// Changes that cannot be driven are reported without touching VENUE.
+	fs, err := v.Recall(context.Background(), &State{
+		Version: stateVersion,
+		Inputs: []InputState{
+			{Input: 1, Prop: map[string]SignalState{"Gain": {Value: 10, Enabled: true}}}, // Unchanged.
+			{Input: 4, Sends: map[string]SignalState{"Aux 2": {Value: -10, Enabled: true}}},
+		},
+		Outputs: []OutputState{
+			{Output: "Aux 1", Prop: map[string]SignalState{"Mute": {Value: 0, Enabled: true}, "Solo": {Value: 0, Enabled: true}}},
+		},
+	}, func(RecallProgress) { t.Error("unexpected progress") })
+	if err != nil {
+		t.Fatalf("Recall() unexpected error; %s", err)
+	}
+	var got []string
+	for _, f := range fs {
+		got = append(got, f.Signal+" "+f.Control)
+	}
+	if want := []string{"Input 4 Aux 2", "Aux 1 Mute"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Recall() failures = %v, want %v", got, want)
+	}
+}
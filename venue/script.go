@@ -0,0 +1,190 @@
+package venue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/pages"
+)
+
+/*
+Workflow scripts (.vwf) automate VENUE UI sequences. Each line holds a single
+command, and '#' starts a comment. Points are given for the 1024x768 reference
+screen, and are scaled to the VENUE resolution.
+
+	page Inputs              # Select a page, unless displayed when run.
+	click Inputs/SoloClear   # Click a named page widget.
+	click 932,524            # Click a point.
+	drag 100,200 0,-20       # Drag from a point by an offset.
+	key F2 F1                # Press and release keys.
+	type 01                  # Type text.
+	sleep 1750ms             # Pause.
+*/
+
+// ParseScript parses a workflow script, appending its events to workflow wf.
+// Named widgets are resolved through the pages of ui. Pages are only selected
+// as the workflow executes, so parsing neither reads nor changes the UI state.
+func ParseScript(r io.Reader, ui *UI, wf *vnc.Workflow) error {
+	s := bufio.NewScanner(r)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		arg = strings.TrimSpace(arg)
+		if cmd == "" {
+			continue
+		}
+		if err := parseCommand(ui, wf, cmd, arg); err != nil {
+			return venuelib.Errorf(venuelib.Code(err), "line %d: %s", lineNo, venuelib.ErrorDesc(err))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return venuelib.Errorf(codes.Internal, "error reading script; %s", err)
+	}
+	return nil
+}
+
+// parseCommand appends the events of a single script command to workflow wf.
+func parseCommand(ui *UI, wf *vnc.Workflow, cmd, arg string) error {
+	switch cmd {
+	case "page":
+		p, err := parsePage(arg)
+		if err != nil {
+			return err
+		}
+		_, err = ui.selectPage(wf, p)
+		return err
+
+	case "click":
+		if p, err := parsePoint(arg); err == nil {
+			wf.MouseClick(buttons.Left, p)
+			return nil
+		}
+		w, err := parseWidget(ui, arg)
+		if err != nil {
+			return err
+		}
+		return w.Press(wf)
+
+	case "drag":
+		from, by, ok := strings.Cut(arg, " ")
+		if !ok {
+			return venuelib.Errorf(codes.InvalidArgument, "drag requires a point and an offset")
+		}
+		p, err := parsePoint(from)
+		if err != nil {
+			return err
+		}
+		d, err := parsePoint(strings.TrimSpace(by))
+		if err != nil {
+			return err
+		}
+		wf.MouseDrag(p, d)
+		return nil
+
+	case "key":
+		for _, name := range strings.Fields(arg) {
+			k, err := parseKey(name)
+			if err != nil {
+				return err
+			}
+			wf.KeyPress(k)
+		}
+		return nil
+
+	case "type":
+		ks, err := keys.TextToKeys(arg)
+		if err != nil {
+			return venuelib.Errorf(codes.InvalidArgument, "invalid text %q; %s", arg, err)
+		}
+		for _, k := range ks {
+			wf.KeyPress(k)
+		}
+		return nil
+
+	case "sleep":
+		d, err := time.ParseDuration(arg)
+		if err != nil || d < 0 {
+			return venuelib.Errorf(codes.InvalidArgument, "invalid sleep duration %q", arg)
+		}
+		wf.Sleep(d)
+		return nil
+	}
+	return venuelib.Errorf(codes.InvalidArgument, "unknown command %q", cmd)
+}
+
+// parsePage parses a case-insensitive page name.
+func parsePage(s string) (pages.Page, error) {
+	return parseName("page", s, pages.Options)
+}
+
+// parsePoint parses a point of the form "x,y".
+func parsePoint(s string) (image.Point, error) {
+	var p image.Point
+	if _, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y); err != nil || fmt.Sprintf("%d,%d", p.X, p.Y) != s {
+		return image.Point{}, venuelib.Errorf(codes.InvalidArgument, "invalid point %q", s)
+	}
+	return p, nil
+}
+
+// parseWidget resolves a widget of the form "Page/Widget".
+func parseWidget(ui *UI, s string) (Widget, error) {
+	name, widget, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "invalid widget %q; expected Page/Widget", s)
+	}
+	p, err := parsePage(name)
+	if err != nil {
+		return nil, err
+	}
+	page, ok := ui.pages[p]
+	if !ok {
+		return nil, venuelib.Errorf(codes.Unimplemented, "support for %q page unimplemented", p)
+	}
+	w, err := page.Widget(widget)
+	if err != nil {
+		return nil, venuelib.Errorf(codes.NotFound, "unknown %s page widget %q", p, widget)
+	}
+	return w, nil
+}
+
+var (
+	keyNamesOnce sync.Once
+	keyNames     map[string]keys.Key
+)
+
+// parseKey returns the key with the given name, e.g. "F1" or "Return".
+func parseKey(name string) (keys.Key, error) {
+	keyNamesOnce.Do(func() {
+		keyNames = map[string]keys.Key{}
+		for k := keys.Key(0); k <= 0xffff; k++ {
+			if s := k.String(); !strings.HasPrefix(s, "Key(") {
+				keyNames[s] = k
+			}
+		}
+	})
+	k, ok := keyNames[name]
+	if !ok {
+		return 0, venuelib.Errorf(codes.InvalidArgument, "unknown key %q", name)
+	}
+	return k, nil
+}
+
+// RunScript parses a workflow script, and executes it.
+func (v *Venue) RunScript(ctx context.Context, r io.Reader) error {
+	wf := v.newWorkflow()
+	if err := ParseScript(r, v.currentUI(), wf); err != nil {
+		return err
+	}
+	return v.executeCtx(ctx, wf, vnc.Normal)
+}
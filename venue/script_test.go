@@ -0,0 +1,80 @@
+package venue
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+func TestParseScript(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		script string
+		events []string
+		code   codes.Code
+	}{
+		{"empty", "# Nothing to do.\n\n", nil, codes.OK},
+		{"key", "key F1 Return", []string{
+			"press F1", "release F1", "press Return", "release Return"}, codes.OK},
+		{"type", "type 1a", []string{
+			"press Digit1", "release Digit1", "press SmallA", "release SmallA"}, codes.OK},
+		{"click point", "click 10,20  # Comment.", []string{
+			"move mouse to (10,20)", "Left button click at (10,20)", "Left button release"}, codes.OK},
+		{"click widget", "click inputs/Mute", []string{
+			"move mouse to (78,460)", "Left button click at (78,460)", "Left button release"}, codes.OK},
+		{"drag", "drag 10,20 5,-5", []string{
+			"move mouse to (10,20)", "Left button click at (10,20)", "mouse drag to (15,15)", "Left button release"}, codes.OK},
+		{"sleep", "sleep 1.5s", []string{"sleep for 1.5s"}, codes.OK},
+		{"page", "page Outputs", []string{"select the Outputs page"}, codes.OK},
+		{"unknown command", "key F1\njump 1", nil, codes.InvalidArgument},
+		{"unknown key", "key Hyper", nil, codes.InvalidArgument},
+		{"unknown page", "page Mixer", nil, codes.InvalidArgument},
+		{"unimplemented page", "click Filing/Load", nil, codes.Unimplemented},
+		{"unknown widget", "click Inputs/Flanger", nil, codes.NotFound},
+		{"invalid point", "click 10,20,30", nil, codes.InvalidArgument},
+		{"missing drag offset", "drag 10,20", nil, codes.InvalidArgument},
+		{"invalid sleep", "sleep soon", nil, codes.InvalidArgument},
+	} {
+		wf := vnc.NewWorkflow(&mockConn{})
+		err := ParseScript(strings.NewReader(tt.script), defaultUI(t), wf)
+		if got, want := venuelib.Code(err), tt.code; got != want {
+			t.Errorf("%s: ParseScript() error code = %s, want %s; %v", tt.desc, got, want, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var got []string
+		for _, e := range wf.Events() {
+			got = append(got, e.String())
+		}
+		if want := tt.events; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: events = %q, want %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestParseScriptPage(t *testing.T) {
+	ui := defaultUI(t)
+	wf := vnc.NewWorkflow(&mockConn{})
+	if err := ParseScript(strings.NewReader("page Outputs\npage Inputs\n"), ui, wf); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if p, ok := ui.lastPage(); ok {
+		t.Errorf("parsing selected the %s page", p)
+	}
+	if got, want := wf.Len(), 2; got != want {
+		t.Errorf("events = %d, want %d", got, want)
+	}
+}
+
+func TestParseScriptLineNumber(t *testing.T) {
+	err := ParseScript(strings.NewReader("key F1\n\njump\n"), defaultUI(t), vnc.NewWorkflow(&mockConn{}))
+	if got, want := venuelib.ErrorDesc(err), `line 3: unknown command "jump"`; got != want {
+		t.Errorf("ParseScript() error = %q, want %q", got, want)
+	}
+}
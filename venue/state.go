@@ -0,0 +1,357 @@
+package venue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+/*
+The console model is saved as a versioned JSON state, so that it survives
+restarts, and so that states can be compared.
+
+	{
+	  "version": 1,
+	  "selected": 3,
+	  "inputs": [{
+	    "input": 1,
+	    "prop": {"Gain": {"value": 24, "enabled": true}, ...},
+	    "sends": {"Aux 1": {"value": "-inf", "enabled": true, "estimated": true}, ...}
+	  }, ...],
+	  "outputs": [{
+	    "output": "Aux 1",
+	    "prop": {"Mute": {"value": 0, "enabled": true}, ...}
+	  }, ...]
+	}
+
+Infinite values, which JSON numbers cannot hold, are given as "-inf" or "+inf".
+Loaded values are estimates, as VENUE may have changed since they were saved.
+*/
+
+// stateVersion is the state file version understood by this package.
+const stateVersion = 1
+
+// State is a snapshot of the console model.
+type State struct {
+	Version  int              `json:"version"`
+	Selected signals.SignalNo `json:"selected,omitempty"` // Selected input.
+	Inputs   []InputState     `json:"inputs"`
+	Outputs  []OutputState    `json:"outputs"`
+}
+
+// InputState is the state of an input.
+type InputState struct {
+	Input signals.SignalNo       `json:"input"`
+	Prop  map[string]SignalState `json:"prop"`
+	Sends map[string]SignalState `json:"sends"`
+}
+
+// OutputState is the state of an output.
+type OutputState struct {
+	Output string                 `json:"output"` // Output name, e.g. "Aux 5".
+	Prop   map[string]SignalState `json:"prop"`
+}
+
+// SignalState is the state of a console control.
+type SignalState struct {
+	Value     StateValue `json:"value"`
+	Enabled   bool       `json:"enabled"`
+	Estimated bool       `json:"estimated,omitempty"`
+}
+
+// StateValue is a control value, which may be infinite.
+type StateValue float64
+
+// MarshalJSON implements json.Marshaler.
+func (v StateValue) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(v), -1):
+		return []byte(`"-inf"`), nil
+	case math.IsInf(float64(v), 1):
+		return []byte(`"+inf"`), nil
+	}
+	return json.Marshal(float64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *StateValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "-inf":
+			*v = StateValue(math.Inf(-1))
+		case "inf", "+inf":
+			*v = StateValue(math.Inf(1))
+		default:
+			return fmt.Errorf("invalid value %q", s)
+		}
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("invalid value %s", b)
+	}
+	*v = StateValue(f)
+	return nil
+}
+
+// signalStates returns the states of signals sigs.
+func signalStates(sigs Signals) map[string]SignalState {
+	ss := make(map[string]SignalState, len(sigs))
+	for n, sig := range sigs {
+		ss[n] = SignalState{StateValue(sig.val), sig.ena, sig.estimated}
+	}
+	return ss
+}
+
+// checkStates returns an error unless every state names a signal of sigs, with
+// a value in range. What describes the signals in errors.
+func checkStates(what string, sigs Signals, ss map[string]SignalState) error {
+	for n, st := range ss {
+		sig, ok := sigs[n]
+		if !ok {
+			return venuelib.Errorf(codes.InvalidArgument, "%s has no %q control", what, n)
+		}
+		if v := float64(st.Value); v < sig.min || v > sig.max {
+			return venuelib.Errorf(codes.InvalidArgument, "%s %s value %v is outside %v..%v", what, n, v, sig.min, sig.max)
+		}
+	}
+	return nil
+}
+
+// setStates sets signals sigs to their states. Values are estimates.
+func setStates(sigs Signals, ss map[string]SignalState) {
+	for n, st := range ss {
+		sig := sigs[n]
+		sig.Set(float64(st.Value))
+		sig.SetEnabled(st.Enabled)
+	}
+}
+
+// State returns a snapshot of the console model.
+func (v *Venue) State() *State {
+	v.model.Lock()
+	defer v.model.Unlock()
+	st := &State{Version: stateVersion, Selected: v.input}
+	for _, i := range v.inputs {
+		st.Inputs = append(st.Inputs, InputState{i.sigNo, signalStates(i.prop), signalStates(i.sends)})
+	}
+	for _, c := range outputCounts(v.opts.buses) {
+		for n := 1; n <= c.num; n++ {
+			o := v.outputs[outputName(c.sig, signals.SignalNo(n))]
+			st.Outputs = append(st.Outputs, OutputState{o.Name(), signalStates(o.prop)})
+		}
+	}
+	return st
+}
+
+// SetState sets the console model to state st. The state need not hold every
+// input, output or control; those missing are left unchanged. Nothing is
+// changed should the state be invalid.
+func (v *Venue) SetState(st *State) error {
+	v.model.Lock()
+	defer v.model.Unlock()
+	if err := v.checkState(st); err != nil {
+		return err
+	}
+	if st.Selected != 0 {
+		v.input = st.Selected
+	}
+	for _, is := range st.Inputs {
+		i := v.inputs[is.Input-1]
+		setStates(i.prop, is.Prop)
+		setStates(i.sends, is.Sends)
+	}
+	for _, ost := range st.Outputs {
+		setStates(v.outputs[ost.Output].prop, ost.Prop)
+	}
+	return nil
+}
+
+// checkState returns an error unless state st is valid for the console model.
+// The model lock must be held.
+func (v *Venue) checkState(st *State) error {
+	if st.Version != stateVersion {
+		return venuelib.Errorf(codes.InvalidArgument, "unsupported state version %d; want %d", st.Version, stateVersion)
+	}
+	if st.Selected < 0 || st.Selected > numInputs {
+		return venuelib.Errorf(codes.InvalidArgument, "selected input %d is not modelled", st.Selected)
+	}
+
+	seen := map[string]bool{}
+	for _, is := range st.Inputs {
+		if is.Input < 1 || is.Input > numInputs {
+			return venuelib.Errorf(codes.InvalidArgument, "input %d is not modelled", is.Input)
+		}
+		what := fmt.Sprintf("input %d", is.Input)
+		if seen[what] {
+			return venuelib.Errorf(codes.InvalidArgument, "duplicate %s", what)
+		}
+		seen[what] = true
+		i := v.inputs[is.Input-1]
+		if err := checkStates(what, i.prop, is.Prop); err != nil {
+			return err
+		}
+		if err := checkStates(what, i.sends, is.Sends); err != nil {
+			return err
+		}
+	}
+	for _, ost := range st.Outputs {
+		o, ok := v.outputs[ost.Output]
+		if !ok {
+			return venuelib.Errorf(codes.InvalidArgument, "unknown output %q", ost.Output)
+		}
+		if seen[ost.Output] {
+			return venuelib.Errorf(codes.InvalidArgument, "duplicate output %q", ost.Output)
+		}
+		seen[ost.Output] = true
+		if err := checkStates(ost.Output, o.prop, ost.Prop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseState parses a JSON console state.
+func ParseState(r io.Reader) (*State, error) {
+	st := &State{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(st); err != nil {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "invalid state; %s", err)
+	}
+	if st.Version != stateVersion {
+		return nil, venuelib.Errorf(codes.InvalidArgument, "unsupported state version %d; want %d", st.Version, stateVersion)
+	}
+	return st, nil
+}
+
+// SaveState writes the console model to w as JSON.
+func (v *Venue) SaveState(w io.Writer) error {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v.State()); err != nil {
+		return venuelib.Errorf(codes.Internal, "unable to save state; %s", err)
+	}
+	return nil
+}
+
+// LoadState sets the console model to the JSON state read from r.
+func (v *Venue) LoadState(r io.Reader) error {
+	if glog.V(3) {
+		glog.Infof("Venue.%s", venuelib.FnName())
+	}
+	st, err := ParseState(r)
+	if err != nil {
+		return err
+	}
+	return v.SetState(st)
+}
+
+// StateHandler returns an HTTP handler serving the console model, so that the
+// model of a running server can be saved and loaded. GET returns the JSON
+// state, and PUT sets the model to the JSON state of the request body. The
+// selected input of the loaded state is ignored, as loading leaves VENUE as it
+// is; the model keeps the input VENUE shows selected.
+func (v *Venue) StateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var buf bytes.Buffer
+			if err := v.SaveState(&buf); err != nil {
+				http.Error(w, venuelib.ErrorDesc(err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(buf.Bytes())
+		case http.MethodPut:
+			st, err := ParseState(r.Body)
+			if err == nil {
+				st.Selected = 0
+				err = v.SetState(st)
+			}
+			if err != nil {
+				http.Error(w, venuelib.ErrorDesc(err), http.StatusBadRequest)
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// StateDiff is a control that differs between two states.
+type StateDiff struct {
+	Signal   string // Input or output name, e.g. "Input 3" or "Aux 5".
+	Control  string
+	From, To SignalState
+}
+
+// String returns a human readable representation of the difference.
+func (d StateDiff) String() string {
+	return fmt.Sprintf("%s %s: %s -> %s", d.Signal, d.Control, d.From, d.To)
+}
+
+// String returns a human readable representation of the state.
+func (s SignalState) String() string {
+	if s.Enabled {
+		return fmt.Sprintf("%g (on)", float64(s.Value))
+	}
+	return fmt.Sprintf("%g (off)", float64(s.Value))
+}
+
+// DiffStates returns the controls whose value or enabled state differ between
+// states from and to, in the signal order of state to, and by control name.
+// Controls missing from either state are not compared.
+func DiffStates(from, to *State) []StateDiff {
+	var ds []StateDiff
+	diff := func(name string, a, b map[string]SignalState) {
+		var names []string
+		for n := range b {
+			if _, ok := a[n]; ok {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if a[n].Value != b[n].Value || a[n].Enabled != b[n].Enabled {
+				ds = append(ds, StateDiff{name, n, a[n], b[n]})
+			}
+		}
+	}
+
+	inputs := map[signals.SignalNo]InputState{}
+	for _, is := range from.Inputs {
+		inputs[is.Input] = is
+	}
+	for _, is := range to.Inputs {
+		if fi, ok := inputs[is.Input]; ok {
+			name := fmt.Sprintf("%s %d", signals.Input, is.Input)
+			diff(name, fi.Prop, is.Prop)
+			diff(name, fi.Sends, is.Sends)
+		}
+	}
+	outputs := map[string]OutputState{}
+	for _, ost := range from.Outputs {
+		outputs[ost.Output] = ost
+	}
+	for _, ost := range to.Outputs {
+		if fo, ok := outputs[ost.Output]; ok {
+			diff(ost.Output, fo.Prop, ost.Prop)
+		}
+	}
+	return ds
+}
@@ -0,0 +1,231 @@
+package venue
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/router/signals"
+	"github.com/kward/venue/internal/venuelib"
+)
+
+func TestStateValueJSON(t *testing.T) {
+	for _, tt := range []struct {
+		v    StateValue
+		json string
+	}{
+		{StateValue(-12.5), `-12.5`},
+		{StateValue(0), `0`},
+		{StateValue(math.Inf(-1)), `"-inf"`},
+		{StateValue(math.Inf(1)), `"+inf"`},
+	} {
+		b, err := tt.v.MarshalJSON()
+		if err != nil {
+			t.Errorf("%v: MarshalJSON() unexpected error; %s", tt.v, err)
+			continue
+		}
+		if got, want := string(b), tt.json; got != want {
+			t.Errorf("%v: MarshalJSON() = %s, want %s", tt.v, got, want)
+		}
+		var v StateValue
+		if err := v.UnmarshalJSON(b); err != nil {
+			t.Errorf("%s: UnmarshalJSON() unexpected error; %s", tt.json, err)
+			continue
+		}
+		if got, want := v, tt.v; got != want {
+			t.Errorf("%s: UnmarshalJSON() = %v, want %v", tt.json, got, want)
+		}
+	}
+	for _, s := range []string{`"inf"`, `"-INF"`} {
+		var v StateValue
+		if err := v.UnmarshalJSON([]byte(s)); err != nil || !math.IsInf(float64(v), 0) {
+			t.Errorf("%s: UnmarshalJSON() = %v, %v; want an infinity", s, v, err)
+		}
+	}
+	for _, s := range []string{`"loud"`, `true`} {
+		var v StateValue
+		if err := v.UnmarshalJSON([]byte(s)); err == nil {
+			t.Errorf("%s: UnmarshalJSON() expected an error", s)
+		}
+	}
+}
+
+func TestSaveLoadState(t *testing.T) {
+	v := newModelVenue(t)
+	v.selectInput(3)
+	v.updateInput("Gain", func(sig *Signal) { sig.Set(32) })
+	v.updateInput("Aux 5", func(sig *Signal) { sig.Set(math.Inf(-1)) })
+	v.updateInput("Mute", func(sig *Signal) {
+		sig.SetEnabled(true)
+		sig.Confirm()
+	})
+	if err := v.soloOutput(signals.Aux, 5); err != nil {
+		t.Fatalf("soloOutput() unexpected error; %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := v.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState() unexpected error; %s", err)
+	}
+	if !strings.Contains(buf.String(), `"value": "-inf"`) {
+		t.Errorf("SaveState() did not save -inf as a string:\n%s", buf.String())
+	}
+
+	loaded := newModelVenue(t)
+	if err := loaded.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("LoadState() unexpected error; %s", err)
+	}
+	if got, want := loaded.SelectedInput(), signals.SignalNo(3); got != want {
+		t.Errorf("selected input = %d, want %d", got, want)
+	}
+	if ds := DiffStates(v.State(), loaded.State()); len(ds) != 0 {
+		t.Errorf("loaded state differs from the saved state; %v", ds)
+	}
+	i, err := loaded.Input(3)
+	if err != nil {
+		t.Fatalf("Input() unexpected error; %s", err)
+	}
+	if sig, _ := i.Control("Mute"); !sig.Enabled() || !sig.Estimated() {
+		t.Errorf("loaded mute enabled = %t, estimated = %t; want true, true", sig.Enabled(), sig.Estimated())
+	}
+	if o, _ := loaded.Output(signals.Aux, 5); !o.Soloed() {
+		t.Error("loaded Aux 5 not soloed")
+	}
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		json string
+	}{
+		{"malformed", `{"version": 1,`},
+		{"unknown field", `{"version": 1, "mixes": []}`},
+		{"version", `{"version": 2}`},
+		{"selected", `{"version": 1, "selected": 49}`},
+		{"input", `{"version": 1, "inputs": [{"input": 0}]}`},
+		{"duplicate input", `{"version": 1, "inputs": [{"input": 1}, {"input": 1}]}`},
+		{"control", `{"version": 1, "inputs": [{"input": 1, "prop": {"Volume": {"value": 1}}}]}`},
+		{"range", `{"version": 1, "inputs": [{"input": 1, "prop": {"Gain": {"value": 70}}}]}`},
+		{"output", `{"version": 1, "outputs": [{"output": "Aux 17"}]}`},
+		{"value", `{"version": 1, "outputs": [{"output": "Aux 1", "prop": {"Mute": {"value": "loud"}}}]}`},
+		// The first input is valid, so that nothing changing is checked.
+		{"partial", `{"version": 1, "inputs": [{"input": 1, "prop": {"Gain": {"value": 40}}}, {"input": 99}]}`},
+	} {
+		v := newModelVenue(t)
+		err := v.LoadState(strings.NewReader(tt.json))
+		if got, want := venuelib.Code(err), codes.InvalidArgument; got != want {
+			t.Errorf("%s: LoadState() error = %v, want %s", tt.desc, err, want)
+		}
+		if ds := DiffStates(newModelVenue(t).State(), v.State()); len(ds) != 0 {
+			t.Errorf("%s: invalid state changed the model; %v", tt.desc, ds)
+		}
+	}
+}
+
+func TestLoadStatePartial(t *testing.T) {
+	v := newModelVenue(t)
+	err := v.LoadState(strings.NewReader(`{"version": 1, "inputs": [{"input": 2, "sends": {"Aux 3": {"value": -6, "enabled": true}}}]}`))
+	if err != nil {
+		t.Fatalf("LoadState() unexpected error; %s", err)
+	}
+	want := []StateDiff{{"Input 2", "Aux 3", SignalState{auxDef, true, true}, SignalState{-6, true, true}}}
+	if got := DiffStates(newModelVenue(t).State(), v.State()); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffStates() = %v, want %v", got, want)
+	}
+	if got, want := v.SelectedInput(), signals.SignalNo(0); got != want {
+		t.Errorf("selected input = %d, want %d", got, want)
+	}
+}
+
+func TestStateHandler(t *testing.T) {
+	v := newModelVenue(t)
+	v.selectInput(2) // Selected on VENUE.
+	srv := httptest.NewServer(v.StateHandler())
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		desc   string
+		method string
+		body   string
+		status int
+	}{
+		{"load", http.MethodPut, `{"version": 1, "selected": 4, "inputs": [{"input": 3, "prop": {"Gain": {"value": 30}}}]}`, http.StatusOK},
+		{"load invalid", http.MethodPut, `{"version": 2}`, http.StatusBadRequest},
+		{"save", http.MethodGet, "", http.StatusOK},
+		{"method", http.MethodPost, "", http.StatusMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(tt.method, srv.URL, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Errorf("%s: status = %d, want %d; %s", tt.desc, got, want, body)
+			continue
+		}
+		if tt.method != http.MethodGet {
+			continue
+		}
+		st, err := ParseState(bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := st.Selected, signals.SignalNo(2); got != want {
+			t.Errorf("%s: saved selected input = %d, want %d", tt.desc, got, want)
+		}
+		if got, want := st.Inputs[2].Prop["Gain"].Value, StateValue(30); got != want {
+			t.Errorf("%s: saved input 3 gain = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	from := &State{
+		Version: stateVersion,
+		Inputs: []InputState{
+			{Input: 1, Prop: map[string]SignalState{"Gain": {Value: 20}, "Mute": {}}},
+			{Input: 2, Prop: map[string]SignalState{"Gain": {Value: 20}}},
+		},
+		Outputs: []OutputState{{"Aux 1", map[string]SignalState{"Mute": {Value: 0, Enabled: true}}}},
+	}
+	to := &State{
+		Version: stateVersion,
+		Inputs: []InputState{
+			{Input: 1, Prop: map[string]SignalState{"Gain": {Value: 24}, "Mute": {Enabled: true}, "Pad": {}}},
+			{Input: 3, Prop: map[string]SignalState{"Gain": {Value: 10}}},
+		},
+		Outputs: []OutputState{{"Aux 1", map[string]SignalState{"Mute": {Value: 0, Enabled: true, Estimated: true}}}},
+	}
+	want := []StateDiff{
+		{"Input 1", "Gain", SignalState{Value: 20}, SignalState{Value: 24}},
+		{"Input 1", "Mute", SignalState{}, SignalState{Enabled: true}},
+	}
+	if got := DiffStates(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffStates() = %v, want %v", got, want)
+	}
+	if got, want := want[0].String(), "Input 1 Gain: 20 (off) -> 24 (off)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// newModelVenue returns an unconnected Venue with a reset console model.
+func newModelVenue(t *testing.T) *Venue {
+	t.Helper()
+	v, err := New()
+	if err != nil {
+		t.Fatalf("New() unexpected error; %s", err)
+	}
+	return v
+}
@@ -3,14 +3,17 @@ package venue
 import (
 	"fmt"
 	"image"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kward/go-vnc/buttons"
 	"github.com/kward/go-vnc/keys"
 	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/buses"
 	"github.com/kward/venue/internal/codes"
 	"github.com/kward/venue/internal/math"
-	"github.com/kward/venue/internal/router/controls"
 	"github.com/kward/venue/internal/venuelib"
 	"github.com/kward/venue/venue/encoders"
 	"github.com/kward/venue/venue/meters"
@@ -21,18 +24,90 @@ import (
 // UI holds references to the Venue UI pages.
 // TODO(kward:20170201) Can I get rid of the UI struct?
 type UI struct {
-	pages Pages
+	pages  Pages
+	verify time.Duration // Time allowed for a selected page to display; zero to not wait.
+
+	mu     sync.Mutex       // Protects the cached UI state below, and the switch states.
+	page   pages.Page       // Last selected page.
+	known  bool             // True if page is known.
+	stale  bool             // True if the displayed UI cannot be trusted.
+	onPage func(pages.Page) // Called when the selected page changes; may be nil.
 }
 
-// NewUI returns a populated UI struct.
-func NewUI() *UI {
-	return &UI{Pages{
-		pages.Inputs:  NewInputsPage(),
-		pages.Outputs: NewOutputsPage(),
-	}}
+// NewUI returns a UI struct populated from layout l, with the bus widgets named
+// after the buses of configuration c.
+func NewUI(l *Layout, c buses.Config) (*UI, error) {
+	ps, err := l.NewPages()
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.assignBuses(c); err != nil {
+		return nil, err
+	}
+	return &UI{pages: ps}, nil
+}
+
+// lastPage returns the last selected page, if it is known.
+func (ui *UI) lastPage() (pages.Page, bool) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.page, ui.known
+}
+
+// setPage records page p as selected.
+func (ui *UI) setPage(p pages.Page) {
+	ui.mu.Lock()
+	changed := !ui.known || ui.page != p
+	ui.page, ui.known, ui.stale = p, true, false
+	fn := ui.onPage
+	ui.mu.Unlock()
+	if changed && fn != nil {
+		fn(p)
+	}
+}
+
+// invalidate marks the cached UI state as unknown, e.g. after a workflow
+// failed partway through. The next page selection then navigates from scratch
+// rather than trusting the displayed UI.
+func (ui *UI) invalidate() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.known, ui.stale = false, true
+}
+
+// setGlyphs sets the glyphs that the encoders read their values with.
+func (ui *UI) setGlyphs(gs Glyphs) {
+	for _, p := range ui.pages {
+		for _, w := range p.widgets {
+			if e, ok := w.(*Encoder); ok {
+				e.glyphs = gs
+			}
+		}
+	}
+}
+
+// recordSwitch records state `on` of switch w.
+func (ui *UI) recordSwitch(w *Switch, on bool) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	w.isEnabled = on
+}
+
+// switchEnabled returns the recorded state of switch w.
+func (ui *UI) switchEnabled(w *Switch) bool {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return w.isEnabled
 }
 
-// selectPage changes the VENUE page.
+func (ui *UI) isStale() bool {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.stale
+}
+
+// selectPage adds the events changing the VENUE page to p. The displayed page
+// is only read as the workflow executes, after the events preceding it.
 func (ui *UI) selectPage(wf *vnc.Workflow, p pages.Page) (*Page, error) {
 	if glog.V(3) {
 		glog.Info(venuelib.FnName())
@@ -41,14 +116,70 @@ func (ui *UI) selectPage(wf *vnc.Workflow, p pages.Page) (*Page, error) {
 	if !ok {
 		return nil, venuelib.Errorf(codes.Unimplemented, "support for %q page unimplemented", p)
 	}
+	// Without a page to change, no keys are pressed, so that a High priority
+	// workflow on the displayed page can preempt another one.
+	keyFree := func() bool { return ui.displayed(wf, p) }
+	wf.ExpandKeyFree(fmt.Sprintf("select the %s page", p), keyFree, func(x *vnc.Workflow) error {
+		_, err := ui.navigate(x, p)
+		return err
+	})
+	return w, nil
+}
+
+// navigate adds the events changing the displayed VENUE page to p, and returns
+// true if there were any. The page is left alone should it be displayed
+// already, unless the UI state is stale. The INPUTS page is also reselected
+// unless it was selected here, to be sure that it shows inputs bank 1-48.
+func (ui *UI) navigate(wf *vnc.Workflow, p pages.Page) (bool, error) {
+	if ui.displayed(wf, p) {
+		if glog.V(2) {
+			glog.Infof("The %s page is already selected.", p)
+		}
+		ui.setPage(p)
+		return false, nil
+	}
 	if p == pages.Inputs {
 		// To ensure we start on inputs bank 1-48, select another page first.
 		wf.KeyPress(keys.F2) // OUTPUTS
+		ui.waitForPage(wf, pages.Outputs)
 	}
-	if err := w.Press(wf); err != nil {
-		return nil, err
+	if err := ui.pages[p].Press(wf); err != nil {
+		return false, err
 	}
-	return w, nil
+	ui.waitForPage(wf, p)
+	ui.setPage(p)
+	return true, nil
+}
+
+// displayed returns true if page p is displayed on the framebuffer of workflow
+// wf, and need not be reselected.
+func (ui *UI) displayed(wf *vnc.Workflow, p pages.Page) bool {
+	if last, known := ui.lastPage(); ui.isStale() || p == pages.Inputs && (!known || last != p) {
+		return false
+	}
+	s, err := framebuffer(wf)
+	if err != nil {
+		return false
+	}
+	curr, err := readPage(s)
+	return err == nil && curr == p
+}
+
+// waitForPage adds a wait for page p to be displayed, should the UI verify its
+// page changes.
+func (ui *UI) waitForPage(wf *vnc.Workflow, p pages.Page) {
+	if ui.verify <= 0 {
+		return
+	}
+	var tabs image.Rectangle
+	for _, r := range pageTabs {
+		tabs = tabs.Union(r)
+	}
+	tf := wf.Transform()
+	wf.WaitFor(fmt.Sprintf("the %s page", p), tabs, func(s *vnc.Snapshot) bool {
+		curr, err := readPage(sample(s, tf))
+		return err == nil && curr == p
+	}, ui.verify)
 }
 
 // The Widget interface provides functionality for interacting with VNC widgets.
@@ -67,10 +198,14 @@ type Widgets map[string]Widget
 //-----------------------------------------------------------------------------
 // Encoder
 
+// encoderWindow holds the dimensions of an encoder value window.
+var encoderWindow = image.Point{48, 11}
+
 type Encoder struct {
 	center   image.Point
 	window   encoders.Encoder // Position of value window
 	hasOnOff bool             // Has an on/off switch
+	glyphs   Glyphs           // Glyphs to read the value window with.
 }
 
 // Verify that the expected interface is implemented properly.
@@ -82,9 +217,29 @@ func (w *Encoder) Press(wf *vnc.Workflow) error {
 	return nil
 }
 
-// Read implements the Widget interface.
+// Read implements the Widget interface. It returns the EncoderValue displayed
+// in the value window of the encoder.
 func (w *Encoder) Read(wf *vnc.Workflow) (interface{}, error) {
-	return nil, venuelib.Errorf(codes.Unimplemented, "Encoder.Read() unimplemented")
+	fb, err := framebuffer(wf)
+	if err != nil {
+		return nil, err
+	}
+	return w.read(fb)
+}
+
+// read recognizes the text of the encoder value window.
+func (w *Encoder) read(s sampler) (EncoderValue, error) {
+	if len(w.glyphs) == 0 {
+		return EncoderValue{}, venuelib.Errorf(codes.FailedPrecondition, "no glyphs to read encoder values with")
+	}
+	txt, err := readText(s, w.windowRect(), w.glyphs)
+	if err != nil {
+		return EncoderValue{}, err
+	}
+	if txt == "" {
+		return EncoderValue{}, venuelib.Errorf(codes.NotFound, "encoder value window is blank")
+	}
+	return parseValue(txt)
 }
 
 // Update implements the Widget interface.
@@ -131,6 +286,18 @@ func (w *Encoder) Increment(wf *vnc.Workflow) error { return w.Adjust(wf, 1) }
 // Decrement the value of an encoder.
 func (w *Encoder) Decrement(wf *vnc.Workflow) error { return w.Adjust(wf, -1) }
 
+// windowRect returns the rectangle of the encoder value window.
+func (w *Encoder) windowRect() image.Rectangle {
+	p := w.clickPoint().Sub(encoderWindow.Div(2))
+	return image.Rectangle{p, p.Add(encoderWindow)}
+}
+
+// bounds returns the rectangle covered by the encoder value window.
+func (w *Encoder) bounds() image.Rectangle { return w.windowRect() }
+
+// moveBy moves the encoder by d.
+func (w *Encoder) moveBy(d image.Point) { w.center = w.center.Add(d) }
+
 // clickPoint returns the point to click based on the window of the encoder.
 func (w *Encoder) clickPoint() image.Point {
 	var dx, dy int
@@ -163,6 +330,11 @@ func (w *Encoder) clickPoint() image.Point {
 //-----------------------------------------------------------------------------
 // Meter
 
+// meterDims holds the dimensions of each meter size.
+var meterDims = map[meters.Meter]image.Point{
+	meters.SmallVertical: {13, 50},
+}
+
 type Meter struct {
 	pos      image.Point  // Position of UI element.
 	size     meters.Meter // Meter size (small..large).
@@ -174,13 +346,41 @@ var _ Widget = new(Meter)
 
 // Press implements the Widget interface.
 func (w *Meter) Press(wf *vnc.Workflow) error {
-	wf.MouseClick(buttons.Left, w.clickOffset())
+	wf.MouseClick(buttons.Left, w.clickPoint())
 	return nil
 }
 
-// Read implements the Widget interface.
+// Read implements the Widget interface. It returns the approximate level of
+// each meter channel in dBFS as a []float64, with one value for a mono meter
+// and two (left, right) for a stereo meter. A meter without any lit segments
+// reads as -Inf.
 func (w *Meter) Read(wf *vnc.Workflow) (interface{}, error) {
-	return nil, venuelib.Errorf(codes.Unimplemented, "Meter.Read() unimplemented")
+	fb, err := framebuffer(wf)
+	if err != nil {
+		return nil, err
+	}
+	return w.read(fb)
+}
+
+// read samples the lit segment height of each meter channel.
+func (w *Meter) read(s sampler) ([]float64, error) {
+	dims, ok := meterDims[w.size]
+	if !ok {
+		return nil, venuelib.Errorf(codes.Unimplemented, "reading of %s meters unimplemented", w.size)
+	}
+
+	// The mono meter column is centered; stereo columns are a quarter in from
+	// either side.
+	cols := []int{dims.X / 2}
+	if w.isStereo {
+		cols = []int{dims.X / 4, dims.X - 1 - dims.X/4}
+	}
+	levels := make([]float64, len(cols))
+	for i, dx := range cols {
+		h := litHeight(s, w.pos.X+dx, w.pos.Y, w.pos.Y+dims.Y)
+		levels[i] = meterLevel(float64(h) / float64(dims.Y))
+	}
+	return levels, nil
 }
 
 // Update implements the Widget interface.
@@ -194,8 +394,17 @@ func (w *Meter) IsMono() bool { return !w.isStereo }
 // IsMono returns true if this a stereo meter.
 func (w *Meter) IsStereo() bool { return w.isStereo }
 
+// bounds returns the rectangle covered by the meter. It is empty for meter
+// sizes with unknown dimensions.
+func (w *Meter) bounds() image.Rectangle {
+	return image.Rectangle{w.pos, w.pos.Add(meterDims[w.size])}
+}
+
+// moveBy moves the meter by d.
+func (w *Meter) moveBy(d image.Point) { w.pos = w.pos.Add(d) }
+
 // clickPoint returns the point to click based on the size of the meter.
-func (w *Meter) clickOffset() image.Point {
+func (w *Meter) clickPoint() image.Point {
 	switch w.size {
 	case meters.SmallVertical:
 		return w.pos.Add(image.Point{7, 25})
@@ -210,6 +419,30 @@ func (w *Meter) clickOffset() image.Point {
 //-----------------------------------------------------------------------------
 // Switch
 
+// switchDims holds the dimensions of each switch size.
+var switchDims = map[switches.Size]image.Point{
+	switches.Tiny:   {13, 13},
+	switches.Small:  {18, 14},
+	switches.Medium: {26, 16},
+	switches.Large:  {32, 18},
+}
+
+// switchThreshold describes when the LED indicator of a switch is lit. A switch
+// is lit when at least `fraction` of its pixels have a luma of `luma` or more.
+type switchThreshold struct {
+	luma     int
+	fraction float64
+}
+
+// switchThresholds holds the LED thresholds of each switch size. The smaller
+// switches have proportionally more border, so they need fewer lit pixels.
+var switchThresholds = map[switches.Size]switchThreshold{
+	switches.Tiny:   {luma: 128, fraction: 0.30},
+	switches.Small:  {luma: 128, fraction: 0.35},
+	switches.Medium: {luma: 140, fraction: 0.40},
+	switches.Large:  {luma: 140, fraction: 0.40},
+}
+
 // Switch is a UIElement representing a switch.
 type Switch struct {
 	pos        image.Point   // Position of UI element.
@@ -224,32 +457,64 @@ var _ Widget = new(Switch)
 
 // Press implements the Widget interface.
 func (w *Switch) Press(wf *vnc.Workflow) error {
-	wf.MouseClick(buttons.Left, w.clickOffset())
+	wf.MouseClick(buttons.Left, w.clickPoint())
 	return nil
 }
 
-// Read implements the Widget interface.
+// Read implements the Widget interface. It returns true if the LED indicator
+// of the switch is lit.
 func (w *Switch) Read(wf *vnc.Workflow) (interface{}, error) {
-	return nil, venuelib.Errorf(codes.Unimplemented, "Switch.Read() unimplemented")
+	fb, err := framebuffer(wf)
+	if err != nil {
+		return nil, err
+	}
+	on, err := w.read(fb)
+	if err != nil {
+		return nil, err
+	}
+	return on, nil
 }
 
-// Update implements the Widget interface.
+// read samples the LED indicator of the switch.
+func (w *Switch) read(s sampler) (bool, error) {
+	th, ok := switchThresholds[w.size]
+	if !ok {
+		return false, venuelib.Errorf(codes.Unimplemented, "reading of %s switches unimplemented", w.size)
+	}
+	// Inset the bounds so that the switch border is not sampled.
+	r := w.bounds().Inset(2)
+	return litFraction(s, r, th.luma) >= th.fraction, nil
+}
+
+// Update implements the Widget interface. The switch is read as the workflow
+// executes, and pressed only if its state differs. The new state is not
+// recorded; see UI.recordSwitch.
 func (w *Switch) Update(wf *vnc.Workflow, val interface{}) error {
 	if w.IsPushButton() {
 		return venuelib.Errorf(codes.InvalidArgument, "switches cannot be updated")
 	}
-
-	val, err := w.Read(wf)
-	if err != nil {
-		return err
-	}
-	if w.isEnabled != val.(bool) {
-		return w.Press(wf)
+	want, ok := val.(bool)
+	if !ok {
+		return venuelib.Errorf(codes.InvalidArgument, "invalid switch value %v", val)
 	}
+
+	// Switches are clicked, so no keys are pressed.
+	keyFree := func() bool { return true }
+	wf.ExpandKeyFree(fmt.Sprintf("update the switch at %s to %t", w.pos, want), keyFree, func(x *vnc.Workflow) error {
+		got, err := w.Read(x)
+		if err != nil {
+			return err
+		}
+		if got.(bool) != want {
+			return w.Press(x)
+		}
+		return nil
+	})
 	return nil
 }
 
-// IsEnabled returns true if the switch is enabled.
+// IsEnabled returns true if the switch is enabled. Switches shared between
+// workflows are read through UI.switchEnabled instead.
 func (w *Switch) IsEnabled() bool { return w.isEnabled }
 
 // NewPushButton returns a new push-button switch. x and y refer to the
@@ -280,7 +545,16 @@ func NewToggle(x, y int, size switches.Size, def bool) *Switch {
 // IsToggle returns true if this is a toggle switch.
 func (w *Switch) IsToggle() bool { return w.kind == switches.Toggle }
 
-func (e *Switch) clickOffset() image.Point {
+// bounds returns the rectangle covered by the switch.
+func (w *Switch) bounds() image.Rectangle {
+	return image.Rectangle{w.pos, w.pos.Add(switchDims[w.size])}
+}
+
+// moveBy moves the switch by d.
+func (w *Switch) moveBy(d image.Point) { w.pos = w.pos.Add(d) }
+
+// clickPoint returns the point to click based on the size of the switch.
+func (e *Switch) clickPoint() image.Point {
 	switch e.size {
 	case switches.Tiny:
 		return e.pos.Add(image.Point{7, 7})
@@ -294,6 +568,105 @@ func (e *Switch) clickOffset() image.Point {
 	return e.pos
 }
 
+//-----------------------------------------------------------------------------
+// Screen
+
+// Widget positions are given for VENUE running at the reference screen size.
+const (
+	screenWidth  = 1024
+	screenHeight = 768
+)
+
+var screenSize = image.Point{screenWidth, screenHeight}
+
+// checkScreen verifies that a framebuffer of size fb is able to display the
+// VENUE UI. Larger framebuffers are supported by scaling the widget positions,
+// but smaller ones would render the widgets too small to be read reliably.
+func checkScreen(fb image.Point) error {
+	if fb.X < screenWidth || fb.Y < screenHeight {
+		return venuelib.Errorf(codes.FailedPrecondition, "unsupported framebuffer resolution %dx%d; at least %dx%d is required", fb.X, fb.Y, screenWidth, screenHeight)
+	}
+	return nil
+}
+
+// screenTransform returns the transform mapping widget positions onto a
+// framebuffer of size fb. The VENUE UI is scaled to fit the framebuffer, and
+// centered within it.
+func screenTransform(fb image.Point) vnc.Transform {
+	return vnc.FitTransform(screenSize, fb)
+}
+
+// clusterGap is the distance in pixels under which widgets share a region.
+const clusterGap = 40
+
+// regions returns the framebuffer regions read from the UI, mapped by transform
+// tf: the page tabs, and the widgets of the displayed page, with the channel
+// name of the INPUTS page. Nearby widgets share a region, as every region is
+// requested from the server each period. Regions are refreshed every period p,
+// apart from those of the meters, which are refreshed every period `meter`.
+// Should the displayed page be unknown, the widgets of every page are read.
+func (ui *UI) regions(tf vnc.Transform, p, meter time.Duration) []vnc.Region {
+	var tabs image.Rectangle
+	for _, r := range pageTabs {
+		tabs = tabs.Union(r)
+	}
+	ps := ui.pages
+	if curr, ok := ui.lastPage(); ok {
+		ps = Pages{curr: ui.pages[curr]}
+	}
+	var widgets, meters []image.Rectangle
+	for n, pg := range ps {
+		if n == pages.Inputs {
+			widgets = append(widgets, channelName)
+		}
+		for _, w := range pg.widgets {
+			t, ok := w.(target)
+			if !ok {
+				continue
+			}
+			if _, ok := t.(*Meter); ok {
+				meters = append(meters, t.bounds())
+			} else {
+				widgets = append(widgets, t.bounds())
+			}
+		}
+	}
+	rs := []vnc.Region{{Rect: tf.Rect(tabs), Period: p}}
+	for _, r := range cluster(widgets) {
+		rs = append(rs, vnc.Region{Rect: tf.Rect(r), Period: p})
+	}
+	for _, r := range cluster(meters) {
+		rs = append(rs, vnc.Region{Rect: tf.Rect(r), Period: min(meter, p)})
+	}
+	return rs
+}
+
+// cluster merges the rectangles of rs lying within clusterGap of each other.
+// The clusters are ordered top to bottom, then left to right.
+func cluster(rs []image.Rectangle) []image.Rectangle {
+	cs := append([]image.Rectangle(nil), rs...)
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(cs); i++ {
+			for j := i + 1; j < len(cs); j++ {
+				if cs[i].Inset(-clusterGap / 2).Overlaps(cs[j].Inset(-clusterGap / 2)) {
+					cs[i] = cs[i].Union(cs[j])
+					cs = append(cs[:j], cs[j+1:]...)
+					merged = true
+					j--
+				}
+			}
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Min.Y != cs[j].Min.Y {
+			return cs[i].Min.Y < cs[j].Min.Y
+		}
+		return cs[i].Min.X < cs[j].Min.X
+	})
+	return cs
+}
+
 //-----------------------------------------------------------------------------
 // Page
 
@@ -305,10 +678,34 @@ type Page struct {
 
 type Pages map[pages.Page]*Page
 
+const (
+	pageTabY        = 2   // Y position of the page tabs.
+	pageTabHeight   = 18  // Height of a page tab.
+	pageTabLuma     = 150 // Minimum luma of a highlighted page tab pixel.
+	pageTabFraction = 0.5 // Fraction of pixels lit on a highlighted page tab.
+)
+
+// pageTabs holds the position of the tab for each page. The tab of the
+// displayed page is highlighted.
+var pageTabs = map[pages.Page]image.Rectangle{
+	pages.Inputs:    image.Rect(2, pageTabY, 84, pageTabY+pageTabHeight),
+	pages.Outputs:   image.Rect(86, pageTabY, 168, pageTabY+pageTabHeight),
+	pages.Filing:    image.Rect(170, pageTabY, 252, pageTabY+pageTabHeight),
+	pages.Snapshots: image.Rect(254, pageTabY, 336, pageTabY+pageTabHeight),
+	pages.Patchbay:  image.Rect(338, pageTabY, 420, pageTabY+pageTabHeight),
+	pages.Plugins:   image.Rect(422, pageTabY, 504, pageTabY+pageTabHeight),
+	pages.Options:   image.Rect(506, pageTabY, 588, pageTabY+pageTabHeight),
+}
+
+// channelName is the position of the name of the selected channel, displayed
+// on the INPUTS page. It changes once a typed channel number is selected.
+var channelName = image.Rect(4, 26, 150, 44)
+
 // Verify that the expected interface is implemented properly.
 var _ Widget = new(Page)
 
-// Press implements the Widget interface.
+// Press implements the Widget interface. Only pages with a function key can be
+// pressed.
 func (w *Page) Press(wf *vnc.Workflow) error {
 	var key keys.Key
 	switch w.page {
@@ -316,150 +713,49 @@ func (w *Page) Press(wf *vnc.Workflow) error {
 		key = keys.F1
 	case pages.Outputs:
 		key = keys.F2
+	default:
+		return venuelib.Errorf(codes.Unimplemented, "no key selects the %s page", w.page)
 	}
 	wf.KeyPress(key)
 	return nil
 }
 
-// Read implements the Widget interface.
+// Read implements the Widget interface. It returns the pages.Page currently
+// displayed by VENUE, which need not be this page.
 func (w *Page) Read(wf *vnc.Workflow) (interface{}, error) {
-	return nil, venuelib.Errorf(codes.Unimplemented, "Page.Read() unimplemented")
-}
-
-// Update implements the Widget interface.
-func (w *Page) Update(wf *vnc.Workflow, val interface{}) error {
-	return venuelib.Errorf(codes.Unimplemented, "Page.Update() unimplemented")
+	fb, err := framebuffer(wf)
+	if err != nil {
+		return nil, err
+	}
+	return readPage(fb)
 }
 
-const (
-	bankX  = 8   // X position of 1st bank.
-	bankDX = 131 // dX between banks.
-	chanDX = 15  // dX between channels in a bank.
-
-	// Inputs
-	auxOddX  = 316
-	auxPanX  = 473
-	aux12Y   = 95
-	aux34Y   = 146
-	aux56Y   = 197
-	aux78Y   = 248
-	aux910Y  = 299
-	aux1112Y = 350
-	aux1314Y = 401
-	aux1516Y = 452
-
-	// Outputs
-	meterY = 512
-	muteY  = 588
-	soloY  = 573
-)
-
-// NewInputsPage returns a populated Inputs page.
-func NewInputsPage() *Page {
-	return &Page{
-		pages.Inputs,
-		Widgets{
-			// Input
-			"Phantom": NewToggle(153, 171, switches.Medium, switches.Disabled),
-			"Pad":     NewToggle(153, 196, switches.Medium, switches.Disabled),
-			"Guess":   NewPushButton(153, 221, switches.Medium),
-			"Gain":    &Encoder{image.Point{167, 279}, encoders.BottomLeft, true},
-			"Phase":   NewToggle(12, 420, switches.Medium, switches.Disabled),
-			"Solo":    NewToggle(12, 451, switches.Large, switches.Disabled),
-			"Mute":    NewToggle(62, 451, switches.Large, switches.Disabled),
-			"Delay":   &Encoder{image.Point{168, 387}, encoders.BottomLeft, false},
-			"HPF":     &Encoder{image.Point{168, 454}, encoders.BottomLeft, true},
-			// Bus Assign
-			"VarGroups": NewPushButton(226, 299, switches.Medium),
-			// Pan
-			"Pan": &Encoder{image.Point{239, 443}, encoders.BottomCenter, false},
-			//-- RightOffset
-			//-- Balance
-			// Aux Sends
-			"Aux 1":        &Encoder{image.Point{auxOddX, aux12Y}, encoders.TopRight, true},
-			"AuxPan 1/2":   &Encoder{image.Point{auxPanX, aux12Y}, encoders.TopLeft, false},
-			"Aux 3":        &Encoder{image.Point{auxOddX, aux34Y}, encoders.TopRight, true},
-			"AuxPan 3/4":   &Encoder{image.Point{auxPanX, aux34Y}, encoders.TopLeft, false},
-			"Aux 5":        &Encoder{image.Point{auxOddX, aux56Y}, encoders.TopRight, true},
-			"AuxPan 5/6":   &Encoder{image.Point{auxPanX, aux56Y}, encoders.TopLeft, false},
-			"Aux 7":        &Encoder{image.Point{auxOddX, aux78Y}, encoders.TopRight, true},
-			"AuxPan 7/8":   &Encoder{image.Point{auxPanX, aux78Y}, encoders.TopLeft, false},
-			"Aux 9":        &Encoder{image.Point{auxOddX, aux910Y}, encoders.TopRight, true},
-			"AuxPan 9/10":  &Encoder{image.Point{auxPanX, aux910Y}, encoders.TopLeft, false},
-			"Aux 11":       &Encoder{image.Point{auxOddX, aux1112Y}, encoders.TopRight, true},
-			"AuxPan 11/12": &Encoder{image.Point{auxPanX, aux1112Y}, encoders.TopLeft, false},
-			"Aux 13":       &Encoder{image.Point{auxOddX, aux1314Y}, encoders.TopRight, true},
-			"AuxPan 13/14": &Encoder{image.Point{auxPanX, aux1314Y}, encoders.TopLeft, false},
-			"Aux 15":       &Encoder{image.Point{auxOddX, aux1516Y}, encoders.TopRight, true},
-			"AuxPan 15/16": &Encoder{image.Point{auxPanX, aux1516Y}, encoders.TopLeft, false},
-			"Group 1":      &Encoder{image.Point{auxOddX, aux12Y}, encoders.TopRight, true},
-			"GroupPan 1/2": &Encoder{image.Point{auxPanX, aux12Y}, encoders.TopLeft, false},
-			"Group 3":      &Encoder{image.Point{auxOddX, aux34Y}, encoders.TopRight, true},
-			"GroupPan 3/4": &Encoder{image.Point{auxPanX, aux34Y}, encoders.TopLeft, false},
-			"Group 5":      &Encoder{image.Point{auxOddX, aux56Y}, encoders.TopRight, true},
-			"GroupPan 5/6": &Encoder{image.Point{auxPanX, aux56Y}, encoders.TopLeft, false},
-			"Group 7":      &Encoder{image.Point{auxOddX, aux78Y}, encoders.TopRight, true},
-			"GroupPan 7/8": &Encoder{image.Point{auxPanX, aux78Y}, encoders.TopLeft, false},
-			// EQ
-			// Comp/Lim
-			// Exp/Gate
-			// Misc
-			"SoloClear": NewPushButton(979, 493, switches.Medium),
-		}}
-}
-
-// NewOutputsPage returns a populated Outputs page.
-func NewOutputsPage() *Page {
-	widgets := Widgets{
-		"SoloClear": NewPushButton(980, 490, switches.Medium),
-	}
-
-	// Auxes
-	for _, b := range []int{1, 2} { // Bank.
-		pre := controls.Aux.String()
-		for c := 1; c <= 8; c++ { // Bank channel.
-			ch, x := (b-1)*8+c, bankX+(b-1)*bankDX+(c-1)*chanDX
-
-			n := fmt.Sprintf("%s %d Solo", pre, ch)
-			if glog.V(4) {
-				glog.Infof("NewOutput() element[%v]:", n)
-			}
-			widgets[n] = NewToggle(x, soloY, switches.Tiny, false)
-
-			n = fmt.Sprintf("%s %d Value", pre, ch)
-			if glog.V(4) {
-				glog.Infof("NewOutput() element[%v]:", n)
-			}
-			widgets[n] = &Meter{
-				pos:  image.Point{x, meterY},
-				size: meters.SmallVertical,
-			}
+// readPage identifies the displayed page from the highlighted page tab.
+func readPage(s sampler) (pages.Page, error) {
+	var (
+		page pages.Page
+		lit  int
+	)
+	for p, r := range pageTabs {
+		if litFraction(s, r.Inset(2), pageTabLuma) < pageTabFraction {
+			continue
 		}
+		page = p
+		lit++
 	}
-
-	// Groups
-	b := 5 // bank
-	pre := controls.Group.String()
-	for c := 1; c <= 8; c++ { // bank channel
-		ch, x := c, bankX+(b-1)*bankDX+(c-1)*chanDX
-
-		n := fmt.Sprintf("%s %d Solo", pre, ch)
-		if glog.V(4) {
-			glog.Infof("NewOutput() element[%v]:", n)
-		}
-		widgets[n] = NewToggle(x, soloY, switches.Tiny, false)
-
-		n = fmt.Sprintf("%s %d Meter", pre, ch)
-		if glog.V(4) {
-			glog.Infof("NewOutput() element[%v]:", n)
-		}
-		widgets[n] = &Meter{
-			pos:  image.Point{x, meterY},
-			size: meters.SmallVertical,
-		}
+	switch lit {
+	case 0:
+		return page, venuelib.Errorf(codes.NotFound, "no page tab is highlighted")
+	case 1:
+		return page, nil
+	default:
+		return page, venuelib.Errorf(codes.FailedPrecondition, "%d page tabs are highlighted", lit)
 	}
+}
 
-	return &Page{pages.Outputs, widgets}
+// Update implements the Widget interface.
+func (w *Page) Update(wf *vnc.Workflow, val interface{}) error {
+	return venuelib.Errorf(codes.Unimplemented, "Page.Update() unimplemented")
 }
 
 // Widget returns the named widget.
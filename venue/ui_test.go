@@ -0,0 +1,585 @@
+package venue
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	vnclib "github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/rfbflags"
+	"github.com/kward/venue/api/vnc"
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+	"github.com/kward/venue/venue/meters"
+	"github.com/kward/venue/venue/pages"
+	"github.com/kward/venue/venue/switches"
+)
+
+var (
+	background = color.RGBA{40, 40, 40, 255}
+	ledOn      = color.RGBA{250, 210, 30, 255}
+)
+
+func TestSwitchRead(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		size switches.Size
+		lit  image.Rectangle // Relative to the switch position.
+		want bool
+	}{
+		{"tiny off", switches.Tiny, image.Rectangle{}, false},
+		{"tiny on", switches.Tiny, image.Rect(0, 0, 13, 13), true},
+		{"medium off", switches.Medium, image.Rectangle{}, false},
+		{"medium on", switches.Medium, image.Rect(0, 0, 26, 16), true},
+		{"medium border only", switches.Medium, image.Rect(0, 0, 26, 2), false},
+		{"large on", switches.Large, image.Rect(4, 4, 28, 14), true},
+	} {
+		w := NewToggle(100, 50, tt.size, false)
+		img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+		draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		draw.Draw(img, tt.lit.Add(w.pos), image.NewUniform(ledOn), image.Point{}, draw.Src)
+
+		got, err := w.read(img)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: read() = %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchUpdate(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		lit     bool
+		val     bool
+		clicked bool
+	}{
+		{"off to on", false, true, true},
+		{"on to on", true, true, false},
+		{"on to off", true, false, true},
+		{"off to off", false, false, false},
+	} {
+		w := NewToggle(10, 10, switches.Medium, false)
+		fb := vnc.NewFramebuffer(100, 100)
+		fill(fb, image.Rect(0, 0, 100, 100), background)
+		conn := &mockConn{}
+		wf := vnc.NewWorkflow(conn)
+		wf.SetFramebuffer(fb)
+
+		if err := w.Update(wf, tt.val); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		// The switch is read as the workflow executes.
+		if tt.lit {
+			fill(fb, w.bounds(), ledOn)
+		}
+		if err := wf.Execute(); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := len(conn.pointer) > 0, tt.clicked; got != want {
+			t.Errorf("%s: clicked = %t, want %t", tt.desc, got, want)
+		}
+		if got, want := w.IsEnabled(), false; got != want {
+			t.Errorf("%s: IsEnabled() = %t, want %t; Update() records no state", tt.desc, got, want)
+		}
+	}
+}
+
+func TestSwitchUpdatePushButton(t *testing.T) {
+	w := NewPushButton(10, 10, switches.Medium)
+	if err := w.Update(vnc.NewWorkflow(&mockConn{}), true); err == nil {
+		t.Error("expected an error updating a push-button")
+	}
+}
+
+func TestMeterRead(t *testing.T) {
+	green := color.RGBA{40, 220, 40, 255}
+	for _, tt := range []struct {
+		desc     string
+		isStereo bool
+		lit      []image.Rectangle // Relative to the meter position.
+		want     []float64
+	}{
+		{"mono silent", false, nil, []float64{math.Inf(-1)}},
+		{"mono full", false, []image.Rectangle{image.Rect(0, 0, 13, 50)}, []float64{0}},
+		{"mono half", false, []image.Rectangle{image.Rect(0, 25, 13, 50)}, []float64{-18}},
+		{"mono segmented", false, []image.Rectangle{
+			image.Rect(0, 40, 13, 50), image.Rect(0, 30, 13, 38)}, []float64{-24}},
+		{"mono peak hold", false, []image.Rectangle{
+			image.Rect(0, 40, 13, 50), image.Rect(0, 5, 13, 6)}, []float64{-40}},
+		{"stereo", true, []image.Rectangle{
+			image.Rect(0, 10, 6, 50), image.Rect(7, 40, 13, 50)}, []float64{-6, -40}},
+	} {
+		w := &Meter{pos: image.Point{20, 10}, size: meters.SmallVertical, isStereo: tt.isStereo}
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		for _, r := range tt.lit {
+			draw.Draw(img, r.Add(w.pos), image.NewUniform(green), image.Point{}, draw.Src)
+		}
+
+		got, err := w.read(img)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestMeterReadUnimplemented(t *testing.T) {
+	w := &Meter{size: meters.LargeVertical}
+	if _, err := w.read(image.NewRGBA(image.Rect(0, 0, 10, 10))); err == nil {
+		t.Error("expected an error reading a large meter")
+	}
+}
+
+func TestReadPage(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		lit  []pages.Page
+		want pages.Page
+		ok   bool
+	}{
+		{"inputs", []pages.Page{pages.Inputs}, pages.Inputs, true},
+		{"outputs", []pages.Page{pages.Outputs}, pages.Outputs, true},
+		{"options", []pages.Page{pages.Options}, pages.Options, true},
+		{"none", nil, pages.Inputs, false},
+		{"multiple", []pages.Page{pages.Inputs, pages.Outputs}, pages.Inputs, false},
+	} {
+		img := newPageImage(tt.lit...)
+		got, err := readPage(img)
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+		if !tt.ok {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: readPage() = %s, want %s", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSelectPage(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		curr     []pages.Page // Page tabs that are highlighted.
+		selected bool         // The current page was selected by the UI.
+		queued   bool         // Workflow has earlier events.
+		page     pages.Page
+		keys     keys.Keys
+	}{
+		{"inputs to inputs", []pages.Page{pages.Inputs}, true, false, pages.Inputs, nil},
+		{"unknown bank", []pages.Page{pages.Inputs}, false, false, pages.Inputs, keys.Keys{keys.F2, keys.F1}},
+		{"outputs to outputs", []pages.Page{pages.Outputs}, false, false, pages.Outputs, nil},
+		{"outputs to inputs", []pages.Page{pages.Outputs}, false, false, pages.Inputs, keys.Keys{keys.F2, keys.F1}},
+		{"inputs to outputs", []pages.Page{pages.Inputs}, false, false, pages.Outputs, keys.Keys{keys.F2}},
+		{"unknown to inputs", nil, false, false, pages.Inputs, keys.Keys{keys.F2, keys.F1}},
+		{"queued events", []pages.Page{pages.Outputs}, false, true, pages.Outputs, nil},
+	} {
+		fb := vnc.NewFramebuffer(1024, 768)
+		fill(fb, fb.Bounds(), background)
+		for _, p := range tt.curr {
+			fill(fb, pageTabs[p], ledOn)
+		}
+		ui := defaultUI(t)
+		if tt.selected {
+			ui.setPage(tt.curr[0])
+		}
+		conn := &mockConn{}
+		wf := vnc.NewWorkflow(conn)
+		wf.SetFramebuffer(fb)
+		if tt.queued {
+			wf.MouseMove(image.Point{0, 0})
+		}
+
+		if _, err := ui.selectPage(wf, tt.page); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if err := wf.Execute(); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		if got, want := conn.keys, tt.keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys = %v, want %v", tt.desc, got, want)
+		}
+		if p, ok := ui.lastPage(); !ok || p != tt.page {
+			t.Errorf("%s: lastPage() = %s, %t, want %s, true", tt.desc, p, ok, tt.page)
+		}
+	}
+}
+
+func TestPagePress(t *testing.T) {
+	for _, tt := range []struct {
+		page pages.Page
+		keys keys.Keys
+		ok   bool
+	}{
+		{pages.Inputs, keys.Keys{keys.F1}, true},
+		{pages.Outputs, keys.Keys{keys.F2}, true},
+		{pages.Options, nil, false},
+	} {
+		conn := &mockConn{}
+		wf := vnc.NewWorkflow(conn)
+		err := (&Page{page: tt.page}).Press(wf)
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error; %s", tt.page, err)
+			continue
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected an error", tt.page)
+			continue
+		}
+		if err := wf.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.page, err)
+		}
+		if got, want := conn.keys, tt.keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys = %v, want %v", tt.page, got, want)
+		}
+	}
+}
+
+// TestSelectPageQueued checks that the displayed page is read as the workflow
+// executes, rather than as it is built.
+func TestSelectPageQueued(t *testing.T) {
+	fb := vnc.NewFramebuffer(1024, 768)
+	fill(fb, fb.Bounds(), background)
+	fill(fb, pageTabs[pages.Inputs], ledOn)
+	conn := &mockConn{}
+	wf := vnc.NewWorkflow(conn)
+	wf.SetFramebuffer(fb)
+	ui := defaultUI(t)
+	if _, err := ui.selectPage(wf, pages.Outputs); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if _, ok := ui.lastPage(); ok {
+		t.Error("selectPage() changed the page before executing")
+	}
+
+	// The OUTPUTS page is selected before the workflow executes.
+	fill(fb, fb.Bounds(), background)
+	fill(fb, pageTabs[pages.Outputs], ledOn)
+	if err := wf.Execute(); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got := conn.keys; got != nil {
+		t.Errorf("keys = %v, want none", got)
+	}
+}
+
+// TestSelectPageVerify checks that page changes wait for the selected page to
+// be displayed, rather than for any change of the page tabs.
+func TestSelectPageVerify(t *testing.T) {
+	fb := vnc.NewFramebuffer(1024, 768)
+	fill(fb, fb.Bounds(), background)
+	conn := &tabsConn{fb: fb}
+	wf := vnc.NewWorkflow(conn)
+	wf.SetFramebuffer(fb)
+	ui := defaultUI(t)
+	ui.verify = time.Second
+	if _, err := ui.selectPage(wf, pages.Inputs); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if err := wf.Execute(); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := conn.keys, (keys.Keys{keys.F2, keys.F1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	// A page that is never displayed fails the workflow.
+	conn.ignore = true
+	ui.verify = 20 * time.Millisecond
+	wf = vnc.NewWorkflow(conn)
+	wf.SetFramebuffer(fb)
+	if _, err := ui.selectPage(wf, pages.Outputs); err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if err := wf.Execute(); venuelib.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Execute() error = %v, want %s", err, codes.DeadlineExceeded)
+	}
+}
+
+func TestSelectPageStale(t *testing.T) {
+	fb := vnc.NewFramebuffer(1024, 768)
+	fill(fb, fb.Bounds(), background)
+	fill(fb, pageTabs[pages.Inputs], ledOn)
+	ui := defaultUI(t)
+	ui.invalidate()
+
+	for _, tt := range []struct {
+		desc string
+		keys keys.Keys
+	}{
+		{"stale", keys.Keys{keys.F2, keys.F1}},
+		{"renavigated", nil},
+	} {
+		conn := &mockConn{}
+		wf := vnc.NewWorkflow(conn)
+		wf.SetFramebuffer(fb)
+		if _, err := ui.selectPage(wf, pages.Inputs); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if err := wf.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if got, want := conn.keys, tt.keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys = %v, want %v", tt.desc, got, want)
+		}
+		if p, ok := ui.lastPage(); !ok || p != pages.Inputs {
+			t.Errorf("%s: lastPage() = %s, %t, want %s, true", tt.desc, p, ok, pages.Inputs)
+		}
+	}
+}
+
+func TestScreenTransform(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		size image.Point
+		ok   bool
+	}{
+		{"reference", image.Point{1024, 768}, true},
+		{"scaled", image.Point{1280, 1024}, true},
+		{"doubled", image.Point{2048, 1536}, true},
+		{"too small", image.Point{800, 600}, false},
+		{"too short", image.Point{1280, 720}, false},
+	} {
+		err := checkScreen(tt.size)
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("%s: checkScreen() error = %v, want ok %t", tt.desc, err, want)
+		}
+		if !tt.ok {
+			continue
+		}
+
+		tf := screenTransform(tt.size)
+		mute := NewToggle(62, 451, switches.Large, switches.Disabled)
+		fb := vnc.NewFramebuffer(tt.size.X, tt.size.Y)
+		fill(fb, fb.Bounds(), background)
+		fill(fb, tf.Rect(pageTabs[pages.Outputs]), ledOn)
+		fill(fb, tf.Rect(mute.bounds()), ledOn)
+
+		conn := &mockConn{}
+		wf := vnc.NewWorkflow(conn)
+		wf.SetFramebuffer(fb)
+		wf.SetTransform(tf)
+		page, err := (&Page{page: pages.Inputs}).Read(wf)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		} else if got, want := page, pages.Outputs; got != want {
+			t.Errorf("%s: page = %s, want %s", tt.desc, got, want)
+		}
+		on, err := mute.Read(wf)
+		if err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+		} else if !on.(bool) {
+			t.Errorf("%s: mute is not lit", tt.desc)
+		}
+
+		mute.Press(wf)
+		if err := wf.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error; %s", tt.desc, err)
+		}
+		if got, want := conn.pointer, []image.Point{tf.Point(image.Point{78, 460})}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: clicked %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+// defaultUI returns a UI populated from the default layout.
+func TestUIRegions(t *testing.T) {
+	ui := defaultUI(t)
+	meter := image.Rect(8, 512, 21, 562) // Aux 1 Meter.
+	mute := NewToggle(62, 451, switches.Large, switches.Disabled).bounds()
+	for _, tt := range []struct {
+		desc    string
+		page    *pages.Page // Displayed page; nil when unknown.
+		periods map[image.Rectangle]time.Duration
+		unread  []image.Rectangle
+	}{
+		{"unknown page", nil,
+			map[image.Rectangle]time.Duration{meter: 250 * time.Millisecond, mute: time.Second, channelName: time.Second},
+			nil},
+		{"inputs", &[]pages.Page{pages.Inputs}[0],
+			map[image.Rectangle]time.Duration{mute: time.Second, channelName: time.Second},
+			[]image.Rectangle{meter}},
+		{"outputs", &[]pages.Page{pages.Outputs}[0],
+			map[image.Rectangle]time.Duration{meter: 250 * time.Millisecond},
+			[]image.Rectangle{mute, channelName}},
+	} {
+		if tt.page != nil {
+			ui.setPage(*tt.page)
+		} else {
+			ui.invalidate()
+		}
+		rs := ui.regions(vnc.Identity, time.Second, 250*time.Millisecond)
+		if got, want := rs[0], (vnc.Region{Rect: image.Rect(2, 2, 588, 20), Period: time.Second}); got != want {
+			t.Errorf("%s: page tabs region = %v, want %v", tt.desc, got, want)
+		}
+		for r, want := range tt.periods {
+			// The widget is refreshed as often as the fastest region holding it.
+			var got time.Duration
+			for _, rr := range rs {
+				if r.In(rr.Rect) && (got == 0 || rr.Period < got) {
+					got = rr.Period
+				}
+			}
+			if got != want {
+				t.Errorf("%s: region %v period = %s, want %s", tt.desc, r, got, want)
+			}
+		}
+		for _, r := range tt.unread {
+			for _, rr := range rs {
+				if r.Overlaps(rr.Rect) {
+					t.Errorf("%s: region %v is read, within %v", tt.desc, r, rr.Rect)
+				}
+			}
+		}
+		// The regions of a page cover a small part of the screen.
+		if tt.page != nil {
+			area := 0
+			for _, r := range rs {
+				area += r.Rect.Dx() * r.Rect.Dy()
+			}
+			if got, max := float64(area)/float64(screenSize.X*screenSize.Y), 0.1; got > max {
+				t.Errorf("%s: regions cover %.2f of the screen, want at most %.2f", tt.desc, got, max)
+			}
+		}
+	}
+
+	// The meter period only applies to meters.
+	ui.setPage(pages.Outputs)
+	for _, r := range ui.regions(vnc.Identity, time.Second, 250*time.Millisecond) {
+		if r.Period == time.Second {
+			continue
+		}
+		for n, w := range ui.pages[pages.Outputs].widgets {
+			if _, ok := w.(*Meter); ok {
+				continue
+			}
+			if t2, ok := w.(target); ok && t2.bounds().Overlaps(r.Rect) {
+				t.Errorf("%s is refreshed at the meter period, within %v", n, r.Rect)
+			}
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		rs   []image.Rectangle
+		want []image.Rectangle
+	}{
+		{"none", nil, nil},
+		{"apart", []image.Rectangle{image.Rect(100, 0, 110, 10), image.Rect(0, 0, 10, 10)},
+			[]image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(100, 0, 110, 10)}},
+		{"near", []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(20, 0, 30, 10)},
+			[]image.Rectangle{image.Rect(0, 0, 30, 10)}},
+		{"chained", []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(60, 0, 70, 10), image.Rect(30, 0, 40, 10)},
+			[]image.Rectangle{image.Rect(0, 0, 70, 10)}},
+	} {
+		if got := cluster(tt.rs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: cluster() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func defaultUI(t *testing.T) *UI {
+	t.Helper()
+	ui, err := NewUI(DefaultLayout(), buses.Default())
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	return ui
+}
+
+// newPageImage returns a VENUE sized image with the page tabs of `lit`
+// highlighted.
+func newPageImage(lit ...pages.Page) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	for _, p := range lit {
+		draw.Draw(img, pageTabs[p], image.NewUniform(ledOn), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// fill paints rectangle r of the framebuffer with color c, and commits it.
+func fill(fb *vnc.Framebuffer, r image.Rectangle, c color.RGBA) {
+	colors := make([]vnclib.Color, r.Dx()*r.Dy())
+	for i := range colors {
+		colors[i] = vnclib.Color{R: uint16(c.R), G: uint16(c.G), B: uint16(c.B)}
+	}
+	fb.Paint(vnclib.Rectangle{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y),
+		Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+	}, colors)
+	fb.Commit()
+}
+
+//-----------------------------------------------------------------------------
+
+// mockConn implements the vnc.ClientConn interface.
+type mockConn struct {
+	keys    keys.Keys
+	pointer []image.Point
+}
+
+func (c *mockConn) FramebufferHeight() uint16 { return 0 }
+func (c *mockConn) FramebufferWidth() uint16  { return 0 }
+
+func (c *mockConn) KeyEvent(key keys.Key, down bool) error {
+	if down {
+		c.keys = append(c.keys, key)
+	}
+	return nil
+}
+
+func (c *mockConn) PointerEvent(button buttons.Button, x, y uint16) error {
+	if button != buttons.None {
+		c.pointer = append(c.pointer, image.Point{int(x), int(y)})
+	}
+	return nil
+}
+
+func (c *mockConn) Close() error                                                         { return nil }
+func (c *mockConn) DebugMetrics()                                                        {}
+func (c *mockConn) FramebufferUpdateRequest(_ rfbflags.RFBFlag, _, _, _, _ uint16) error { return nil }
+func (c *mockConn) ListenAndHandle() error                                               { return nil }
+
+// tabsConn highlights the page tab of the page keys pressed, as VENUE does,
+// unless told to ignore them.
+type tabsConn struct {
+	mockConn
+	fb     *vnc.Framebuffer
+	ignore bool
+}
+
+func (c *tabsConn) KeyEvent(key keys.Key, down bool) error {
+	c.mockConn.KeyEvent(key, down)
+	p, ok := map[keys.Key]pages.Page{keys.F1: pages.Inputs, keys.F2: pages.Outputs}[key]
+	if !ok || !down || c.ignore {
+		return nil
+	}
+	fill(c.fb, c.fb.Bounds(), background)
+	fill(c.fb, pageTabs[p], ledOn)
+	return nil
+}
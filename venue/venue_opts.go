@@ -1,10 +1,22 @@
 package venue
 
-import "time"
+import (
+	"time"
+
+	"github.com/kward/venue/internal/buses"
+	"github.com/kward/venue/internal/codes"
+	"github.com/kward/venue/internal/venuelib"
+)
 
 type options struct {
-	inputs  uint
-	refresh time.Duration // VNC Framebuffer refresh period
+	inputs     uint
+	refresh    time.Duration // VNC Framebuffer refresh period
+	minBackoff time.Duration // Initial delay between reconnect attempts.
+	maxBackoff time.Duration // Maximum delay between reconnect attempts.
+	layout     *Layout       // Page and widget layout.
+	templates  Templates     // Widget templates to locate widgets with.
+	buses      buses.Config  // Bus configuration.
+	glyphs     Glyphs        // Glyphs to recognize encoder values with.
 }
 
 // Inputs is an option for New() that sets the number of inputs.
@@ -28,3 +40,99 @@ func (o *options) setRefresh(v time.Duration) error {
 	o.refresh = v
 	return nil
 }
+
+// Reconnect is an option for New() that sets the minimum and maximum delays
+// between VNC reconnect attempts. The delay doubles after every failed attempt.
+func Reconnect(min, max time.Duration) func(*options) error {
+	return func(o *options) error { return o.setReconnect(min, max) }
+}
+
+// setReconnect sets the VNC reconnect backoff delays.
+func (o *options) setReconnect(min, max time.Duration) error {
+	if min <= 0 || max < min {
+		return venuelib.Errorf(codes.InvalidArgument, "invalid reconnect backoff %s..%s", min, max)
+	}
+	o.minBackoff, o.maxBackoff = min, max
+	return nil
+}
+
+// LayoutFile is an option for New() that loads the page and widget layout from
+// the file at path. An empty path keeps the default layout.
+func LayoutFile(path string) func(*options) error {
+	return func(o *options) error {
+		if path == "" {
+			return nil
+		}
+		l, err := LoadLayout(path)
+		if err != nil {
+			return err
+		}
+		return o.setLayout(l)
+	}
+}
+
+// setLayout sets the page and widget layout.
+func (o *options) setLayout(l *Layout) error {
+	o.layout = l
+	return nil
+}
+
+// TemplateDir is an option for New() that loads the widget templates in
+// directory dir, so that widgets are located on the screen at initialization.
+// An empty dir locates no widgets.
+func TemplateDir(dir string) func(*options) error {
+	return func(o *options) error {
+		if dir == "" {
+			return nil
+		}
+		ts, err := LoadTemplates(dir)
+		if err != nil {
+			return err
+		}
+		return o.setTemplates(ts)
+	}
+}
+
+// setTemplates sets the widget templates.
+func (o *options) setTemplates(ts Templates) error {
+	o.templates = ts
+	return nil
+}
+
+// Buses is an option for New() that sets the VENUE bus configuration, i.e. the
+// number of aux and group buses, and which are stereo.
+func Buses(c buses.Config) func(*options) error {
+	return func(o *options) error { return o.setBuses(c) }
+}
+
+// setBuses sets the bus configuration.
+func (o *options) setBuses(c buses.Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	o.buses = c
+	return nil
+}
+
+// GlyphDir is an option for New() that loads the glyphs in directory dir, so
+// that the modelled encoder values are confirmed by recognizing the text of
+// their value windows. An empty dir recognizes no values, and only switches are
+// confirmed.
+func GlyphDir(dir string) func(*options) error {
+	return func(o *options) error {
+		if dir == "" {
+			return nil
+		}
+		gs, err := LoadGlyphs(dir)
+		if err != nil {
+			return err
+		}
+		return o.setGlyphs(gs)
+	}
+}
+
+// setGlyphs sets the glyphs to recognize encoder values with.
+func (o *options) setGlyphs(gs Glyphs) error {
+	o.glyphs = gs
+	return nil
+}